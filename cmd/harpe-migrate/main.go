@@ -0,0 +1,96 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/m1k8/harpe/pkg/db"
+)
+
+const usage = `usage: harpe-migrate [flags] <up|down|status>
+
+  up      apply every pending migration
+  down    roll back the last applied group
+  status  list migrations and whether they are applied
+
+Connection settings come from the pg section of the config file, then the
+environment (DATABASE_URL, PGHOST, PGPORT, PGDATABASE, PGUSER, PGPASSWORD, PGSSLMODE).
+`
+
+func main() {
+	cfgPath := flag.String("config", "config.json", "config file holding the pg settings")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts, err := db.LoadOptions(*cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.SkipMigrations = true
+
+	contxt := context.Background()
+
+	client, err := db.Connect(contxt, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	switch flag.Arg(0) {
+	case "up":
+		_, err = client.Migrate(contxt)
+	case "down":
+		_, err = client.Rollback(contxt)
+	case "status":
+		err = printStatus(contxt, client)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printStatus(ctx context.Context, client *db.Client) error {
+	ms, err := client.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if m.IsApplied() {
+			fmt.Printf("%v\tapplied (group %v, %v)\n", m.Name, m.GroupID, m.MigratedAt.Format("2006-01-02 15:04"))
+		} else {
+			fmt.Printf("%v\tpending\n", m.Name)
+		}
+	}
+	return nil
+}
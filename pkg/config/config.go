@@ -32,5 +32,13 @@ type StocksConfig struct {
 }
 
 type PGConfig struct {
-	PW string `json:"pw"`
+	PW           string `json:"pw"`
+	DSN          string `json:"dsn"`
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+	DB           string `json:"db"`
+	User         string `json:"user"`
+	SSLMode      string `json:"sslmode"`
+	MaxOpenConns int    `json:"max_open_conns"`
+	MaxIdleConns int    `json:"max_idle_conns"`
 }
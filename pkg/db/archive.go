@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// unpriced forgets the price c was closed at, for when there wasn't a real one.
+func (c *ClosedAlert) unpriced() *ClosedAlert {
+	c.ClosePrice = 0
+	c.PctGain = 0
+	c.PriceUnknown = true
+	return c
+}
+
+// GetClosedAlerts returns the guild's archived alerts closed at or after since, newest first. A zero since returns them all.
+func (d *DB) GetClosedAlerts(since time.Time) ([]*ClosedAlert, error) {
+	return d.GetClosedAlertsContext(context.Background(), since)
+}
+
+func (d *DB) GetClosedAlertsContext(ctx context.Context, since time.Time) ([]*ClosedAlert, error) {
+	return d.getClosed(ctx, since, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q
+	})
+}
+
+// GetClosedAlertsCaller returns caller's archived alerts in the guild closed at or after since, newest first.
+func (d *DB) GetClosedAlertsCaller(caller string, since time.Time) ([]*ClosedAlert, error) {
+	return d.GetClosedAlertsCallerContext(context.Background(), caller, since)
+}
+
+func (d *DB) GetClosedAlertsCallerContext(ctx context.Context, caller string, since time.Time) ([]*ClosedAlert, error) {
+	return d.getClosed(ctx, since, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("caller = ?", caller)
+	})
+}
+
+func (d *DB) getClosed(ctx context.Context, since time.Time, filter func(q *bun.SelectQuery) *bun.SelectQuery) ([]*ClosedAlert, error) {
+	allClosed := make([]*ClosedAlert, 0)
+
+	q := d.db.NewSelect().Model(&allClosed).Where("guild_id = ?", d.Guild)
+	if !since.IsZero() {
+		q = q.Where("close_time >= ?", since)
+	}
+	err := filter(q).Order("close_time DESC").Scan(ctx)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get closed alerts for %v : %v", d.Guild, err.Error()))
+		return nil, err
+	}
+	return allClosed, nil
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditPOIHit     = "poi_hit"
+	AuditSwitchType = "switch_type"
+	AuditTransition = "transition"
+	AuditRemove     = "remove"
+)
+
+// AuditEntry records one mutation of an alert. Field, Before and After describe what changed
+// (for a removal Field is "reason" and After the close reason), Snapshot is the alert as JSON before the change,
+// or after it for a create, and is left empty by the conditional high/low updates. Caller is whoever made the alert, Actor whoever made the change.
+type AuditEntry struct {
+	AuditID   int64 `bun:",pk,autoincrement"`
+	GuildID   string
+	AlertID   string
+	AssetType string
+	Caller    string
+	Actor     string
+	Action    string
+	Field     string
+	Before    string
+	After     string
+	Snapshot  string
+	At        time.Time
+}
+
+// SystemActor is recorded when a change is made without an actor on the context, e.g. by a price monitor.
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor tags ctx with the user making a change, for the audit log.
+func WithActor(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// ActorFrom returns the user WithActor put on ctx, or SystemActor.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+func newAuditEntry(ctx context.Context, action, guildID, alertID, assetType, caller string, alert interface{}) *AuditEntry {
+	var snap []byte
+	if alert != nil {
+		var err error
+		snap, err = json.Marshal(alert)
+		if err != nil {
+			log.Println(fmt.Sprintf("Unable to snapshot %v %v for audit : %v", assetType, alertID, err.Error()))
+		}
+	}
+
+	return &AuditEntry{
+		GuildID:   guildID,
+		AlertID:   alertID,
+		AssetType: assetType,
+		Caller:    caller,
+		Actor:     ActorFrom(ctx),
+		Action:    action,
+		Snapshot:  string(snap),
+	}
+}
+
+// change notes which field moved from before to after.
+func (a *AuditEntry) change(field string, before, after interface{}) *AuditEntry {
+	a.Field = field
+	a.Before = fmt.Sprint(before)
+	a.After = fmt.Sprint(after)
+	return a
+}
+
+func writeAudit(ctx context.Context, idb bun.IDB, at time.Time, entries ...*AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, e := range entries {
+		e.At = at
+	}
+
+	_, err := idb.NewInsert().Model(&entries).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to write audit log: %w", err)
+	}
+	return nil
+}
+
+// createAudited saves a new alert, the targets and opening fill it is called with, and its audit entries in one
+// transaction, so none lands without the others.
+// Re-creating one of the guild's alerts replaces it, and its open targets and ledger with the new ones.
+// An alert with the same ID in another guild is never overwritten; that is reported as ErrAlreadyExists.
+func (d *DB) createAudited(ctx context.Context, uid string, alert interface{}, t alertTable, targets []*Target, fill *Fill, entries ...*AuditEntry) error {
+	return d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewInsert().Model(alert).
+			On("CONFLICT (?) DO UPDATE", bun.Ident(t.pk)).
+			Where("?.? = EXCLUDED.?", bun.Ident(t.alias), bun.Ident(t.guild), bun.Ident(t.guild)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return inUse(t.assetType)
+		}
+		if err = resetDetails(ctx, tx, d.Guild, t.assetType, uid); err != nil {
+			return err
+		}
+		if err = insertTargets(ctx, tx, targets); err != nil {
+			return err
+		}
+		if fill != nil {
+			if _, err = tx.NewInsert().Model(fill).Exec(ctx); err != nil {
+				return fmt.Errorf("unable to open ledger: %w", err)
+			}
+		}
+		return writeAudit(ctx, tx, d.now(), entries...)
+	})
+}
+
+// patch edits an existing alert in place. It reads the guild's alert uid into alert under a row lock, lets edit
+// change it and name the columns it changed, then UPDATEs only those, along with edit's audit entries, all in one
+// transaction. Columns edit didn't touch, like a high move() raised in the meantime, are left alone, and an alert
+// closed in the meantime is ErrNotFound rather than being written back.
+func (d *DB) patch(ctx context.Context, t alertTable, uid string, alert interface{}, edit func() ([]string, []*AuditEntry, error)) error {
+	if !d.reg.monitored(d.Guild, uid) {
+		return noMonitor(t.assetType, uid)
+	}
+
+	return d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(alert).
+			Where("? = ?", bun.Ident(t.pk), uid).
+			Where("? = ?", bun.Ident(t.guild), d.Guild).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return notFound(err, t.assetType, uid)
+		}
+
+		columns, entries, err := edit()
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.NewUpdate().Model(alert).Column(columns...).
+			Where("? = ?", bun.Ident(t.pk), uid).
+			Where("? = ?", bun.Ident(t.guild), d.Guild).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("%v %v : %w", t.assetType, uid, ErrNotFound)
+		}
+		return writeAudit(ctx, tx, d.now(), entries...)
+	})
+}
+
+// GetAuditLog returns the guild's audit entries made at or after since, newest first. A zero since returns them all.
+func (d *DB) GetAuditLog(since time.Time) ([]*AuditEntry, error) {
+	return d.GetAuditLogContext(context.Background(), since)
+}
+
+func (d *DB) GetAuditLogContext(ctx context.Context, since time.Time) ([]*AuditEntry, error) {
+	return d.getAudit(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		if since.IsZero() {
+			return q
+		}
+		return q.Where("at >= ?", since)
+	})
+}
+
+// GetAuditLogAlert returns every audit entry for one alert, newest first.
+func (d *DB) GetAuditLogAlert(uid string) ([]*AuditEntry, error) {
+	return d.GetAuditLogAlertContext(context.Background(), uid)
+}
+
+func (d *DB) GetAuditLogAlertContext(ctx context.Context, uid string) ([]*AuditEntry, error) {
+	return d.getAudit(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("alert_id = ?", uid)
+	})
+}
+
+// GetAuditLogCaller returns every audit entry for alerts made by caller, newest first.
+func (d *DB) GetAuditLogCaller(caller string) ([]*AuditEntry, error) {
+	return d.GetAuditLogCallerContext(context.Background(), caller)
+}
+
+func (d *DB) GetAuditLogCallerContext(ctx context.Context, caller string) ([]*AuditEntry, error) {
+	return d.getAudit(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("caller = ?", caller)
+	})
+}
+
+// GetAuditLogActor returns every change actor made, newest first.
+func (d *DB) GetAuditLogActor(actor string) ([]*AuditEntry, error) {
+	return d.GetAuditLogActorContext(context.Background(), actor)
+}
+
+func (d *DB) GetAuditLogActorContext(ctx context.Context, actor string) ([]*AuditEntry, error) {
+	return d.getAudit(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("actor = ?", actor)
+	})
+}
+
+func (d *DB) getAudit(ctx context.Context, filter func(q *bun.SelectQuery) *bun.SelectQuery) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+
+	err := filter(d.db.NewSelect().Model(&entries).Where("guild_id = ?", d.Guild)).Order("audit_id DESC").Scan(ctx)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get audit log for %v : %v", d.Guild, err.Error()))
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+func TestIsTradingHours(t *testing.T) {
+	ny := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, calendar.Location())
+	}
+
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+
+		for _, c := range []struct {
+			name string
+			at   time.Time
+			open bool
+		}{
+			{"pre-market", ny(time.October, 14, 4, 0), false},
+			{"a minute before the open", ny(time.October, 14, 9, 29), false},
+			{"the open", ny(time.October, 14, 9, 30), true},
+			{"a minute before the close", ny(time.October, 14, 15, 59), true},
+			{"the close", ny(time.October, 14, 16, 0), false},
+			{"after-hours", ny(time.October, 14, 19, 59), false},
+			{"overnight", ny(time.October, 15, 2, 0), false},
+			{"saturday", ny(time.October, 17, 11, 0), false},
+			{"sunday", ny(time.October, 18, 11, 0), false},
+			{"thanksgiving", ny(time.November, 26, 11, 0), false},
+			{"christmas, observed", ny(time.December, 25, 11, 0), false},
+			{"the day after thanksgiving, before its early close", ny(time.November, 27, 12, 59), true},
+			{"the day after thanksgiving, at its early close", ny(time.November, 27, 13, 0), false},
+		} {
+			b.clock.Set(c.at)
+			if got := r.IsTradingHours(); got != c.open {
+				t.Errorf("%v (%v): IsTradingHours() = %v, want %v", c.name, c.at, got, c.open)
+			}
+		}
+	})
+}
+
+func TestClockStamps(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		stock, short, coin, option := b.id("stock"), b.id("short"), b.id("coin"), b.id("option")
+
+		_, _, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 110, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, _, err = r.CreateShort(short, "TSLA", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 200)
+		must(t, "CreateShort", err)
+		_, _, err = r.CreateCrypto(coin, "BTC", "caller", 0, 0, 0, 0, 0, utils.SWING, 20000)
+		must(t, "CreateCrypto", err)
+		_, _, _, err = r.CreateOption(option, "oid", "caller", utils.SWING, "AAPL", "C", "20", "11", "2026", 150, 2, 0, 0, 0, 0, 145)
+		must(t, "CreateOption", err)
+
+		s, err := r.GetStock(stock)
+		must(t, "GetStock", err)
+		sh, err := r.GetShort(short)
+		must(t, "GetShort", err)
+		c, err := r.GetCrypto(coin)
+		must(t, "GetCrypto", err)
+		o, err := r.GetOption(option)
+		must(t, "GetOption", err)
+		for name, at := range map[string]time.Time{
+			"stock":  s.StockCallTime,
+			"short":  sh.ShortCallTime,
+			"crypto": c.CryptoCallTime,
+			"option": o.OptionCallTime,
+		} {
+			if !at.Equal(start) {
+				t.Errorf("%v was called at %v, want %v", name, at, start)
+			}
+		}
+
+		targets, err := r.GetTargets(AssetStock, stock)
+		must(t, "GetTargets", err)
+		if len(targets) != 1 || !targets[0].CreatedAt.Equal(start) {
+			t.Errorf("stock targets %+v, want one made at %v", targets, start)
+		}
+		monitors := r.Registry().List(b.id("g"))
+		if len(monitors) != 4 {
+			t.Errorf("%d monitors registered, want 4", len(monitors))
+		}
+		for _, m := range monitors {
+			if !m.Since.Equal(start) {
+				t.Errorf("monitor %v registered at %v, want %v", m.ID, m.Since, start)
+			}
+		}
+
+		closeAt := b.clock.Advance(3 * time.Hour)
+		var closed []*ClosedAlert
+		for _, fn := range []func() (*ClosedAlert, error){
+			func() (*ClosedAlert, error) { return r.CloseStock(stock, CloseReasonTarget, 110) },
+			func() (*ClosedAlert, error) { return r.CloseShort(short, CloseReasonStopped, 210) },
+			func() (*ClosedAlert, error) { return r.CloseCrypto(coin, CloseReasonRemoved, 0) },
+			func() (*ClosedAlert, error) { return r.CloseOption(option, CloseReasonRemoved, 0) },
+		} {
+			a, err := fn()
+			must(t, "Close", err)
+			closed = append(closed, a)
+		}
+		for _, a := range closed {
+			if !a.CloseTime.Equal(closeAt) {
+				t.Errorf("%v %v closed at %v, want %v", a.AssetType, a.AlertID, a.CloseTime, closeAt)
+			}
+			if at := a.States[StateClosed]; !at.Equal(closeAt) {
+				t.Errorf("%v %v entered %v at %v, want %v", a.AssetType, a.AlertID, StateClosed, at, closeAt)
+			}
+		}
+
+		entries, err := r.GetAuditLog(closeAt)
+		must(t, "GetAuditLog", err)
+		if len(entries) != len(closed) {
+			t.Errorf("%d audit entries at or after the close, want %d", len(entries), len(closed))
+		}
+	})
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/db/migrations"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/migrate"
+)
+
+// Options describes how to reach Postgres. When DSN is set it wins over Host/Port/Database/User/SSLMode,
+// but Password is still applied on top so it can be kept out of the DSN.
+type Options struct {
+	DSN      string
+	Host     string
+	Port     int
+	Database string
+	User     string
+	Password string
+	SSLMode  string // disable, allow, prefer, require, verify-ca or verify-full
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// SkipMigrations stops Connect from applying pending migrations, for when they are run by hand.
+	SkipMigrations bool
+
+	// Clock stamps call times, transitions, closes and the audit log. Nil is the system clock.
+	Clock clock.Clock
+}
+
+// DefaultOptions matches the docker-compose setup Kronos has always used.
+func DefaultOptions() Options {
+	return Options{
+		Host:     "postgres",
+		Port:     5432,
+		Database: "db",
+		User:     "postgres",
+		SSLMode:  "disable",
+	}
+}
+
+// OptionsFromConfig overlays whatever is set in the pg section of config.json on top of DefaultOptions.
+func OptionsFromConfig(cfg config.PGConfig) Options {
+	opts := DefaultOptions()
+
+	if cfg.DSN != "" {
+		opts.DSN = cfg.DSN
+	}
+	if cfg.Host != "" {
+		opts.Host = cfg.Host
+	}
+	if cfg.Port != 0 {
+		opts.Port = cfg.Port
+	}
+	if cfg.DB != "" {
+		opts.Database = cfg.DB
+	}
+	if cfg.User != "" {
+		opts.User = cfg.User
+	}
+	if cfg.PW != "" {
+		opts.Password = cfg.PW
+	}
+	if cfg.SSLMode != "" {
+		opts.SSLMode = cfg.SSLMode
+	}
+	if cfg.MaxOpenConns != 0 {
+		opts.MaxOpenConns = cfg.MaxOpenConns
+	}
+	if cfg.MaxIdleConns != 0 {
+		opts.MaxIdleConns = cfg.MaxIdleConns
+	}
+
+	return opts
+}
+
+// WithEnv overlays the environment on top of o. It understands DATABASE_URL, the usual libpq
+// PGHOST/PGPORT/PGDATABASE/PGUSER/PGPASSWORD/PGSSLMODE variables and HARPE_PG_MAX_OPEN_CONNS/HARPE_PG_MAX_IDLE_CONNS.
+func (o Options) WithEnv() (Options, error) {
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		o.DSN = v
+	}
+	if v := os.Getenv("PGHOST"); v != "" {
+		o.Host = v
+	}
+	if v := os.Getenv("PGDATABASE"); v != "" {
+		o.Database = v
+	}
+	if v := os.Getenv("PGUSER"); v != "" {
+		o.User = v
+	}
+	if v := os.Getenv("PGPASSWORD"); v != "" {
+		o.Password = v
+	}
+	if v := os.Getenv("PGSSLMODE"); v != "" {
+		o.SSLMode = v
+	}
+
+	for env, field := range map[string]*int{
+		"PGPORT":                  &o.Port,
+		"HARPE_PG_MAX_OPEN_CONNS": &o.MaxOpenConns,
+		"HARPE_PG_MAX_IDLE_CONNS": &o.MaxIdleConns,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return o, fmt.Errorf("invalid %v %q: %w", env, v, err)
+		}
+		*field = n
+	}
+
+	return o, nil
+}
+
+// LoadOptions reads the pg section of the config file at path, then applies the environment on top.
+// A missing file is not an error, so a deployment can be configured from the environment alone.
+func LoadOptions(path string) (Options, error) {
+	var cfg config.Config
+
+	byteValue, err := ioutil.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return Options{}, fmt.Errorf("error reading %v: %w", path, err)
+	default:
+		if err = json.Unmarshal(byteValue, &cfg); err != nil {
+			return Options{}, fmt.Errorf("error parsing %v: %w", path, err)
+		}
+	}
+
+	return OptionsFromConfig(cfg.PostgresCfg).WithEnv()
+}
+
+func (o Options) connectorOptions() (opts []pgdriver.Option, err error) {
+	if o.DSN != "" {
+		u, err := url.Parse(o.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DSN: %w", err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" && u.Scheme != "unix" {
+			return nil, invalidInput("invalid DSN scheme %q", u.Scheme)
+		}
+		if _, err = tlsOption(u.Query().Get("sslmode")); err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, pgdriver.WithDSN(o.DSN))
+		if o.Password != "" {
+			opts = append(opts, pgdriver.WithPassword(o.Password))
+		}
+		return opts, nil
+	}
+
+	if o.Host == "" || o.Port == 0 || o.Database == "" || o.User == "" {
+		return nil, invalidInput("host, port, database and user must all be set when no DSN is given")
+	}
+
+	tlsOpt, err := tlsOption(o.SSLMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return []pgdriver.Option{
+		pgdriver.WithAddr(net.JoinHostPort(o.Host, strconv.Itoa(o.Port))),
+		pgdriver.WithDatabase(o.Database),
+		pgdriver.WithUser(o.User),
+		pgdriver.WithPassword(o.Password),
+		tlsOpt,
+	}, nil
+}
+
+// tlsOption maps a libpq sslmode onto the driver the same way pgdriver does for DSNs.
+func tlsOption(sslMode string) (pgdriver.Option, error) {
+	switch sslMode {
+	case "verify-ca", "verify-full":
+		return pgdriver.WithTLSConfig(new(tls.Config)), nil
+	case "allow", "prefer", "require", "":
+		return pgdriver.WithTLSConfig(&tls.Config{InsecureSkipVerify: true}), nil
+	case "disable":
+		return pgdriver.WithInsecure(true), nil
+	default:
+		return nil, invalidInput("sslmode %q is not supported", sslMode)
+	}
+}
+
+// Client is a Postgres connection pool shared by every guild's DB.
+type Client struct {
+	db    *bun.DB
+	reg   *AlertRegistry
+	clock clock.Clock
+}
+
+// Connect opens a pool with opts, checks Postgres is reachable and, unless told otherwise, migrates the schema.
+func Connect(ctx context.Context, opts Options) (*Client, error) {
+	connOpts, err := opts.connectorOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := newConnector(connOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	sqldb := sql.OpenDB(connector)
+	if opts.MaxOpenConns > 0 {
+		sqldb.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		sqldb.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		sqldb.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+
+	if err = sqldb.PingContext(ctx); err != nil {
+		sqldb.Close()
+		return nil, fmt.Errorf("unable to reach postgres: %w", err)
+	}
+
+	db := bun.NewDB(sqldb, pgdialect.New())
+	db.RegisterModel((*Channel)(nil))
+	//////////////////////////////////////////////////
+	db.RegisterModel((*Stock)(nil))
+	db.RegisterModel((*Short)(nil))
+	db.RegisterModel((*Option)(nil))
+	db.RegisterModel((*Crypto)(nil))
+
+	c := &Client{db: db, reg: NewAlertRegistry(), clock: clock.OrSystem(opts.Clock)}
+
+	if !opts.SkipMigrations {
+		if _, err = c.Migrate(ctx); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// pgdriver panics on a DSN it can't parse; turn that into an error.
+func newConnector(opts []pgdriver.Option) (connector *pgdriver.Connector, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("invalid connection options: %v", e)
+		}
+	}()
+
+	return pgdriver.NewConnector(opts...), nil
+}
+
+// Guild returns a DB scoped to guildID, sharing this pool.
+func (c *Client) Guild(guildID string) *DB {
+	return &DB{
+		Guild: guildID,
+		db:    c.db,
+		reg:   c.reg,
+		clock: c.clock,
+	}
+}
+
+// Registry returns the monitors of every guild on this client.
+func (c *Client) Registry() *AlertRegistry {
+	return c.reg
+}
+
+func (c *Client) Migrate(ctx context.Context) (*migrate.MigrationGroup, error) {
+	return migrations.Up(ctx, c.db)
+}
+
+func (c *Client) Rollback(ctx context.Context) (*migrate.MigrationGroup, error) {
+	return migrations.Down(ctx, c.db)
+}
+
+func (c *Client) MigrationStatus(ctx context.Context) (migrate.MigrationSlice, error) {
+	return migrations.Status(ctx, c.db)
+}
+
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// Shutdown stops every alert monitor, waits for them to release until ctx is done, then closes the pool.
+func (c *Client) Shutdown(ctx context.Context) error {
+	err := c.reg.Shutdown(ctx)
+	if cerr := c.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -17,23 +17,26 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"sync"
 	"time"
+
+	"github.com/uptrace/bun"
 )
 
 func (d *DB) CreateCrypto(uid, coin, author string, spt, ept, poi, stop, tstop float32, alertType int, starting float32) (chan bool, bool, error) {
-	chanMap.LoadOrStore(d.Guild, &sync.Map{})
+	return d.CreateCryptoContext(context.Background(), uid, coin, author, spt, ept, poi, stop, tstop, alertType, starting)
+}
+
+func (d *DB) CreateCryptoContext(ctx context.Context, uid, coin, author string, spt, ept, poi, stop, tstop float32, alertType int, starting float32) (chan bool, bool, error) {
 	exists, exitChan := d.GetExitChanExists(uid)
 
 	if exists {
 		return exitChan, exists, nil
 	}
 
-	contxt := context.Background()
-
 	s := &Crypto{
 		CryptoAlertID:      uid,
 		CryptoGuildID:      d.Guild,
@@ -42,7 +45,7 @@ func (d *DB) CreateCrypto(uid, coin, author string, spt, ept, poi, stop, tstop f
 		CryptoHighest:      starting,
 		CryptoLastHigh:     starting,
 		AlertType:          alertType,
-		CryptoCallTime:     time.Now(),
+		CryptoCallTime:     d.now(),
 		CryptoEPt:          ept,
 		CryptoSPt:          spt,
 		CryptoStop:         stop,
@@ -50,11 +53,16 @@ func (d *DB) CreateCrypto(uid, coin, author string, spt, ept, poi, stop, tstop f
 		CryptoPoI:          poi,
 		CryptoPOIHit:       false,
 		Caller:             author,
+		CryptoState:        initialState(poi, false),
 	}
+	s.CryptoStateTimes = initialTimes(s.CryptoState, s.CryptoCallTime)
 
-	_, err := d.db.NewInsert().Model(s).On("CONFLICT (crypto_alert_id) DO UPDATE").Exec(contxt)
+	targets := seedTargets(d.Guild, uid, AssetCrypto, TargetPrice, d.now(), spt, ept)
+	fill := openingFill(d.Guild, uid, AssetCrypto, author, s.CryptoStarting, s.CryptoCallTime)
+	err := d.createAudited(ctx, uid, s, cryptoTable, targets, fill, s.auditEntry(ctx, AuditCreate))
 
 	if err != nil {
+		d.reg.Cancel(d.Guild, uid)
 		log.Println(fmt.Sprintf("Unable to create Crypto %v : %v", coin, err.Error()))
 		return nil, false, err
 	}
@@ -63,68 +71,98 @@ func (d *DB) CreateCrypto(uid, coin, author string, spt, ept, poi, stop, tstop f
 }
 
 func (d *DB) RemoveCrypto(uid string) error {
+	return d.RemoveCryptoContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
+func (d *DB) RemoveCryptoContext(ctx context.Context, uid string) error {
+	_, err := d.CloseCryptoContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
 
-	s := &Crypto{
-		CryptoGuildID: d.Guild,
-		CryptoAlertID: uid,
-	}
-	res, err := d.db.NewDelete().Model(s).Where("crypto_alert_id = ?", uid).Exec(contxt)
-	rowsAffected, _ := res.RowsAffected()
+// CloseCrypto removes the crypto and archives it with reason. A zero price archives it at its highest price.
+func (d *DB) CloseCrypto(uid, reason string, price float32) (*ClosedAlert, error) {
+	return d.CloseCryptoContext(context.Background(), uid, reason, price)
+}
 
-	if rowsAffected == 0 {
-		err = errors.New(fmt.Sprintf("Unable to remove Crypto %v : NOT FOUND", uid))
-		return err
-	}
+func (d *DB) CloseCryptoContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	c := &Crypto{}
+	var closed *ClosedAlert
+
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().Model(c).Where("crypto_alert_id = ?", uid).Where("crypto_guild_id = ?", d.Guild).Returning("*").Exec(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("Unable to remove Crypto %v : %w", uid, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("Unable to remove Crypto %v : %w", uid, ErrNotFound)
+		}
+
+		closed = c.closed(reason, price, d.now())
+		_, err = tx.NewInsert().Model(closed).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err = archiveDetails(ctx, tx, d.Guild, closed); err != nil {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), c.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	})
 
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to remove Crypto %v : %v", uid, err.Error()))
-		return err
+		log.Println(fmt.Sprintf("Unable to close Crypto %v : %v", uid, err.Error()))
+		return nil, err
 	}
-	clearFromSyncMap(chanMap, d.Guild, uid)
-	return nil
+	d.reg.Cancel(d.Guild, uid)
+	return closed, nil
 }
 
 func (d *DB) GetCrypto(uid string) (*Crypto, error) {
+	return d.GetCryptoContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
-
+func (d *DB) GetCryptoContext(ctx context.Context, uid string) (*Crypto, error) {
 	s := &Crypto{
 		CryptoGuildID: d.Guild,
 		CryptoAlertID: uid,
 	}
-	err := d.db.NewSelect().Model(s).Where("crypto_alert_id = ?", uid).Scan(contxt)
+	err := d.db.NewSelect().Model(s).Where("crypto_alert_id = ?", uid).Where("crypto_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, err.Error()))
-		return nil, err
+		return nil, notFound(err, "crypto", uid)
 	}
-	gMap, ok := chanMap.Load(d.Guild)
-	if ok {
-		gMapCast := gMap.(*sync.Map)
-		_, ok := gMapCast.Load(uid)
-		if !ok {
-			log.Println(fmt.Sprintf("Unable to get alert channel for crypto %v. Please try recreating this alert, or calling !refresh then running this command again.", uid))
-			return nil, err
-		}
+	if !d.reg.monitored(d.Guild, uid) {
+		err = noMonitor("crypto", uid)
+		log.Println(err.Error())
+		return nil, err
 	}
 
 	return s, nil
 }
 
 func (d *DB) CryptoPOIHit(uid string) error {
-	contxt := context.Background()
-
-	s, err := d.GetCrypto(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, err.Error()))
-		return err
-	}
-
-	s.CryptoPOIHit = true
+	return d.CryptoPOIHitContext(context.Background(), uid)
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (crypto_alert_id) DO UPDATE").Exec(contxt)
+func (d *DB) CryptoPOIHitContext(ctx context.Context, uid string) error {
+	s := &Crypto{}
+	err := d.patch(ctx, cryptoTable, uid, s, func() ([]string, []*AuditEntry, error) {
+		entries := []*AuditEntry{s.auditEntry(ctx, AuditPOIHit).change("poi_hit", s.CryptoPOIHit, true)}
+		s.CryptoPOIHit = true
+
+		if s.GetState() == StatePending {
+			entry, err := s.moveTo(ctx, StateActive, d.now())
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return []string{"crypto_poi_hit", cryptoTable.state, cryptoTable.stateTimes}, entries, nil
+	})
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update Crypto %v : %v", uid, err.Error()))
@@ -134,48 +172,128 @@ func (d *DB) CryptoPOIHit(uid string) error {
 	return nil
 }
 
-func (d *DB) CryptoSetNewHigh(uid string, price float32) error {
-	contxt := context.Background()
+// CryptoTransition moves the crypto to state to, refusing any move its lifecycle does not allow.
+// Moving it to StateClosed closes it, archiving it with the reason its current state implies.
+func (d *DB) CryptoTransition(uid string, to State) error {
+	return d.CryptoTransitionContext(context.Background(), uid, to)
+}
 
-	s, err := d.GetCrypto(uid)
+func (d *DB) CryptoTransitionContext(ctx context.Context, uid string, to State) error {
+	s, err := d.GetCryptoContext(ctx, uid)
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, err.Error()))
+		log.Println(fmt.Sprintf("Unable to get crypto %v : %v", uid, err.Error()))
 		return err
 	}
 
-	s.CryptoHighest = price
+	if to == StateClosed {
+		_, err = d.CloseCryptoContext(ctx, uid, closeReason(s.GetState()), 0)
+		return err
+	}
+
+	stored := s.CryptoState
+	entry, err := s.moveTo(ctx, to, d.now())
+	if err != nil {
+		return err
+	}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (crypto_alert_id) DO UPDATE").Exec(contxt)
+	err = d.setState(ctx, cryptoTable, uid, stored, s.CryptoState, s.CryptoStateTimes, entry)
 
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to update Crypto %v : %v", uid, err.Error()))
+		log.Println(fmt.Sprintf("Unable to update crypto %v : %v", uid, err.Error()))
 		return err
 	}
 
 	return nil
 }
 
+func (d *DB) CryptoSetNewHigh(uid string, price float32) error {
+	return d.CryptoSetNewHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) CryptoSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.CryptoUpdateHighContext(ctx, uid, price)
+	return err
+}
+
+// CryptoUpdateHigh records price as the new high only if it is above the current one, in a single statement,
+// and reports whether it did.
+func (d *DB) CryptoUpdateHigh(uid string, price float32) (bool, error) {
+	return d.CryptoUpdateHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) CryptoUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error) {
+	return d.move(ctx, cryptoHigh, uid, price)
+}
+
+// CryptoSetNewAvg declares price the new average cost, as a FillAvg in the ledger rather than by overwriting the entry.
 func (d *DB) CryptoSetNewAvg(uid string, price float32) error {
-	contxt := context.Background()
+	return d.CryptoSetNewAvgContext(context.Background(), uid, price)
+}
 
-	s, err := d.GetCrypto(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, err.Error()))
-		return err
+func (d *DB) CryptoSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.AddFillContext(ctx, AssetCrypto, uid, FillAvg, price, 0)
+	return err
+}
+
+func (c Crypto) GetPctGain(highest float32) float32 {
+	return ((highest - c.CryptoStarting) / c.CryptoStarting) * 100
+}
+
+func (c Crypto) closed(reason string, price float32, now time.Time) *ClosedAlert {
+	if price == PriceUnknown {
+		return c.closed(reason, 0, now).unpriced()
+	}
+	if price == 0 {
+		price = c.CryptoHighest
 	}
 
-	s.CryptoStarting = price
+	return &ClosedAlert{
+		AlertID:     c.CryptoAlertID,
+		GuildID:     c.CryptoGuildID,
+		AssetType:   AssetCrypto,
+		Ticker:      c.CryptoCoin,
+		Contract:    "",
+		AlertType:   c.AlertType,
+		Caller:      c.Caller,
+		Starting:    c.CryptoStarting,
+		Highest:     c.CryptoHighest,
+		CallTime:    c.CryptoCallTime,
+		CloseReason: reason,
+		ClosePrice:  price,
+		CloseTime:   now,
+		PctGain:     c.GetPctGain(price),
+		States:      closedTimes(c.GetStateTimes(), now),
+	}
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (crypto_alert_id) DO UPDATE").Exec(contxt)
+func (c *Crypto) auditEntry(ctx context.Context, action string) *AuditEntry {
+	return newAuditEntry(ctx, action, c.CryptoGuildID, c.CryptoAlertID, AssetCrypto, c.Caller, c)
+}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to update Crypto %v : %v", uid, err.Error()))
-		return err
+// GetState returns the crypto's lifecycle state, working it out for alerts stored before states were recorded.
+func (c Crypto) GetState() State {
+	if c.CryptoState == "" {
+		return initialState(c.CryptoPoI, c.CryptoPOIHit)
 	}
+	return c.CryptoState
+}
 
-	return nil
+// GetStateTimes returns when the crypto entered each state it has been in.
+func (c Crypto) GetStateTimes() StateTimes {
+	if len(c.CryptoStateTimes) == 0 {
+		return initialTimes(c.GetState(), c.CryptoCallTime)
+	}
+	return c.CryptoStateTimes
 }
 
-func (c Crypto) GetPctGain(highest float32) float32 {
-	return ((highest - c.CryptoStarting) / c.CryptoStarting) * 100
+func (c *Crypto) moveTo(ctx context.Context, to State, at time.Time) (*AuditEntry, error) {
+	from := c.GetState()
+	entry := c.auditEntry(ctx, AuditTransition).change("state", from, to)
+
+	times := c.GetStateTimes()
+	if err := advance(&c.CryptoState, &times, from, to, at); err != nil {
+		return nil, err
+	}
+	c.CryptoStateTimes = times
+	return entry, nil
 }
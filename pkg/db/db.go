@@ -17,258 +17,229 @@ package db
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
-	"strconv"
 	"sync"
+	"time"
 
-	"github.com/m1k8/harpe/pkg/config"
-	"github.com/uniplaces/carbon"
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/utils"
 	"github.com/uptrace/bun"
-	"github.com/uptrace/bun/dialect/pgdialect"
-	"github.com/uptrace/bun/driver/pgdriver"
 )
 
 var once = sync.Once{}
-var client *bun.DB
-var chanMap = sync.Map{}
+var client *Client
 
+// NewDB returns a DB for guildID on a pool shared by the whole process, configured from config.json and the environment.
+// It panics if that fails; use Connect to handle connection errors yourself.
 func NewDB(guildID string) *DB {
-	contxt := context.Background()
-
 	once.Do(func() {
-
-		cfgFile, err := os.Open("config.json")
-
+		opts, err := LoadOptions("config.json")
 		if err != nil {
-			panic("Unable to open config.json!")
+			panic(err.Error())
 		}
 
-		defer cfgFile.Close()
-
-		byteValue, err := ioutil.ReadAll(cfgFile)
-
+		c, err := Connect(context.Background(), opts)
 		if err != nil {
-			panic("Error reading config.json!")
+			panic("unable to connect to postgres: " + err.Error())
 		}
-		var cfg config.Config
 
-		json.Unmarshal(byteValue, &cfg)
+		client = c
+	})
 
-		if cfg.PostgresCfg.PW == "" {
-			panic("pg pw not set in config!")
-		}
-		pw := cfg.PostgresCfg.PW
-
-		dsn := "postgres://postgres:@postgres:5432/db?sslmode=disable"
-		//dsn := "postgres://localhost:5432/db?sslmode=disable"
-		sqldb := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dsn),
-			pgdriver.WithPassword(pw)))
-
-		db := bun.NewDB(sqldb, pgdialect.New())
-		db.RegisterModel((*Channel)(nil))
-		//////////////////////////////////////////////////
-		db.RegisterModel((*Stock)(nil))
-		db.RegisterModel((*Short)(nil))
-		db.RegisterModel((*Option)(nil))
-		db.RegisterModel((*Crypto)(nil))
-
-		_, err = db.NewCreateTable().Model((*Channel)(nil)).IfNotExists().Exec(contxt)
-		if err != nil {
-			panic("unable to create/get channel perms table: " + err.Error())
-		}
+	if client == nil {
+		panic("db not set!")
+	}
 
-		_, err = db.NewCreateTable().Model((*Stock)(nil)).IfNotExists().Exec(contxt)
-		if err != nil {
-			panic("unable to create/get stocks table: " + err.Error())
-		}
+	return client.Guild(guildID)
+}
 
-		_, err = db.NewCreateTable().Model((*Short)(nil)).IfNotExists().Exec(contxt)
-		if err != nil {
-			panic("unable to create/get shorts table: " + err.Error())
-		}
+// RemovalReport lists the alert IDs a nuke removed, per asset type.
+type RemovalReport struct {
+	Stocks  []string
+	Shorts  []string
+	Crypto  []string
+	Options []string
+}
 
-		_, err = db.NewCreateTable().Model((*Crypto)(nil)).IfNotExists().Exec(contxt)
-		if err != nil {
-			panic("unable to create/get crypto table: " + err.Error())
-		}
+func (r *RemovalReport) Total() int {
+	return len(r.Stocks) + len(r.Shorts) + len(r.Crypto) + len(r.Options)
+}
 
-		_, err = db.NewCreateTable().Model((*Option)(nil)).IfNotExists().Exec(contxt)
-		if err != nil {
-			panic("unable to create/get options table: " + err.Error())
-		}
+func (d *DB) RmAll() (*RemovalReport, error) {
+	return d.RmAllContext(context.Background())
+}
 
-		client = db
-	})
+// RmAllContext removes every alert in the guild in a single transaction. Monitors are only signalled once it commits.
+func (d *DB) RmAllContext(ctx context.Context) (*RemovalReport, error) {
+	log.Println("Nuke called!!!!!!!!!!!!!!!!!!!!!!")
 
-	if client == nil {
-		panic("db not set!")
-	}
+	return d.nuke(ctx, func(q *bun.DeleteQuery) *bun.DeleteQuery {
+		return q
+	})
+}
 
-	return &DB{
-		Guild: guildID,
-		db:    client,
-	}
+func (d *DB) RmAllCaller(caller string) (*RemovalReport, error) {
+	return d.RmAllCallerContext(context.Background(), caller)
 }
 
-func (d *DB) RmAll() error {
-	contxt := context.Background()
+// RmAllCallerContext removes every alert caller made in the guild in a single transaction. Monitors are only signalled once it commits.
+func (d *DB) RmAllCallerContext(ctx context.Context, caller string) (*RemovalReport, error) {
+	log.Println("Nuke called for " + caller + " !!!!!!!!!!!!!!!!!!!!!!")
 
+	return d.nuke(ctx, func(q *bun.DeleteQuery) *bun.DeleteQuery {
+		return q.Where("caller = ?", caller)
+	})
+}
+
+func (d *DB) nuke(ctx context.Context, filter func(q *bun.DeleteQuery) *bun.DeleteQuery) (*RemovalReport, error) {
 	allStocks := make([]*Stock, 0)
 	allShorts := make([]*Short, 0)
 	allOptions := make([]*Option, 0)
 	allCrypto := make([]*Crypto, 0)
-	log.Println("Nuke called!!!!!!!!!!!!!!!!!!!!!!")
 
-	err := d.db.NewSelect().Model((*Stock)(nil)).Where("stock_guild_id = ?", d.Guild).Scan(contxt, &allStocks)
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := filter(tx.NewDelete().Model(&allStocks).Where("stock_guild_id = ?", d.Guild)).Returning("*").Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to remove stocks: %w", err)
+		}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get stocks. There is probably a serious issue: %v.", err.Error()))
-		return err
-	}
+		_, err = filter(tx.NewDelete().Model(&allShorts).Where("short_guild_id = ?", d.Guild)).Returning("*").Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to remove shorts: %w", err)
+		}
 
-	for _, v := range allStocks {
-		log.Println("removing " + v.StockAlertID)
-		d.RemoveStock(v.StockAlertID)
-	}
+		_, err = filter(tx.NewDelete().Model(&allCrypto).Where("crypto_guild_id = ?", d.Guild)).Returning("*").Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to remove crypto: %w", err)
+		}
 
-	err = d.db.NewSelect().Model((*Short)(nil)).Where("short_guild_id = ?", d.Guild).Scan(contxt, &allShorts)
+		_, err = filter(tx.NewDelete().Model(&allOptions).Where("option_guild_id = ?", d.Guild)).Returning("*").Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to remove options: %w", err)
+		}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get shorts. There is probably a serious issue: %v.", err.Error()))
-		return err
-	}
+		closed := nukedArchive(d.now(), allStocks, allShorts, allCrypto, allOptions)
+		if len(closed) == 0 {
+			return nil
+		}
 
-	for _, v := range allShorts {
-		log.Println("removing " + v.ShortAlertID)
-		d.RemoveShort(v.ShortAlertID)
-	}
+		_, err = tx.NewInsert().Model(&closed).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to archive removed alerts: %w", err)
+		}
 
-	err = d.db.NewSelect().Model((*Crypto)(nil)).Where("crypto_guild_id = ?", d.Guild).Scan(contxt, &allCrypto)
+		if err = archiveDetails(ctx, tx, d.Guild, closed...); err != nil {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), nukedAudit(ctx, allStocks, allShorts, allCrypto, allOptions)...)
+	})
 
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get crypto. There is probably a serious issue: %v.", err.Error()))
-		return err
+		log.Println(fmt.Sprintf("Nuke failed, nothing was removed: %v", err.Error()))
+		return nil, err
 	}
 
-	for _, v := range allCrypto {
-		log.Println("removing " + v.CryptoAlertID)
-		d.RemoveCrypto(v.CryptoAlertID)
+	report := &RemovalReport{}
+	for _, v := range allStocks {
+		report.Stocks = append(report.Stocks, v.StockAlertID)
 	}
-
-	err = d.db.NewSelect().Model((*Option)(nil)).Where("option_guild_id = ?", d.Guild).Scan(contxt, &allOptions)
-
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get options. There is probably a serious issue: %v.", err.Error()))
-		return err
+	for _, v := range allShorts {
+		report.Shorts = append(report.Shorts, v.ShortAlertID)
+	}
+	for _, v := range allCrypto {
+		report.Crypto = append(report.Crypto, v.CryptoAlertID)
 	}
-
 	for _, v := range allOptions {
-		log.Println("removing " + v.OptionAlertID)
-		d.RemoveOptionByCode(v.OptionAlertID)
+		report.Options = append(report.Options, v.OptionAlertID)
 	}
+	signalRemoved(d.reg, d.Guild, report)
 
-	log.Println("Nuke completed!!!!!!!!!!!!!!!!!!!!!!")
+	log.Println(fmt.Sprintf("Nuke completed, removed %v alerts!!!!!!!!!!!!!!!!!!!!!!", report.Total()))
 
-	return nil
+	return report, nil
 }
 
-func (d *DB) RmAllCaller(caller string) error {
-	contxt := context.Background()
-
-	allStocks := make([]*Stock, 0)
-	allShorts := make([]*Short, 0)
-	allOptions := make([]*Option, 0)
-	allCrypto := make([]*Crypto, 0)
-	log.Println("Nuke called for " + caller + " !!!!!!!!!!!!!!!!!!!!!!")
-
-	err := d.db.NewSelect().Model((*Stock)(nil)).Where("stock_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allStocks)
-
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get stocks. There is probably a serious issue: %v.", err.Error()))
-		return err
-	}
+func nukedArchive(now time.Time, allStocks []*Stock, allShorts []*Short, allCrypto []*Crypto, allOptions []*Option) []*ClosedAlert {
+	closed := make([]*ClosedAlert, 0, len(allStocks)+len(allShorts)+len(allCrypto)+len(allOptions))
 
 	for _, v := range allStocks {
-		log.Println("removing " + v.StockAlertID)
-		d.RemoveStock(v.StockAlertID)
-	}
-
-	err = d.db.NewSelect().Model((*Short)(nil)).Where("short_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allShorts)
-
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get shorts. There is probably a serious issue: %v.", err.Error()))
-		return err
+		closed = append(closed, v.closed(CloseReasonNuked, 0, now))
 	}
-
 	for _, v := range allShorts {
-		log.Println("removing " + v.ShortAlertID)
-		d.RemoveShort(v.ShortAlertID)
+		closed = append(closed, v.closed(CloseReasonNuked, 0, now))
 	}
-
-	err = d.db.NewSelect().Model((*Crypto)(nil)).Where("crypto_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allCrypto)
-
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get crypto. There is probably a serious issue: %v.", err.Error()))
-		return err
-	}
-
 	for _, v := range allCrypto {
-		log.Println("removing " + v.CryptoAlertID)
-		d.RemoveCrypto(v.CryptoAlertID)
+		closed = append(closed, v.closed(CloseReasonNuked, 0, now))
+	}
+	for _, v := range allOptions {
+		closed = append(closed, v.closed(CloseReasonNuked, 0, now))
 	}
 
-	err = d.db.NewSelect().Model((*Option)(nil)).Where("option_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allOptions)
+	return closed
+}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get options. There is probably a serious issue: %v.", err.Error()))
-		return err
-	}
+func nukedAudit(ctx context.Context, allStocks []*Stock, allShorts []*Short, allCrypto []*Crypto, allOptions []*Option) []*AuditEntry {
+	entries := make([]*AuditEntry, 0, len(allStocks)+len(allShorts)+len(allCrypto)+len(allOptions))
 
+	for _, v := range allStocks {
+		entries = append(entries, v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+	}
+	for _, v := range allShorts {
+		entries = append(entries, v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+	}
+	for _, v := range allCrypto {
+		entries = append(entries, v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+	}
 	for _, v := range allOptions {
-		log.Println("removing " + v.OptionAlertID)
-		d.RemoveOptionByCode(v.OptionAlertID)
+		entries = append(entries, v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
 	}
 
-	log.Println("Nuke completed!!!!!!!!!!!!!!!!!!!!!!")
+	return entries
+}
 
-	return nil
+func signalRemoved(reg *AlertRegistry, guildID string, report *RemovalReport) {
+	for _, ids := range [][]string{report.Stocks, report.Shorts, report.Crypto, report.Options} {
+		for _, id := range ids {
+			log.Println("removing " + id)
+			reg.Cancel(guildID, id)
+		}
+	}
 }
 
 func (d *DB) GetAll() ([]*Stock, []*Short, []*Crypto, []*Option, error) {
-	contxt := context.Background()
+	return d.GetAllContext(context.Background())
+}
+
+func (d *DB) GetAllContext(ctx context.Context) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
 	allStocks := make([]*Stock, 0)
 	allShorts := make([]*Short, 0)
 	allOptions := make([]*Option, 0)
 	allCrypto := make([]*Crypto, 0)
 
-	err := d.db.NewSelect().Model((*Stock)(nil)).Where("stock_guild_id = ?", d.Guild).Scan(contxt, &allStocks)
+	err := d.db.NewSelect().Model((*Stock)(nil)).Where("stock_guild_id = ?", d.Guild).Scan(ctx, &allStocks)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get stocks. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model((*Short)(nil)).Where("short_guild_id = ?", d.Guild).Scan(contxt, &allShorts)
+	err = d.db.NewSelect().Model((*Short)(nil)).Where("short_guild_id = ?", d.Guild).Scan(ctx, &allShorts)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get shorts. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model((*Crypto)(nil)).Where("crypto_guild_id = ?", d.Guild).Scan(contxt, &allCrypto)
+	err = d.db.NewSelect().Model((*Crypto)(nil)).Where("crypto_guild_id = ?", d.Guild).Scan(ctx, &allCrypto)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get crypto. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model((*Option)(nil)).Where("option_guild_id = ?", d.Guild).Scan(contxt, &allOptions)
+	err = d.db.NewSelect().Model((*Option)(nil)).Where("option_guild_id = ?", d.Guild).Scan(ctx, &allOptions)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get options. There is probably a serious issue: %v.", err.Error()))
@@ -279,34 +250,37 @@ func (d *DB) GetAll() ([]*Stock, []*Short, []*Crypto, []*Option, error) {
 }
 
 func (d *DB) GetAllCaller(caller string) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
-	contxt := context.Background()
+	return d.GetAllCallerContext(context.Background(), caller)
+}
+
+func (d *DB) GetAllCallerContext(ctx context.Context, caller string) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
 	allStocks := make([]*Stock, 0)
 	allShorts := make([]*Short, 0)
 	allOptions := make([]*Option, 0)
 	allCrypto := make([]*Crypto, 0)
 
-	err := d.db.NewSelect().Model((*Stock)(nil)).Where("stock_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allStocks)
+	err := d.db.NewSelect().Model((*Stock)(nil)).Where("stock_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(ctx, &allStocks)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get stocks. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model((*Short)(nil)).Where("short_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allShorts)
+	err = d.db.NewSelect().Model((*Short)(nil)).Where("short_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(ctx, &allShorts)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get shorts. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model((*Crypto)(nil)).Where("crypto_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allCrypto)
+	err = d.db.NewSelect().Model((*Crypto)(nil)).Where("crypto_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(ctx, &allCrypto)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get crypto. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model((*Option)(nil)).Where("option_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(contxt, &allOptions)
+	err = d.db.NewSelect().Model((*Option)(nil)).Where("option_guild_id = ?", d.Guild).Where("caller = ?", caller).Scan(ctx, &allOptions)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get options. There is probably a serious issue: %v.", err.Error()))
@@ -316,135 +290,113 @@ func (d *DB) GetAllCaller(caller string) ([]*Stock, []*Short, []*Crypto, []*Opti
 	return allStocks, allShorts, allCrypto, allOptions, nil
 }
 
-func (d *DB) GetExitChan(index string) chan bool {
-	val, _ := chanMap.Load(d.Guild)
-
-	gMap := val.(*sync.Map)
-	gVal, ok := gMap.Load(index)
+// Registry returns the monitors shared by every guild on this DB's client.
+func (d *DB) Registry() *AlertRegistry {
+	return d.reg
+}
 
-	if ok {
-		return gVal.(chan bool)
-	} else {
-		return nil
-	}
+func (d *DB) GetExitChan(index string) chan bool {
+	return d.reg.Exit(d.Guild, index)
 }
 
 func (d *DB) GetExitChanExists(index string) (bool, chan bool) {
-	val, _ := chanMap.Load(d.Guild)
-	exitChan := make(chan bool, 1)
-
-	gMap := val.(*sync.Map)
-	gVal, ok := gMap.LoadOrStore(index, exitChan)
-
-	if ok {
-		log.Println("Alert " + index + " exists!")
-		return true, gVal.(chan bool)
-	} else {
-		return false, exitChan
-	}
+	exitChan, exists := d.reg.OpenAt(d.Guild, index, d.now())
+	return exists, exitChan
 }
 
 func (d *DB) SetAndReturnNewExitChan(index string, exitChan chan bool) chan bool {
-	val, _ := chanMap.LoadOrStore(d.Guild, &sync.Map{})
-
-	gMap := val.(*sync.Map)
-	gMap.Store(index, exitChan)
-
-	return exitChan
+	return d.reg.AttachAt(d.Guild, index, exitChan, d.now())
 }
 
 func (d *DB) RefreshFromDB() ([]*Stock, []*Short, []*Crypto, []*Option, error) {
-	contxt := context.Background()
+	return d.RefreshFromDBContext(context.Background())
+}
+
+func (d *DB) RefreshFromDBContext(ctx context.Context) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
 	allStocks := make([]*Stock, 0)
 	allShorts := make([]*Short, 0)
 	allOptions := make([]*Option, 0)
 	allCrypto := make([]*Crypto, 0)
 
-	err := d.db.NewSelect().Model(&allStocks).Where("stock_guild_id = ?", d.Guild).Scan(contxt)
+	err := d.db.NewSelect().Model(&allStocks).Where("stock_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get stocks. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model(&allShorts).Where("short_guild_id = ?", d.Guild).Scan(contxt)
+	err = d.db.NewSelect().Model(&allShorts).Where("short_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get shorts. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model(&allCrypto).Where("crypto_guild_id = ?", d.Guild).Scan(contxt)
+	err = d.db.NewSelect().Model(&allCrypto).Where("crypto_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get crypto. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	err = d.db.NewSelect().Model(&allOptions).Where("option_guild_id = ?", d.Guild).Scan(contxt)
+	err = d.db.NewSelect().Model(&allOptions).Where("option_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get options. There is probably a serious issue: %v.", err.Error()))
 		return nil, nil, nil, nil, err
 	}
 
-	chanMap.LoadOrStore(d.Guild, &sync.Map{})
+	d.reg.Track(d.Guild)
 
 	return allStocks, allShorts, allCrypto, allOptions, nil
 }
 
+// SplitOptionsCode breaks an option symbol into its root, contract type, day, month, four digit year and strike.
+// It accepts anything utils.ParseOptionSymbol does.
 func SplitOptionsCode(code string) (string, string, string, string, string, float32, error) {
-	var (
-		AlertID string
-		day     string
-		month   string
-		year    string
-		cType   string
-		price   float32
-	)
-
-	indexOffset := 0
-
-	switch len(code) {
-	case 16:
-		indexOffset = 0
-	case 17:
-		indexOffset = 1
-	case 18:
-		indexOffset = 2
-	case 19:
-		indexOffset = 3
-	default:
-		return "", "", "", "", "", -1, errors.New("invalid code - " + code)
+	symbol, err := utils.ParseOptionSymbol(code)
+	if err != nil {
+		return "", "", "", "", "", -1, invalidInput("invalid code - %v", err)
 	}
 
-	AlertID = code[:indexOffset+1] // 3
-	year = "20" + code[indexOffset+1:indexOffset+3]
-	month = code[indexOffset+3 : indexOffset+5]
-	day = code[indexOffset+5 : indexOffset+7]
-	cType = code[indexOffset+7 : indexOffset+8]
+	day, month, year := symbol.Date()
+	return symbol.Root, symbol.ContractType(), day, month, year, float32(symbol.StrikePrice()), nil
+}
 
-	p, err := strconv.ParseFloat(code[indexOffset+8:], 32)
-	if err != nil {
-		return "", "", "", "", "", -1, err
-	}
+// IsTradingHours reports whether NYSE is in its regular session, holidays and early closes included.
+func IsTradingHours() bool {
+	return IsTradingHoursWith(clock.System())
+}
 
-	price = float32(p / 1000)
+// IsTradingHoursWith is IsTradingHours telling the time with c. A nil c is the real clock.
+func IsTradingHoursWith(c clock.Clock) bool {
+	return IsTradingHoursAt(clock.OrSystem(c).Now())
+}
 
-	return AlertID, cType, day, month, year, price, nil
+// IsTradingHours reports whether NYSE is in its regular session by the DB's clock.
+func (d *DB) IsTradingHours() bool {
+	return IsTradingHoursAt(d.now())
 }
 
-func IsTradingHours() bool {
-	now, _ := carbon.NowInLocation("America/Detroit") //ET timezone
-	if now.IsWeekend() {
-		return false
-	}
+// IsTradingHoursAt reports whether NYSE is in its regular session at now.
+func IsTradingHoursAt(now time.Time) bool {
+	return calendar.IsOpen(now)
+}
 
-	nowHour := now.Hour()
-	nowMinute := now.Minute()
-	if (nowHour < 9) || (nowHour == 9 && nowMinute < 30) || (nowHour >= 16) { // NOTE - for 2 days a year the bot will sleep early due to DST
-		return false
+// WithClock returns a DB for the same guild and pool that tells the time with c.
+func (d *DB) WithClock(c clock.Clock) *DB {
+	return &DB{
+		Guild: d.Guild,
+		db:    d.db,
+		reg:   d.reg,
+		clock: clock.OrSystem(c),
 	}
+}
+
+func (d *DB) Clock() clock.Clock {
+	return clock.OrSystem(d.clock)
+}
 
-	return true
+func (d *DB) now() time.Time {
+	return d.Clock().Now()
 }
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the db package are wrapped with the alert or alerter involved; test for them with errors.Is.
+var (
+	// ErrNotFound means the alert, alerter or server does not exist.
+	ErrNotFound = errors.New("NOT FOUND")
+	// ErrNoMonitor means the alert exists but nothing is monitoring it, usually because the bot restarted without a refresh.
+	ErrNoMonitor = errors.New("NO MONITOR")
+	// ErrWrongGuild means the request named a guild other than the one the DB is scoped to.
+	ErrWrongGuild = errors.New("INCORRECT GUILD")
+	// ErrInvalidInput means an argument was malformed, such as a bad option code or expiry date.
+	ErrInvalidInput = errors.New("INVALID INPUT")
+	// ErrAlreadyExists means the thing being added is already there.
+	ErrAlreadyExists = errors.New("ALREADY EXISTS")
+	// ErrIllegalTransition means an alert was asked to move to a state its lifecycle does not allow from where it is.
+	ErrIllegalTransition = errors.New("ILLEGAL TRANSITION")
+)
+
+// notFound turns the driver's sql.ErrNoRows into ErrNotFound, describing what was missing; other errors pass through.
+func notFound(err error, what string, id interface{}) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%v %v : %w", what, id, ErrNotFound)
+	}
+	return err
+}
+
+func noMonitor(what, id string) error {
+	return fmt.Errorf("Unable to get alert channel for %v %v : %w. Please try recreating this alert, or calling !refresh then running this command again.", what, id, ErrNoMonitor)
+}
+
+func wrongGuild(guildID string) error {
+	return fmt.Errorf("Incorrect Guild %v : %w", guildID, ErrWrongGuild)
+}
+
+func inUse(assetType string) error {
+	return fmt.Errorf("%v ID is in use by another guild : %w", assetType, ErrAlreadyExists)
+}
+
+func invalidInput(format string, args ...interface{}) error {
+	return fmt.Errorf("%v : %w", fmt.Sprintf(format, args...), ErrInvalidInput)
+}
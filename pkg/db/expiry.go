@@ -0,0 +1,210 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/market"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// calendar decides when markets close for expiry.
+var calendar = market.NYSE()
+
+// expiry is when an alert with an explicit expiry, a unix time in seconds, or of alertType expires. Zero is never.
+func expiry(expiresAt int64, alertType int, callTime time.Time) time.Time {
+	if expiresAt != 0 {
+		return time.Unix(expiresAt, 0)
+	}
+	if alertType == utils.DAY {
+		return calendar.NextClose(callTime)
+	}
+	return time.Time{}
+}
+
+// ExpiresAt is when the stock expires: its expiry if it has one, otherwise the close it was called before for day
+// trades. Swings without an expiry never do.
+func (s Stock) ExpiresAt() time.Time {
+	return expiry(s.StockExpiry, s.AlertType, s.StockCallTime)
+}
+
+// ExpiresAt is when the short expires, as for stocks.
+func (s Short) ExpiresAt() time.Time {
+	return expiry(s.ShortExpiry, s.AlertType, s.ShortCallTime)
+}
+
+// ExpiresAt is when the crypto alert expires. Crypto never closes, so only an explicit expiry counts.
+func (c Crypto) ExpiresAt() time.Time {
+	if c.CryptoExpiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.CryptoExpiry, 0)
+}
+
+// ExpiresAt is when the option expires: the close on its expiration date, or on the session before it if the market
+// is shut that day, or for day trades the close it was called before if that is sooner. An unreadable expiration
+// date never expires.
+func (o Option) ExpiresAt() time.Time {
+	at := time.Time{}
+
+	year, yErr := strconv.Atoi(o.OptionYear)
+	month, mErr := strconv.Atoi(o.OptionMonth)
+	day, dErr := strconv.Atoi(o.OptionDay)
+	if yErr == nil && mErr == nil && dErr == nil {
+		at = calendar.LastClose(time.Date(year, time.Month(month), day, 0, 0, 0, 0, calendar.Location()))
+	}
+
+	if o.AlertType == utils.DAY {
+		if c := calendar.NextClose(o.OptionCallTime); at.IsZero() || c.Before(at) {
+			at = c
+		}
+	}
+	return at
+}
+
+func expired(at, now time.Time) bool {
+	return !at.IsZero() && !now.Before(at)
+}
+
+// PriceFunc returns the last price of symbol, of assetType, to close an expired alert at. Symbol is the ticker or
+// coin, or for options the contract code, and the price is the option's premium.
+type PriceFunc func(ctx context.Context, assetType, symbol string) (float32, error)
+
+// stateful is an alert that moves through the lifecycle.
+type stateful interface {
+	GetState() State
+	GetStateTimes() StateTimes
+	moveTo(ctx context.Context, to State, at time.Time) (*AuditEntry, error)
+}
+
+// saveState stores a's move out of its stored state from, with entry, failing with ErrIllegalTransition if it has
+// moved on since.
+type saveState func(ctx context.Context, t alertTable, uid string, from State, a stateful, entry *AuditEntry) error
+
+// sweepExpired expires every alert in r past its expiry at now: it moves the alert to StateExpired, then closes it
+// with CloseReasonExpired at the price prices gives, which also signals its monitor. Alerts with no price to hand are
+// archived with PriceUnknown. An alert that was already stopped out is closed as stopped instead, and one that moves
+// or goes while being expired is left to whoever moved it. No failure stops the sweep.
+func sweepExpired(ctx context.Context, r Repository, now time.Time, prices PriceFunc, save saveState) (*RemovalReport, error) {
+	stocks, shorts, cryptos, options, err := r.GetAllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RemovalReport{}
+	var sweepErr error
+	sweep := func(ids *[]string, t alertTable, uid, symbol string, stored State, a stateful, at time.Time, closeAlert func(context.Context, string, string, float32) (*ClosedAlert, error)) {
+		if !expired(at, now) {
+			return
+		}
+
+		reason := CloseReasonExpired
+		if from := a.GetState(); from != StateExpired {
+			entry, err := a.moveTo(ctx, StateExpired, now)
+			switch {
+			case errors.Is(err, ErrIllegalTransition):
+				reason = closeReason(from)
+			case err != nil:
+				sweepErr = err
+				return
+			default:
+				if err = save(ctx, t, uid, stored, a, entry); err != nil {
+					if !errors.Is(err, ErrIllegalTransition) {
+						log.Println(fmt.Sprintf("Unable to expire %v : %v", uid, err.Error()))
+						sweepErr = err
+					}
+					return
+				}
+			}
+		}
+
+		price := PriceUnknown
+		if prices != nil {
+			p, err := prices(ctx, t.assetType, symbol)
+			if err != nil {
+				log.Println(fmt.Sprintf("Unable to price expired %v %v, closing it without a price : %v", t.assetType, uid, err.Error()))
+			} else if p > 0 {
+				price = p
+			}
+		}
+
+		_, err := closeAlert(ctx, uid, reason, price)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			log.Println(fmt.Sprintf("Unable to expire %v : %v", uid, err.Error()))
+			sweepErr = err
+		default:
+			log.Println(fmt.Sprintf("Expired %v, due %v", uid, at))
+			*ids = append(*ids, uid)
+		}
+	}
+
+	for _, v := range stocks {
+		sweep(&report.Stocks, stockTable, v.StockAlertID, v.StockTicker, v.StockState, v, v.ExpiresAt(), r.CloseStockContext)
+	}
+	for _, v := range shorts {
+		sweep(&report.Shorts, shortTable, v.ShortAlertID, v.ShortTicker, v.ShortState, v, v.ExpiresAt(), r.CloseShortContext)
+	}
+	for _, v := range cryptos {
+		sweep(&report.Crypto, cryptoTable, v.CryptoAlertID, v.CryptoCoin, v.CryptoState, v, v.ExpiresAt(), r.CloseCryptoContext)
+	}
+	for _, v := range options {
+		symbol := v.OptionTicker
+		if s, err := v.Symbol(); err == nil {
+			symbol = s.String()
+		}
+		sweep(&report.Options, optionTable, v.OptionAlertID, symbol, v.OptionState, v, v.ExpiresAt(), r.CloseOptionContext)
+	}
+
+	return report, sweepErr
+}
+
+// SweepExpired expires every alert in the guild that is past its expiry at now, closing each at the price prices
+// gives, and reports which it closed. A nil prices closes them all with PriceUnknown.
+func (d *DB) SweepExpired(now time.Time, prices PriceFunc) (*RemovalReport, error) {
+	return d.SweepExpiredContext(context.Background(), now, prices)
+}
+
+func (d *DB) SweepExpiredContext(ctx context.Context, now time.Time, prices PriceFunc) (*RemovalReport, error) {
+	return sweepExpired(ctx, d, now, prices, func(ctx context.Context, t alertTable, uid string, from State, a stateful, entry *AuditEntry) error {
+		return d.setState(ctx, t, uid, from, a.GetState(), a.GetStateTimes(), entry)
+	})
+}
+
+// SweepEvery sweeps r for expired alerts every interval until ctx is done, pricing them with prices.
+func SweepEvery(ctx context.Context, r Repository, interval time.Duration, prices PriceFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.SweepExpiredContext(ctx, r.Clock().Now(), prices); err != nil && ctx.Err() == nil {
+			log.Println(fmt.Sprintf("Expiry sweep failed: %v", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+func TestSweepExpired(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		day, swing, dated, stopped, option := b.id("day"), b.id("swing"), b.id("dated"), b.id("stopped"), b.id("option")
+		due := start.Add(time.Hour)
+
+		dayExit, _, err := r.CreateStock(day, "AAPL", "caller", utils.DAY, 0, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock day", err)
+		_, _, err = r.CreateStock(swing, "AAPL", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock swing", err)
+		_, _, err = r.CreateShort(dated, "TSLA", "caller", utils.SWING, 0, 0, 0, 0, 0, due.Unix(), 200)
+		must(t, "CreateShort", err)
+		_, _, err = r.CreateStock(stopped, "MSFT", "caller", utils.SWING, 0, 0, 0, 0, 0, due.Unix(), 300)
+		must(t, "CreateStock stopped", err)
+		_, _, _, err = r.CreateOption(option, "oid", "caller", utils.SWING, "AAPL", "C", "14", "10", "2026", 150, 2, 0, 0, 0, 0, 145)
+		must(t, "CreateOption", err)
+		must(t, "StockTransition", r.StockTransition(stopped, StateStopped))
+
+		var priced []string
+		prices := func(ctx context.Context, assetType, symbol string) (float32, error) {
+			priced = append(priced, assetType+" "+symbol)
+			if assetType == AssetOption {
+				return 0, errors.New("no quote")
+			}
+			return 110, nil
+		}
+
+		report, err := r.SweepExpired(due.Add(-time.Minute), prices)
+		must(t, "SweepExpired before anything is due", err)
+		if report.Total() != 0 {
+			t.Fatalf("swept %+v before anything was due", report)
+		}
+
+		b.clock.Set(calendar.NextClose(start).Add(time.Minute))
+		report, err = r.SweepExpired(b.clock.Now(), prices)
+		must(t, "SweepExpired", err)
+
+		if len(report.Stocks) != 2 || len(report.Shorts) != 1 || len(report.Options) != 1 {
+			t.Errorf("expired %+v, want the day and stopped stocks, the dated short and the option", report)
+		}
+		if !signalled(dayExit) {
+			t.Error("the day trade's monitor was not signalled")
+		}
+		if _, err = r.GetStock(swing); err != nil {
+			t.Errorf("the swing without an expiry went: %v", err)
+		}
+		if len(priced) != 4 {
+			t.Errorf("priced %v, want every alert closed", priced)
+		}
+
+		closed, err := r.GetClosedAlerts(time.Time{})
+		must(t, "GetClosedAlerts", err)
+		byID := make(map[string]*ClosedAlert)
+		for _, c := range closed {
+			byID[c.AlertID] = c
+		}
+
+		for _, id := range []string{day, dated} {
+			c := byID[id]
+			if c == nil {
+				t.Fatalf("%v was not archived", id)
+			}
+			if c.CloseReason != CloseReasonExpired || c.ClosePrice != 110 || c.PriceUnknown {
+				t.Errorf("%v closed %v at %v (unknown %v), want expired at 110", id, c.CloseReason, c.ClosePrice, c.PriceUnknown)
+			}
+			if _, ok := c.States[StateExpired]; !ok {
+				t.Errorf("%v never went through %v: %v", id, StateExpired, c.States)
+			}
+		}
+		if c := byID[day]; c != nil && c.PctGain != 10 {
+			t.Errorf("day trade gained %v%%, want 10%% at its close price rather than its best", c.PctGain)
+		}
+
+		if c := byID[option]; c == nil || !c.PriceUnknown || c.ClosePrice != 0 || c.PctGain != 0 {
+			t.Errorf("option with no quote archived as %+v, want PriceUnknown with no price or gain", c)
+		}
+		if c := byID[stopped]; c == nil || c.CloseReason != CloseReasonStopped {
+			t.Errorf("stopped stock archived as %+v, want reason %v", c, CloseReasonStopped)
+		}
+
+		audit, err := r.GetAuditLogAlert(day)
+		must(t, "GetAuditLogAlert", err)
+		moved := false
+		for _, e := range audit {
+			if e.Action == AuditTransition && e.After == string(StateExpired) {
+				moved = true
+			}
+		}
+		if !moved {
+			t.Errorf("the audit log of %v doesn't show it expiring: %+v", day, audit)
+		}
+	})
+}
+
+func TestSweepExpiredWithoutPrices(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		id := b.id("day")
+
+		_, _, err := r.CreateStock(id, "AAPL", "caller", utils.DAY, 0, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		must(t, "StockSetNewHigh", r.StockSetNewHigh(id, 150))
+
+		b.clock.Set(calendar.NextClose(start))
+		_, err = r.SweepExpired(b.clock.Now(), nil)
+		must(t, "SweepExpired", err)
+
+		closed, err := r.GetClosedAlerts(time.Time{})
+		must(t, "GetClosedAlerts", err)
+		if len(closed) != 1 {
+			t.Fatalf("archived %v alerts, want 1", len(closed))
+		}
+		if c := closed[0]; !c.PriceUnknown || c.PctGain != 0 {
+			t.Errorf("expired without a price as %+v, want PriceUnknown rather than its 50%% best", c)
+		}
+	})
+}
+
+func TestOptionExpiresAt(t *testing.T) {
+	ny := calendar.Location()
+	tests := []struct {
+		name             string
+		day, month, year string
+		alertType        int
+		want             time.Time
+	}{
+		{"trading day", "16", "10", "2026", utils.SWING, time.Date(2026, 10, 16, 16, 0, 0, 0, ny)},
+		{"early close", "27", "11", "2026", utils.SWING, time.Date(2026, 11, 27, 13, 0, 0, 0, ny)},
+		{"good friday", "26", "03", "2027", utils.SWING, time.Date(2027, 3, 25, 16, 0, 0, 0, ny)},
+		{"saturday", "17", "10", "2026", utils.SWING, time.Date(2026, 10, 16, 16, 0, 0, 0, ny)},
+		{"day trade", "18", "12", "2026", utils.DAY, time.Date(2026, 10, 14, 16, 0, 0, 0, ny)},
+		{"unreadable", "xx", "10", "2026", utils.SWING, time.Time{}},
+	}
+	for _, tt := range tests {
+		o := Option{OptionDay: tt.day, OptionMonth: tt.month, OptionYear: tt.year, AlertType: tt.alertType, OptionCallTime: start}
+		if got := o.ExpiresAt(); !got.Equal(tt.want) {
+			t.Errorf("%v: ExpiresAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
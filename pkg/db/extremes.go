@@ -0,0 +1,95 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/uptrace/bun"
+)
+
+// extreme describes a running high (or a short's running low) column that may only ever move one way.
+type extreme struct {
+	alertTable
+	column string
+	field  string
+	lower  bool
+}
+
+var (
+	stockHigh  = extreme{stockTable, "stock_highest", "highest", false}
+	shortLow   = extreme{shortTable, "short_lowest", "lowest", true}
+	cryptoHigh = extreme{cryptoTable, "crypto_highest", "highest", false}
+	optionHigh = extreme{optionTable, "option_highest", "highest", false}
+)
+
+// move sets the column to price in one conditional UPDATE, only if that moves it in the right direction,
+// and reports whether it did. The old value is read under a row lock in the same statement for the audit log.
+func (d *DB) move(ctx context.Context, e extreme, uid string, price float32) (bool, error) {
+	cmp := "<"
+	if e.lower {
+		cmp = ">"
+	}
+
+	moved := false
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var (
+			before float32
+			caller string
+		)
+
+		_, err := tx.NewUpdate().Model(e.model).
+			TableExpr("(SELECT ?, ? FROM ? WHERE ? = ? AND ? = ? FOR UPDATE) AS old",
+				bun.Ident(e.pk), bun.Ident(e.column), bun.Ident(e.table), bun.Ident(e.pk), uid, bun.Ident(e.guild), d.Guild).
+			Set("? = ?", bun.Ident(e.column), price).
+			Where("?.? = old.?", bun.Ident(e.alias), bun.Ident(e.pk), bun.Ident(e.pk)).
+			Where("?.? "+cmp+" CAST(? AS REAL)", bun.Ident(e.alias), bun.Ident(e.column), price).
+			Returning("old.?, ?.caller", bun.Ident(e.column), bun.Ident(e.alias)).
+			Exec(ctx, &before, &caller)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		moved = true
+		entry := newAuditEntry(ctx, AuditUpdate, d.Guild, uid, e.assetType, caller, nil).change(e.field, before, price)
+		return writeAudit(ctx, tx, d.now(), entry)
+	})
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to update %v %v : %v", e.assetType, uid, err.Error()))
+		return false, err
+	}
+
+	if !moved {
+		exists, err := d.db.NewSelect().Model(e.model).Where("? = ?", bun.Ident(e.pk), uid).Where("? = ?", bun.Ident(e.guild), d.Guild).Exists(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, fmt.Errorf("Unable to update %v %v : %w", e.assetType, uid, ErrNotFound)
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,306 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// The kinds of fill in an alert's ledger. A buy opens the position, adds scale into it, trims scale out of it and a
+// sell closes whatever is left. FillAvg is what SetNewAvg records: the caller declaring a new average cost outright.
+const (
+	FillBuy  = "buy"
+	FillAdd  = "add"
+	FillTrim = "trim"
+	FillSell = "sell"
+	FillAvg  = "avg"
+)
+
+// Fill is one entry in an alert's ledger. Size is in whatever units the caller uses; alerts open with a size of 1.
+// ClosedID is zero until the alert is closed, when its ledger is kept with the ClosedAlert it was archived as.
+type Fill struct {
+	FillID    int64 `bun:",pk,autoincrement"`
+	ClosedID  int64 `bun:",nullzero"`
+	GuildID   string
+	AlertID   string
+	AssetType string
+	Kind      string
+	Price     float32
+	Size      float32
+	Caller    string
+	At        time.Time
+}
+
+// Position is what a ledger adds up to. Realized is the % gained on everything sold so far, against the average
+// cost it was sold from; Short positions gain as the price falls.
+type Position struct {
+	Entry    float32
+	AvgCost  float32
+	Size     float32
+	Bought   float32
+	Sold     float32
+	Realized float32
+	Short    bool
+
+	realizedPnL float32
+	soldCost    float32
+}
+
+// NewPosition replays fills in order. Sells and trims larger than what is left only close what is left.
+func NewPosition(fills []*Fill, short bool) Position {
+	p := Position{Short: short}
+	for _, f := range fills {
+		p.apply(f)
+	}
+	return p
+}
+
+func (p *Position) apply(f *Fill) {
+	switch f.Kind {
+	case FillBuy, FillAdd:
+		if p.Entry == 0 {
+			p.Entry = f.Price
+		}
+		if p.Size+f.Size > 0 {
+			p.AvgCost = (p.AvgCost*p.Size + f.Price*f.Size) / (p.Size + f.Size)
+		}
+		p.Size += f.Size
+		p.Bought += f.Size
+	case FillTrim, FillSell:
+		size := f.Size
+		if size == 0 || size > p.Size || f.Kind == FillSell {
+			size = p.Size
+		}
+		p.realizedPnL += p.gain(p.AvgCost, f.Price) * size
+		p.soldCost += p.AvgCost * size
+		p.Size -= size
+		p.Sold += size
+		if p.soldCost != 0 {
+			p.Realized = p.realizedPnL / p.soldCost * 100
+		}
+	case FillAvg:
+		p.AvgCost = f.Price
+	}
+}
+
+func (p Position) gain(from, to float32) float32 {
+	if p.Short {
+		return from - to
+	}
+	return to - from
+}
+
+// Open reports whether any of the position is left.
+func (p Position) Open() bool {
+	return p.Size > 0
+}
+
+// Unrealized is the % gain at price on what is still held, or 0 once it has all been sold.
+func (p Position) Unrealized(price float32) float32 {
+	if !p.Open() || p.AvgCost == 0 {
+		return 0
+	}
+	return p.gain(p.AvgCost, price) / p.AvgCost * 100
+}
+
+// check refuses fills that make no sense against p.
+func (p Position) check(kind string, price, size float32) error {
+	if price <= 0 {
+		return invalidInput("fill price %v must be above zero", price)
+	}
+	if size < 0 {
+		return invalidInput("fill size %v cannot be negative", size)
+	}
+
+	switch kind {
+	case FillBuy:
+		if p.Open() {
+			return invalidInput("position is already open, add to it instead")
+		}
+	case FillAdd, FillTrim, FillSell, FillAvg:
+		if !p.Open() {
+			return invalidInput("there is no open position to %v", kind)
+		}
+	default:
+		return invalidInput("unknown fill kind %q", kind)
+	}
+
+	if (kind == FillBuy || kind == FillAdd) && size == 0 {
+		return invalidInput("a %v needs a size", kind)
+	}
+	if kind == FillTrim && size >= p.Size {
+		return invalidInput("cannot trim %v of %v, sell it instead", size, p.Size)
+	}
+	return nil
+}
+
+// openingFill is the buy an alert is created with.
+func openingFill(guildID, uid, assetType, caller string, price float32, at time.Time) *Fill {
+	return &Fill{
+		GuildID:   guildID,
+		AlertID:   uid,
+		AssetType: assetType,
+		Kind:      FillBuy,
+		Price:     price,
+		Size:      1,
+		Caller:    caller,
+		At:        at,
+	}
+}
+
+func (d *DB) fills(ctx context.Context, idb bun.IDB, assetType, uid string) ([]*Fill, error) {
+	fills := make([]*Fill, 0)
+	err := idb.NewSelect().Model(&fills).
+		Where("guild_id = ?", d.Guild).
+		Where("asset_type = ?", assetType).
+		Where("alert_id = ?", uid).
+		Where("closed_id IS NULL").
+		Order("at", "fill_id").
+		Scan(ctx)
+	return fills, err
+}
+
+// AddFill records a fill against an alert and returns the position it leaves. The alert's starting price follows the
+// average cost while any of the position is open, so everything reading it keeps working; the original entry stays
+// in the ledger. A sell with no size sells whatever is left.
+func (d *DB) AddFill(assetType, uid, kind string, price, size float32) (*Position, error) {
+	return d.AddFillContext(context.Background(), assetType, uid, kind, price, size)
+}
+
+func (d *DB) AddFillContext(ctx context.Context, assetType, uid, kind string, price, size float32) (*Position, error) {
+	t, err := tableFor(assetType)
+	if err != nil {
+		return nil, err
+	}
+
+	var pos Position
+	err = d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var caller string
+		err := tx.NewSelect().Model(t.model).Column("caller").
+			Where("? = ?", bun.Ident(t.pk), uid).
+			Where("? = ?", bun.Ident(t.guild), d.Guild).
+			For("UPDATE").
+			Scan(ctx, &caller)
+		if err != nil {
+			return notFound(err, assetType, uid)
+		}
+
+		fills, err := d.fills(ctx, tx, assetType, uid)
+		if err != nil {
+			return err
+		}
+		pos = NewPosition(fills, assetType == AssetShort)
+		if err = pos.check(kind, price, size); err != nil {
+			return err
+		}
+
+		if kind == FillSell {
+			size = pos.Size
+		}
+		fill := &Fill{
+			GuildID:   d.Guild,
+			AlertID:   uid,
+			AssetType: assetType,
+			Kind:      kind,
+			Price:     price,
+			Size:      size,
+			Caller:    caller,
+			At:        d.now(),
+		}
+		if _, err = tx.NewInsert().Model(fill).Exec(ctx); err != nil {
+			return err
+		}
+
+		before := pos.AvgCost
+		pos.apply(fill)
+		if pos.Open() && pos.AvgCost != before {
+			_, err = tx.NewUpdate().Model(t.model).
+				Set("? = ?", bun.Ident(t.starting), pos.AvgCost).
+				Where("? = ?", bun.Ident(t.pk), uid).
+				Where("? = ?", bun.Ident(t.guild), d.Guild).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return writeAudit(ctx, tx, d.now(), newAuditEntry(ctx, AuditUpdate, d.Guild, uid, assetType, caller, nil).change(kind, "", fmt.Sprintf("%v @ %v", size, price)))
+	})
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to add %v fill to %v %v : %v", kind, assetType, uid, err.Error()))
+		return nil, err
+	}
+	return &pos, nil
+}
+
+// GetFills returns an alert's ledger, oldest first.
+func (d *DB) GetFills(assetType, uid string) ([]*Fill, error) {
+	return d.GetFillsContext(context.Background(), assetType, uid)
+}
+
+func (d *DB) GetFillsContext(ctx context.Context, assetType, uid string) ([]*Fill, error) {
+	fills, err := d.fills(ctx, d.db, assetType, uid)
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get fills for %v %v : %v", assetType, uid, err.Error()))
+		return nil, err
+	}
+	return fills, nil
+}
+
+// GetPosition adds up an alert's ledger.
+func (d *DB) GetPosition(assetType, uid string) (*Position, error) {
+	return d.GetPositionContext(context.Background(), assetType, uid)
+}
+
+func (d *DB) GetPositionContext(ctx context.Context, assetType, uid string) (*Position, error) {
+	fills, err := d.GetFillsContext(ctx, assetType, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(fills) == 0 {
+		return nil, notFound(sql.ErrNoRows, assetType+" ledger", uid)
+	}
+
+	pos := NewPosition(fills, assetType == AssetShort)
+	return &pos, nil
+}
+
+// GetClosedFills returns the ledger a closed alert had when it was closed, in order. NewPosition adds it up.
+func (d *DB) GetClosedFills(closedID int64) ([]*Fill, error) {
+	return d.GetClosedFillsContext(context.Background(), closedID)
+}
+
+func (d *DB) GetClosedFillsContext(ctx context.Context, closedID int64) ([]*Fill, error) {
+	fills := make([]*Fill, 0)
+	err := d.db.NewSelect().Model(&fills).
+		Where("guild_id = ?", d.Guild).
+		Where("closed_id = ?", closedID).
+		Order("at", "fill_id").
+		Scan(ctx)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get fills for closed alert %v : %v", closedID, err.Error()))
+		return nil, err
+	}
+	return fills, nil
+}
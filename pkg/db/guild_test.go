@@ -0,0 +1,252 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// TestGuildIsolation has guild a make one alert of each type, with targets and fills, and guild b try everything on
+// them. Nothing b does may see or change them.
+func TestGuildIsolation(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		a, other := b.repo("a"), b.repo("b")
+		stock, short, coin, option, gone := b.id("stock"), b.id("short"), b.id("coin"), b.id("option"), b.id("gone")
+
+		_, _, err := a.CreateStock(stock, "AAPL", "caller", utils.SWING, 110, 0, 95, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, _, err = a.CreateShort(short, "TSLA", "caller", utils.SWING, 0, 0, 210, 0, 0, 0, 200)
+		must(t, "CreateShort", err)
+		_, _, err = a.CreateCrypto(coin, "BTC", "caller", 0, 0, 19000, 0, 0, utils.SWING, 20000)
+		must(t, "CreateCrypto", err)
+		_, _, _, err = a.CreateOption(option, "oid", "caller", utils.SWING, "AAPL", "C", "20", "11", "2026", 150, 2, 0, 140, 0, 0, 145)
+		must(t, "CreateOption", err)
+		_, _, err = a.CreateStock(gone, "MSFT", "caller", utils.SWING, 310, 0, 0, 0, 0, 0, 300)
+		must(t, "CreateStock gone", err)
+		_, err = a.AddFill(AssetStock, stock, FillAdd, 90, 1)
+		must(t, "AddFill", err)
+		targets, err := a.GetTargets(AssetStock, stock)
+		must(t, "GetTargets", err)
+		if len(targets) != 1 {
+			t.Fatalf("the stock has %d targets, want 1", len(targets))
+		}
+		target := targets[0].TargetID
+		closed, err := a.CloseStock(gone, CloseReasonTarget, 310)
+		must(t, "CloseStock gone", err)
+
+		// Reads.
+		_, err = other.GetStock(stock)
+		wantErr(t, "GetStock", err, ErrNotFound)
+		_, err = other.GetShort(short)
+		wantErr(t, "GetShort", err, ErrNotFound)
+		_, err = other.GetCrypto(coin)
+		wantErr(t, "GetCrypto", err, ErrNotFound)
+		_, err = other.GetOption(option)
+		wantErr(t, "GetOption", err, ErrNotFound)
+
+		stocks, shorts, crypto, options, err := other.GetAll()
+		must(t, "GetAll", err)
+		if n := len(stocks) + len(shorts) + len(crypto) + len(options); n != 0 {
+			t.Errorf("GetAll returned %d of the other guild's alerts", n)
+		}
+		stocks, shorts, crypto, options, err = other.GetAllCaller("caller")
+		must(t, "GetAllCaller", err)
+		if n := len(stocks) + len(shorts) + len(crypto) + len(options); n != 0 {
+			t.Errorf("GetAllCaller returned %d of the other guild's alerts", n)
+		}
+
+		entries, err := other.GetAuditLog(time.Time{})
+		must(t, "GetAuditLog", err)
+		if len(entries) != 0 {
+			t.Errorf("GetAuditLog returned %d of the other guild's entries", len(entries))
+		}
+		entries, err = other.GetAuditLogAlert(stock)
+		must(t, "GetAuditLogAlert", err)
+		if len(entries) != 0 {
+			t.Errorf("GetAuditLogAlert returned %d of the other guild's entries", len(entries))
+		}
+		entries, err = other.GetAuditLogCaller("caller")
+		must(t, "GetAuditLogCaller", err)
+		if len(entries) != 0 {
+			t.Errorf("GetAuditLogCaller returned %d of the other guild's entries", len(entries))
+		}
+		entries, err = other.GetAuditLogActor(SystemActor)
+		must(t, "GetAuditLogActor", err)
+		if len(entries) != 0 {
+			t.Errorf("GetAuditLogActor returned %d of the other guild's entries", len(entries))
+		}
+
+		archived, err := other.GetClosedAlerts(time.Time{})
+		must(t, "GetClosedAlerts", err)
+		if len(archived) != 0 {
+			t.Errorf("GetClosedAlerts returned %d of the other guild's alerts", len(archived))
+		}
+		archived, err = other.GetClosedAlertsCaller("caller", time.Time{})
+		must(t, "GetClosedAlertsCaller", err)
+		if len(archived) != 0 {
+			t.Errorf("GetClosedAlertsCaller returned %d of the other guild's alerts", len(archived))
+		}
+
+		targets, err = other.GetTargets(AssetStock, stock)
+		must(t, "GetTargets", err)
+		if len(targets) != 0 {
+			t.Errorf("GetTargets returned %d of the other guild's targets", len(targets))
+		}
+		targets, err = other.GetClosedTargets(closed.ClosedID)
+		must(t, "GetClosedTargets", err)
+		if len(targets) != 0 {
+			t.Errorf("GetClosedTargets returned %d of the other guild's targets", len(targets))
+		}
+		fills, err := other.GetFills(AssetStock, stock)
+		must(t, "GetFills", err)
+		if len(fills) != 0 {
+			t.Errorf("GetFills returned %d of the other guild's fills", len(fills))
+		}
+		fills, err = other.GetClosedFills(closed.ClosedID)
+		must(t, "GetClosedFills", err)
+		if len(fills) != 0 {
+			t.Errorf("GetClosedFills returned %d of the other guild's fills", len(fills))
+		}
+
+		// Writes.
+		_, err = other.StockUpdateHigh(stock, 500)
+		wantErr(t, "StockUpdateHigh", err, ErrNotFound)
+		wantErr(t, "StockSetNewHigh", other.StockSetNewHigh(stock, 500), ErrNotFound)
+		wantErr(t, "ShortSetNewHigh", other.ShortSetNewHigh(short, 1), ErrNotFound)
+		wantErr(t, "CryptoSetNewHigh", other.CryptoSetNewHigh(coin, 50000), ErrNotFound)
+		wantErr(t, "OptionSetNewHigh", other.OptionSetNewHigh(option, 50), ErrNotFound)
+
+		wantErr(t, "StockPOIHit", other.StockPOIHit(stock), ErrNotFound)
+		wantErr(t, "ShortPOIHit", other.ShortPOIHit(short), ErrNotFound)
+		wantErr(t, "CryptoPOIHit", other.CryptoPOIHit(coin), ErrNotFound)
+		wantErr(t, "OptionPOIHit", other.OptionPOIHit(option), ErrNotFound)
+		wantErr(t, "StockTransition", other.StockTransition(stock, StateStopped), ErrNotFound)
+		_, err = other.SwitchOptionsTypeByCode(option)
+		wantErr(t, "SwitchOptionsTypeByCode", err, ErrNotFound)
+
+		_, err = other.AddTarget(AssetStock, stock, TargetPrice, 130)
+		wantErr(t, "AddTarget", err, ErrNotFound)
+		_, err = other.HitTarget(target, 111)
+		wantErr(t, "HitTarget", err, ErrNotFound)
+		wantErr(t, "RemoveTarget", other.RemoveTarget(target), ErrNotFound)
+		_, err = other.AddFill(AssetStock, stock, FillAdd, 80, 1)
+		wantErr(t, "AddFill", err, ErrNotFound)
+
+		_, err = other.CloseStock(stock, CloseReasonRemoved, 0)
+		wantErr(t, "CloseStock", err, ErrNotFound)
+		_, err = other.CloseShort(short, CloseReasonRemoved, 0)
+		wantErr(t, "CloseShort", err, ErrNotFound)
+		_, err = other.CloseCrypto(coin, CloseReasonRemoved, 0)
+		wantErr(t, "CloseCrypto", err, ErrNotFound)
+		_, err = other.CloseOption(option, CloseReasonRemoved, 0)
+		wantErr(t, "CloseOption", err, ErrNotFound)
+		wantErr(t, "RemoveStock", other.RemoveStock(stock), ErrNotFound)
+		wantErr(t, "RemoveShort", other.RemoveShort(short), ErrNotFound)
+		wantErr(t, "RemoveCrypto", other.RemoveCrypto(coin), ErrNotFound)
+		wantErr(t, "RemoveOptionByCode", other.RemoveOptionByCode(option), ErrNotFound)
+
+		report, err := other.RmAll()
+		must(t, "RmAll", err)
+		if report.Total() != 0 {
+			t.Errorf("RmAll removed %+v from the other guild", report)
+		}
+		b.clock.Set(time.Date(2027, time.January, 4, 17, 0, 0, 0, calendar.Location()))
+		report, err = other.SweepExpired(b.clock.Now(), func(ctx context.Context, assetType, symbol string) (float32, error) {
+			return 1, nil
+		})
+		must(t, "SweepExpired", err)
+		if report.Total() != 0 {
+			t.Errorf("SweepExpired closed %+v from the other guild", report)
+		}
+
+		// Guild a's alerts are as it left them.
+		s, err := a.GetStock(stock)
+		must(t, "GetStock in its own guild", err)
+		if s.StockHighest != 100 || s.StockPOIHit || s.GetState() != StatePending {
+			t.Errorf("the stock was changed from the other guild: %+v", s)
+		}
+		o, err := a.GetOption(option)
+		must(t, "GetOption in its own guild", err)
+		if o.AlertType != utils.SWING || o.OptionUnderlyingPOIHit {
+			t.Errorf("the option was changed from the other guild: %+v", o)
+		}
+		targets, err = a.GetTargets(AssetStock, stock)
+		must(t, "GetTargets in its own guild", err)
+		if len(targets) != 1 || targets[0].Hit() {
+			t.Errorf("the stock's targets were changed from the other guild: %+v", targets)
+		}
+		fills, err = a.GetFills(AssetStock, stock)
+		must(t, "GetFills in its own guild", err)
+		if len(fills) != 2 {
+			t.Errorf("the stock's fills were changed from the other guild: %+v", fills)
+		}
+		_, err = a.GetShort(short)
+		must(t, "GetShort in its own guild", err)
+		_, err = a.GetCrypto(coin)
+		must(t, "GetCrypto in its own guild", err)
+	})
+}
+
+// TestCreateInUse has guild b create alerts with IDs guild a already uses. They must be refused, not overwrite a's.
+func TestCreateInUse(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		a, other := b.repo("a"), b.repo("b")
+		stock, short, coin, option := b.id("stock"), b.id("short"), b.id("coin"), b.id("option")
+
+		_, _, err := a.CreateStock(stock, "AAPL", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, _, err = a.CreateShort(short, "TSLA", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 200)
+		must(t, "CreateShort", err)
+		_, _, err = a.CreateCrypto(coin, "BTC", "caller", 0, 0, 0, 0, 0, utils.SWING, 20000)
+		must(t, "CreateCrypto", err)
+		_, _, _, err = a.CreateOption(option, "oid", "caller", utils.SWING, "AAPL", "C", "20", "11", "2026", 150, 2, 0, 0, 0, 0, 145)
+		must(t, "CreateOption", err)
+
+		_, _, err = other.CreateStock(stock, "GME", "thief", utils.SWING, 0, 0, 0, 0, 0, 0, 1)
+		wantErr(t, "CreateStock", err, ErrAlreadyExists)
+		_, _, err = other.CreateShort(short, "GME", "thief", utils.SWING, 0, 0, 0, 0, 0, 0, 1)
+		wantErr(t, "CreateShort", err, ErrAlreadyExists)
+		_, _, err = other.CreateCrypto(coin, "DOGE", "thief", 0, 0, 0, 0, 0, utils.SWING, 1)
+		wantErr(t, "CreateCrypto", err, ErrAlreadyExists)
+		_, _, _, err = other.CreateOption(option, "oid", "thief", utils.SWING, "GME", "P", "20", "11", "2026", 10, 1, 0, 0, 0, 0, 1)
+		wantErr(t, "CreateOption", err, ErrAlreadyExists)
+
+		s, err := a.GetStock(stock)
+		must(t, "GetStock", err)
+		if s.StockTicker != "AAPL" || s.Caller != "caller" || s.StockStarting != 100 {
+			t.Errorf("the stock was overwritten: %+v", s)
+		}
+		o, err := a.GetOption(option)
+		must(t, "GetOption", err)
+		if o.OptionTicker != "AAPL" || o.Caller != "caller" {
+			t.Errorf("the option was overwritten: %+v", o)
+		}
+		_, err = other.GetStock(stock)
+		wantErr(t, "GetStock from the refused guild", err, ErrNotFound)
+		if other.Registry().Has(b.id("b"), stock) {
+			t.Error("the refused stock was left registered")
+		}
+		entries, err := other.GetAuditLog(time.Time{})
+		must(t, "GetAuditLog", err)
+		if len(entries) != 0 {
+			t.Errorf("the refused creates were audited: %+v", entries)
+		}
+	})
+}
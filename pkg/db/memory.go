@@ -0,0 +1,1974 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// memStore holds the rows for every guild, the same way the Postgres tables do.
+type memStore struct {
+	mu       sync.RWMutex
+	stocks   map[string]Stock
+	shorts   map[string]Short
+	crypto   map[string]Crypto
+	options  map[string]Option
+	channels map[string]Channel
+	closed   []ClosedAlert
+	audits   []AuditEntry
+	targets  []Target
+	targetID int64
+	fills    []Fill
+	fillID   int64
+}
+
+// audit appends entries to the log. The caller must hold mu.
+func (ms *memStore) audit(at time.Time, entries ...*AuditEntry) {
+	for _, e := range entries {
+		e.At = at
+		e.AuditID = int64(len(ms.audits) + 1)
+		ms.audits = append(ms.audits, *e)
+	}
+}
+
+// archive stores c with the next ClosedID, files its targets and fills under it and returns a copy. The caller must
+// hold mu.
+func (ms *memStore) archive(c *ClosedAlert) *ClosedAlert {
+	c.ClosedID = int64(len(ms.closed) + 1)
+	ms.closed = append(ms.closed, *c)
+
+	for i, t := range ms.targets {
+		if t.ClosedID == 0 && t.GuildID == c.GuildID && t.AssetType == c.AssetType && t.AlertID == c.AlertID {
+			ms.targets[i].ClosedID = c.ClosedID
+		}
+	}
+	for i, f := range ms.fills {
+		if f.ClosedID == 0 && f.GuildID == c.GuildID && f.AssetType == c.AssetType && f.AlertID == c.AlertID {
+			ms.fills[i].ClosedID = c.ClosedID
+		}
+	}
+	return c
+}
+
+// reset drops the open targets and fills of the guild's alert uid of assetType, for when it is being replaced. The
+// caller must hold mu.
+func (ms *memStore) reset(guildID, assetType, uid string) {
+	open := func(g, a, id string, closedID int64) bool {
+		return closedID == 0 && g == guildID && a == assetType && id == uid
+	}
+
+	targets := ms.targets[:0]
+	for _, t := range ms.targets {
+		if !open(t.GuildID, t.AssetType, t.AlertID, t.ClosedID) {
+			targets = append(targets, t)
+		}
+	}
+	ms.targets = targets
+
+	fills := ms.fills[:0]
+	for _, f := range ms.fills {
+		if !open(f.GuildID, f.AssetType, f.AlertID, f.ClosedID) {
+			fills = append(fills, f)
+		}
+	}
+	ms.fills = fills
+}
+
+// addFill stores f with the next FillID. The caller must hold mu.
+func (ms *memStore) addFill(f *Fill) {
+	ms.fillID++
+	f.FillID = ms.fillID
+	ms.fills = append(ms.fills, *f)
+}
+
+// fillsOf returns the ledger of the guild's open alert uid of assetType. The caller must hold mu.
+func (ms *memStore) fillsOf(guildID, assetType, uid string) []*Fill {
+	fills := make([]*Fill, 0)
+	for _, f := range ms.fills {
+		if f.ClosedID == 0 && f.GuildID == guildID && f.AssetType == assetType && f.AlertID == uid {
+			f := f
+			fills = append(fills, &f)
+		}
+	}
+	return fills
+}
+
+// setStarting moves the starting price of the guild's alert uid of assetType. The caller must hold mu.
+func (ms *memStore) setStarting(guildID, assetType, uid string, price float32) {
+	switch assetType {
+	case AssetStock:
+		if v, ok := ms.stockOf(guildID, uid); ok {
+			v.StockStarting = price
+			ms.stocks[uid] = v
+		}
+	case AssetShort:
+		if v, ok := ms.shortOf(guildID, uid); ok {
+			v.ShortStarting = price
+			ms.shorts[uid] = v
+		}
+	case AssetCrypto:
+		if v, ok := ms.cryptoOf(guildID, uid); ok {
+			v.CryptoStarting = price
+			ms.crypto[uid] = v
+		}
+	case AssetOption:
+		if v, ok := ms.optionOf(guildID, uid); ok {
+			v.OptionStarting = price
+			ms.options[uid] = v
+		}
+	}
+}
+
+// addTarget stores t with the next TargetID unless the alert already has that target. The caller must hold mu.
+func (ms *memStore) addTarget(t *Target) bool {
+	for _, v := range ms.targets {
+		if v.ClosedID == 0 && v.GuildID == t.GuildID && v.AssetType == t.AssetType && v.AlertID == t.AlertID && v.Series == t.Series && v.Price == t.Price {
+			return false
+		}
+	}
+	ms.targetID++
+	t.TargetID = ms.targetID
+	ms.targets = append(ms.targets, *t)
+	return true
+}
+
+// targetOf returns the index of the guild's open target targetID. The caller must hold mu.
+func (ms *memStore) targetOf(guildID string, targetID int64) (int, bool) {
+	for i, v := range ms.targets {
+		if v.TargetID == targetID && v.GuildID == guildID && v.ClosedID == 0 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// setState moves the guild's alert uid of assetType to state and times, provided it is still in its stored state
+// from. The caller must hold mu.
+func (ms *memStore) setState(guildID, assetType, uid string, from, state State, times StateTimes) bool {
+	switch assetType {
+	case AssetStock:
+		if v, ok := ms.stockOf(guildID, uid); ok && v.StockState == from {
+			v.StockState, v.StockStateTimes = state, times
+			ms.stocks[uid] = v
+			return true
+		}
+	case AssetShort:
+		if v, ok := ms.shortOf(guildID, uid); ok && v.ShortState == from {
+			v.ShortState, v.ShortStateTimes = state, times
+			ms.shorts[uid] = v
+			return true
+		}
+	case AssetCrypto:
+		if v, ok := ms.cryptoOf(guildID, uid); ok && v.CryptoState == from {
+			v.CryptoState, v.CryptoStateTimes = state, times
+			ms.crypto[uid] = v
+			return true
+		}
+	case AssetOption:
+		if v, ok := ms.optionOf(guildID, uid); ok && v.OptionState == from {
+			v.OptionState, v.OptionStateTimes = state, times
+			ms.options[uid] = v
+			return true
+		}
+	}
+	return false
+}
+
+// targetsWhere returns copies of the targets keep accepts, by series then price. The caller must hold mu.
+func (ms *memStore) targetsWhere(keep func(t Target) bool) []*Target {
+	targets := make([]*Target, 0)
+	for _, v := range ms.targets {
+		if keep(v) {
+			v := v
+			targets = append(targets, &v)
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Series != targets[j].Series {
+			return targets[i].Series < targets[j].Series
+		}
+		return targets[i].Price < targets[j].Price
+	})
+	return targets
+}
+
+// callerOf returns who called the guild's alert uid of assetType. The caller must hold mu.
+func (ms *memStore) callerOf(guildID, assetType, uid string) (string, bool) {
+	switch assetType {
+	case AssetStock:
+		v, ok := ms.stockOf(guildID, uid)
+		return v.Caller, ok
+	case AssetShort:
+		v, ok := ms.shortOf(guildID, uid)
+		return v.Caller, ok
+	case AssetCrypto:
+		v, ok := ms.cryptoOf(guildID, uid)
+		return v.Caller, ok
+	case AssetOption:
+		v, ok := ms.optionOf(guildID, uid)
+		return v.Caller, ok
+	}
+	return "", false
+}
+
+// MemoryDB is an in-process Repository, for running Kronos (or its tests) without Postgres.
+type MemoryDB struct {
+	Guild string
+	store *memStore
+	reg   *AlertRegistry
+	clock clock.Clock
+}
+
+func NewMemoryDB(guildID string) *MemoryDB {
+	return &MemoryDB{
+		Guild: guildID,
+		store: &memStore{
+			stocks:   make(map[string]Stock),
+			shorts:   make(map[string]Short),
+			crypto:   make(map[string]Crypto),
+			options:  make(map[string]Option),
+			channels: make(map[string]Channel),
+		},
+		reg:   NewAlertRegistry(),
+		clock: clock.System(),
+	}
+}
+
+// stockOf and friends only find alerts belonging to guildID. The caller must hold mu.
+func (ms *memStore) stockOf(guildID, uid string) (Stock, bool) {
+	v, ok := ms.stocks[uid]
+	return v, ok && v.StockGuildID == guildID
+}
+
+func (ms *memStore) shortOf(guildID, uid string) (Short, bool) {
+	v, ok := ms.shorts[uid]
+	return v, ok && v.ShortGuildID == guildID
+}
+
+func (ms *memStore) cryptoOf(guildID, uid string) (Crypto, bool) {
+	v, ok := ms.crypto[uid]
+	return v, ok && v.CryptoGuildID == guildID
+}
+
+func (ms *memStore) optionOf(guildID, uid string) (Option, bool) {
+	v, ok := ms.options[uid]
+	return v, ok && v.OptionGuildID == guildID
+}
+
+// WithGuild returns a MemoryDB for another guild backed by the same store.
+func (m *MemoryDB) WithGuild(guildID string) *MemoryDB {
+	return &MemoryDB{
+		Guild: guildID,
+		store: m.store,
+		reg:   m.reg,
+		clock: m.clock,
+	}
+}
+
+// WithClock returns a MemoryDB for the same guild and store that tells the time with c.
+func (m *MemoryDB) WithClock(c clock.Clock) *MemoryDB {
+	return &MemoryDB{
+		Guild: m.Guild,
+		store: m.store,
+		reg:   m.reg,
+		clock: clock.OrSystem(c),
+	}
+}
+
+func (m *MemoryDB) Clock() clock.Clock {
+	return m.clock
+}
+
+func (m *MemoryDB) now() time.Time {
+	return m.clock.Now()
+}
+
+// IsTradingHours reports whether NYSE is in its regular session by the MemoryDB's clock.
+func (m *MemoryDB) IsTradingHours() bool {
+	return IsTradingHoursAt(m.now())
+}
+
+func (m *MemoryDB) CreateStock(uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
+	return m.CreateStockContext(context.Background(), uid, stock, author, alertType, spt, ept, poi, stop, tstop, expiry, starting)
+}
+
+func (m *MemoryDB) CreateStockContext(ctx context.Context, uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	exists, exitChan := m.GetExitChanExists(uid)
+
+	if exists {
+		return exitChan, exists, nil
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	v := Stock{
+		StockAlertID:      uid,
+		StockGuildID:      m.Guild,
+		StockTicker:       stock,
+		StockEPt:          ept,
+		StockSPt:          spt,
+		StockExpiry:       expiry,
+		StockStarting:     starting,
+		StockStop:         stop,
+		StockLastHigh:     starting,
+		StockPoI:          poi,
+		StockTrailingStop: tstop,
+		AlertType:         alertType,
+		StockCallTime:     m.now(),
+		StockPOIHit:       false,
+		StockHighest:      starting,
+		Caller:            author,
+		StockState:        initialState(poi, false),
+	}
+	v.StockStateTimes = initialTimes(v.StockState, v.StockCallTime)
+	if old, ok := m.store.stocks[uid]; ok && old.StockGuildID != m.Guild {
+		m.reg.Cancel(m.Guild, uid)
+		return nil, false, inUse(AssetStock)
+	}
+	m.store.reset(m.Guild, AssetStock, uid)
+	m.store.stocks[uid] = v
+	m.store.audit(m.now(), v.auditEntry(ctx, AuditCreate))
+	for _, t := range seedTargets(m.Guild, uid, AssetStock, TargetPrice, m.now(), spt, ept) {
+		m.store.addTarget(t)
+	}
+	m.store.addFill(openingFill(m.Guild, uid, AssetStock, author, v.StockStarting, v.StockCallTime))
+
+	return exitChan, exists, nil
+}
+
+func (m *MemoryDB) RemoveStock(uid string) error {
+	return m.RemoveStockContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) RemoveStockContext(ctx context.Context, uid string) error {
+	_, err := m.CloseStockContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
+
+func (m *MemoryDB) CloseStock(uid, reason string, price float32) (*ClosedAlert, error) {
+	return m.CloseStockContext(context.Background(), uid, reason, price)
+}
+
+func (m *MemoryDB) CloseStockContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	s, ok := m.store.stockOf(m.Guild, uid)
+	if !ok {
+		m.store.mu.Unlock()
+		return nil, fmt.Errorf("Unable to remove Stock %v : %w", uid, ErrNotFound)
+	}
+	delete(m.store.stocks, uid)
+	closed := m.store.archive(s.closed(reason, price, m.now()))
+	m.store.audit(m.now(), s.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	m.store.mu.Unlock()
+
+	m.reg.Cancel(m.Guild, uid)
+	return closed, nil
+}
+
+func (m *MemoryDB) GetStock(uid string) (*Stock, error) {
+	return m.GetStockContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) GetStockContext(ctx context.Context, uid string) (*Stock, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	s, ok := m.store.stockOf(m.Guild, uid)
+	m.store.mu.RUnlock()
+
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, sql.ErrNoRows.Error()))
+		return nil, notFound(sql.ErrNoRows, "stock", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return nil, noMonitor("stock", uid)
+	}
+	return &s, nil
+}
+
+func (m *MemoryDB) StockPOIHit(uid string) error {
+	return m.StockPOIHitContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) StockPOIHitContext(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	s, ok := m.store.stockOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "stock", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("stock", uid)
+	}
+
+	entries := []*AuditEntry{s.auditEntry(ctx, AuditPOIHit).change("poi_hit", s.StockPOIHit, true)}
+	s.StockPOIHit = true
+
+	if s.GetState() == StatePending {
+		entry, err := s.moveTo(ctx, StateActive, m.now())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	m.store.stocks[uid] = s
+	m.store.audit(m.now(), entries...)
+	return nil
+}
+
+func (m *MemoryDB) StockTransition(uid string, to State) error {
+	return m.StockTransitionContext(context.Background(), uid, to)
+}
+
+func (m *MemoryDB) StockTransitionContext(ctx context.Context, uid string, to State) error {
+	if to == StateClosed {
+		s, err := m.GetStockContext(ctx, uid)
+		if err != nil {
+			return err
+		}
+		_, err = m.CloseStockContext(ctx, uid, closeReason(s.GetState()), 0)
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	s, ok := m.store.stockOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "stock", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("stock", uid)
+	}
+
+	entry, err := s.moveTo(ctx, to, m.now())
+	if err != nil {
+		return err
+	}
+
+	m.store.stocks[uid] = s
+	m.store.audit(m.now(), entry)
+	return nil
+}
+
+func (m *MemoryDB) StockSetNewHigh(uid string, price float32) error {
+	return m.StockSetNewHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) StockSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.StockUpdateHighContext(ctx, uid, price)
+	return err
+}
+
+func (m *MemoryDB) StockUpdateHigh(uid string, price float32) (bool, error) {
+	return m.StockUpdateHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) StockUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	s, ok := m.store.stockOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, sql.ErrNoRows.Error()))
+		return false, notFound(sql.ErrNoRows, "stock", uid)
+	}
+	if !(s.StockHighest < price) {
+		return false, nil
+	}
+
+	entry := s.auditEntry(ctx, AuditUpdate).change("highest", s.StockHighest, price)
+	s.StockHighest = price
+	m.store.stocks[uid] = s
+	m.store.audit(m.now(), entry)
+	return true, nil
+}
+
+func (m *MemoryDB) StockSetNewAvg(uid string, price float32) error {
+	return m.StockSetNewAvgContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) StockSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.AddFillContext(ctx, AssetStock, uid, FillAvg, price, 0)
+	return err
+}
+
+func (m *MemoryDB) CreateShort(uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
+	return m.CreateShortContext(context.Background(), uid, stock, author, alertType, spt, ept, poi, stop, tstop, expiry, starting)
+}
+
+func (m *MemoryDB) CreateShortContext(ctx context.Context, uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	exists, exitChan := m.GetExitChanExists(uid)
+
+	if exists {
+		return exitChan, exists, nil
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	v := Short{
+		ShortAlertID:      uid,
+		ShortGuildID:      m.Guild,
+		ShortTicker:       stock,
+		ShortSPt:          spt,
+		ShortEPt:          ept,
+		ShortExpiry:       expiry,
+		ShortStarting:     starting,
+		ShortPoI:          poi,
+		ShortStop:         stop,
+		ShortTrailingStop: tstop,
+		ShortLastLow:      starting,
+		AlertType:         alertType,
+		ShortCallTime:     m.now(),
+		ShortPOIHit:       false,
+		ShortLowest:       starting,
+		Caller:            author,
+		ShortState:        initialState(poi, false),
+	}
+	v.ShortStateTimes = initialTimes(v.ShortState, v.ShortCallTime)
+	if old, ok := m.store.shorts[uid]; ok && old.ShortGuildID != m.Guild {
+		m.reg.Cancel(m.Guild, uid)
+		return nil, false, inUse(AssetShort)
+	}
+	m.store.reset(m.Guild, AssetShort, uid)
+	m.store.shorts[uid] = v
+	m.store.audit(m.now(), v.auditEntry(ctx, AuditCreate))
+	for _, t := range seedTargets(m.Guild, uid, AssetShort, TargetPrice, m.now(), spt, ept) {
+		m.store.addTarget(t)
+	}
+	m.store.addFill(openingFill(m.Guild, uid, AssetShort, author, v.ShortStarting, v.ShortCallTime))
+
+	return exitChan, exists, nil
+}
+
+func (m *MemoryDB) RemoveShort(uid string) error {
+	return m.RemoveShortContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) RemoveShortContext(ctx context.Context, uid string) error {
+	_, err := m.CloseShortContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
+
+func (m *MemoryDB) CloseShort(uid, reason string, price float32) (*ClosedAlert, error) {
+	return m.CloseShortContext(context.Background(), uid, reason, price)
+}
+
+func (m *MemoryDB) CloseShortContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	s, ok := m.store.shortOf(m.Guild, uid)
+	if !ok {
+		m.store.mu.Unlock()
+		return nil, fmt.Errorf("Unable to remove Short %v : %w", uid, ErrNotFound)
+	}
+	delete(m.store.shorts, uid)
+	closed := m.store.archive(s.closed(reason, price, m.now()))
+	m.store.audit(m.now(), s.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	m.store.mu.Unlock()
+
+	m.reg.Cancel(m.Guild, uid)
+	return closed, nil
+}
+
+func (m *MemoryDB) GetShort(uid string) (*Short, error) {
+	return m.GetShortContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) GetShortContext(ctx context.Context, uid string) (*Short, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	s, ok := m.store.shortOf(m.Guild, uid)
+	m.store.mu.RUnlock()
+
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, sql.ErrNoRows.Error()))
+		return nil, notFound(sql.ErrNoRows, "short", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return nil, noMonitor("short", uid)
+	}
+	return &s, nil
+}
+
+func (m *MemoryDB) ShortPOIHit(uid string) error {
+	return m.ShortPOIHitContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) ShortPOIHitContext(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	s, ok := m.store.shortOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "short", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("short", uid)
+	}
+
+	entries := []*AuditEntry{s.auditEntry(ctx, AuditPOIHit).change("poi_hit", s.ShortPOIHit, true)}
+	s.ShortPOIHit = true
+
+	if s.GetState() == StatePending {
+		entry, err := s.moveTo(ctx, StateActive, m.now())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	m.store.shorts[uid] = s
+	m.store.audit(m.now(), entries...)
+	return nil
+}
+
+func (m *MemoryDB) ShortTransition(uid string, to State) error {
+	return m.ShortTransitionContext(context.Background(), uid, to)
+}
+
+func (m *MemoryDB) ShortTransitionContext(ctx context.Context, uid string, to State) error {
+	if to == StateClosed {
+		s, err := m.GetShortContext(ctx, uid)
+		if err != nil {
+			return err
+		}
+		_, err = m.CloseShortContext(ctx, uid, closeReason(s.GetState()), 0)
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	s, ok := m.store.shortOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "short", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("short", uid)
+	}
+
+	entry, err := s.moveTo(ctx, to, m.now())
+	if err != nil {
+		return err
+	}
+
+	m.store.shorts[uid] = s
+	m.store.audit(m.now(), entry)
+	return nil
+}
+
+func (m *MemoryDB) ShortSetNewHigh(uid string, price float32) error {
+	return m.ShortSetNewHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) ShortSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.ShortUpdateLowContext(ctx, uid, price)
+	return err
+}
+
+func (m *MemoryDB) ShortUpdateLow(uid string, price float32) (bool, error) {
+	return m.ShortUpdateLowContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) ShortUpdateLowContext(ctx context.Context, uid string, price float32) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	s, ok := m.store.shortOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Short %v : %v", uid, sql.ErrNoRows.Error()))
+		return false, notFound(sql.ErrNoRows, "short", uid)
+	}
+	if !(s.ShortLowest > price) {
+		return false, nil
+	}
+
+	entry := s.auditEntry(ctx, AuditUpdate).change("lowest", s.ShortLowest, price)
+	s.ShortLowest = price
+	m.store.shorts[uid] = s
+	m.store.audit(m.now(), entry)
+	return true, nil
+}
+
+func (m *MemoryDB) ShortSetNewAvg(uid string, price float32) error {
+	return m.ShortSetNewAvgContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) ShortSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.AddFillContext(ctx, AssetShort, uid, FillAvg, price, 0)
+	return err
+}
+
+func (m *MemoryDB) CreateCrypto(uid, coin, author string, spt, ept, poi, stop, tstop float32, alertType int, starting float32) (chan bool, bool, error) {
+	return m.CreateCryptoContext(context.Background(), uid, coin, author, spt, ept, poi, stop, tstop, alertType, starting)
+}
+
+func (m *MemoryDB) CreateCryptoContext(ctx context.Context, uid, coin, author string, spt, ept, poi, stop, tstop float32, alertType int, starting float32) (chan bool, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	exists, exitChan := m.GetExitChanExists(uid)
+
+	if exists {
+		return exitChan, exists, nil
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	v := Crypto{
+		CryptoAlertID:      uid,
+		CryptoGuildID:      m.Guild,
+		CryptoCoin:         coin,
+		CryptoStarting:     starting,
+		CryptoHighest:      starting,
+		CryptoLastHigh:     starting,
+		AlertType:          alertType,
+		CryptoCallTime:     m.now(),
+		CryptoEPt:          ept,
+		CryptoSPt:          spt,
+		CryptoStop:         stop,
+		CryptoTrailingStop: tstop,
+		CryptoPoI:          poi,
+		CryptoPOIHit:       false,
+		Caller:             author,
+		CryptoState:        initialState(poi, false),
+	}
+	v.CryptoStateTimes = initialTimes(v.CryptoState, v.CryptoCallTime)
+	if old, ok := m.store.crypto[uid]; ok && old.CryptoGuildID != m.Guild {
+		m.reg.Cancel(m.Guild, uid)
+		return nil, false, inUse(AssetCrypto)
+	}
+	m.store.reset(m.Guild, AssetCrypto, uid)
+	m.store.crypto[uid] = v
+	m.store.audit(m.now(), v.auditEntry(ctx, AuditCreate))
+	for _, t := range seedTargets(m.Guild, uid, AssetCrypto, TargetPrice, m.now(), spt, ept) {
+		m.store.addTarget(t)
+	}
+	m.store.addFill(openingFill(m.Guild, uid, AssetCrypto, author, v.CryptoStarting, v.CryptoCallTime))
+
+	return exitChan, exists, nil
+}
+
+func (m *MemoryDB) RemoveCrypto(uid string) error {
+	return m.RemoveCryptoContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) RemoveCryptoContext(ctx context.Context, uid string) error {
+	_, err := m.CloseCryptoContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
+
+func (m *MemoryDB) CloseCrypto(uid, reason string, price float32) (*ClosedAlert, error) {
+	return m.CloseCryptoContext(context.Background(), uid, reason, price)
+}
+
+func (m *MemoryDB) CloseCryptoContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	c, ok := m.store.cryptoOf(m.Guild, uid)
+	if !ok {
+		m.store.mu.Unlock()
+		return nil, fmt.Errorf("Unable to remove Crypto %v : %w", uid, ErrNotFound)
+	}
+	delete(m.store.crypto, uid)
+	closed := m.store.archive(c.closed(reason, price, m.now()))
+	m.store.audit(m.now(), c.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	m.store.mu.Unlock()
+
+	m.reg.Cancel(m.Guild, uid)
+	return closed, nil
+}
+
+func (m *MemoryDB) GetCrypto(uid string) (*Crypto, error) {
+	return m.GetCryptoContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) GetCryptoContext(ctx context.Context, uid string) (*Crypto, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	c, ok := m.store.cryptoOf(m.Guild, uid)
+	m.store.mu.RUnlock()
+
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, sql.ErrNoRows.Error()))
+		return nil, notFound(sql.ErrNoRows, "crypto", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return nil, noMonitor("crypto", uid)
+	}
+	return &c, nil
+}
+
+func (m *MemoryDB) CryptoPOIHit(uid string) error {
+	return m.CryptoPOIHitContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) CryptoPOIHitContext(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	c, ok := m.store.cryptoOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "crypto", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("crypto", uid)
+	}
+
+	entries := []*AuditEntry{c.auditEntry(ctx, AuditPOIHit).change("poi_hit", c.CryptoPOIHit, true)}
+	c.CryptoPOIHit = true
+
+	if c.GetState() == StatePending {
+		entry, err := c.moveTo(ctx, StateActive, m.now())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	m.store.crypto[uid] = c
+	m.store.audit(m.now(), entries...)
+	return nil
+}
+
+func (m *MemoryDB) CryptoTransition(uid string, to State) error {
+	return m.CryptoTransitionContext(context.Background(), uid, to)
+}
+
+func (m *MemoryDB) CryptoTransitionContext(ctx context.Context, uid string, to State) error {
+	if to == StateClosed {
+		c, err := m.GetCryptoContext(ctx, uid)
+		if err != nil {
+			return err
+		}
+		_, err = m.CloseCryptoContext(ctx, uid, closeReason(c.GetState()), 0)
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	c, ok := m.store.cryptoOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "crypto", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("crypto", uid)
+	}
+
+	entry, err := c.moveTo(ctx, to, m.now())
+	if err != nil {
+		return err
+	}
+
+	m.store.crypto[uid] = c
+	m.store.audit(m.now(), entry)
+	return nil
+}
+
+func (m *MemoryDB) CryptoSetNewHigh(uid string, price float32) error {
+	return m.CryptoSetNewHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) CryptoSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.CryptoUpdateHighContext(ctx, uid, price)
+	return err
+}
+
+func (m *MemoryDB) CryptoUpdateHigh(uid string, price float32) (bool, error) {
+	return m.CryptoUpdateHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) CryptoUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	c, ok := m.store.cryptoOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Crypto %v : %v", uid, sql.ErrNoRows.Error()))
+		return false, notFound(sql.ErrNoRows, "crypto", uid)
+	}
+	if !(c.CryptoHighest < price) {
+		return false, nil
+	}
+
+	entry := c.auditEntry(ctx, AuditUpdate).change("highest", c.CryptoHighest, price)
+	c.CryptoHighest = price
+	m.store.crypto[uid] = c
+	m.store.audit(m.now(), entry)
+	return true, nil
+}
+
+func (m *MemoryDB) CryptoSetNewAvg(uid string, price float32) error {
+	return m.CryptoSetNewAvgContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) CryptoSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.AddFillContext(ctx, AssetCrypto, uid, FillAvg, price, 0)
+	return err
+}
+
+func (m *MemoryDB) CreateOption(uid, oID, author string, alertType int, ticker, contractType, day, month, year string, price, starting, pt, poi, stop, tstop, underStart float32) (chan bool, string, bool, error) {
+	return m.CreateOptionContext(context.Background(), uid, oID, author, alertType, ticker, contractType, day, month, year, price, starting, pt, poi, stop, tstop, underStart)
+}
+
+func (m *MemoryDB) CreateOptionContext(ctx context.Context, uid, oID, author string, alertType int, ticker, contractType, day, month, year string, price, starting, pt, poi, stop, tstop, underStart float32) (chan bool, string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", false, err
+	}
+
+	if len(year) != 4 {
+		return nil, "", false, invalidInput("invalid Syntax - year is incorrect")
+	}
+
+	if len(month) > 2 || len(month) == 0 {
+		return nil, "", false, invalidInput("invalid Syntax - month is incorrect")
+	}
+
+	if len(day) > 2 || len(day) == 0 {
+		return nil, "", false, invalidInput("invalid Syntax - day is incorrect")
+	}
+
+	exists, exitChan := m.GetExitChanExists(uid)
+
+	if exists {
+		return exitChan, oID, exists, nil
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	v := Option{
+		OptionAlertID:            uid,
+		OptionGuildID:            m.Guild,
+		OptionTicker:             ticker,
+		OptionUid:                oID,
+		OptionDay:                day,
+		OptionContractType:       contractType,
+		OptionMonth:              month,
+		OptionYear:               year,
+		OptionStrike:             price,
+		OptionStarting:           starting,
+		AlertType:                alertType,
+		OptionCallTime:           m.now(),
+		OptionHighest:            starting,
+		OptionLastHigh:           starting,
+		OptionTrailingStop:       tstop,
+		OptionUnderlyingPoI:      poi,
+		OptionUnderlyingStop:     stop,
+		OptionUnderlyingStarting: underStart,
+		OptionUnderlyingPOIHit:   false,
+		Caller:                   author,
+		OptionState:              initialState(poi, false),
+	}
+	v.OptionStateTimes = initialTimes(v.OptionState, v.OptionCallTime)
+	if old, ok := m.store.options[uid]; ok && old.OptionGuildID != m.Guild {
+		m.reg.Cancel(m.Guild, uid)
+		return nil, "", false, inUse(AssetOption)
+	}
+	m.store.reset(m.Guild, AssetOption, uid)
+	m.store.options[uid] = v
+	m.store.audit(m.now(), v.auditEntry(ctx, AuditCreate))
+	for _, t := range seedTargets(m.Guild, uid, AssetOption, TargetPremium, m.now(), pt) {
+		m.store.addTarget(t)
+	}
+	m.store.addFill(openingFill(m.Guild, uid, AssetOption, author, v.OptionStarting, v.OptionCallTime))
+
+	return exitChan, oID, exists, nil
+}
+
+func (m *MemoryDB) RemoveOptionByCode(uid string) error {
+	return m.RemoveOptionByCodeContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) RemoveOptionByCodeContext(ctx context.Context, uid string) error {
+	_, err := m.CloseOptionContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
+
+func (m *MemoryDB) CloseOption(uid, reason string, price float32) (*ClosedAlert, error) {
+	return m.CloseOptionContext(context.Background(), uid, reason, price)
+}
+
+func (m *MemoryDB) CloseOptionContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	o, ok := m.store.optionOf(m.Guild, uid)
+	if !ok {
+		m.store.mu.Unlock()
+		return nil, fmt.Errorf("Unable to remove option %v : %w", uid, ErrNotFound)
+	}
+	delete(m.store.options, uid)
+	closed := m.store.archive(o.closed(reason, price, m.now()))
+	m.store.audit(m.now(), o.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	m.store.mu.Unlock()
+
+	m.reg.Cancel(m.Guild, uid)
+	return closed, nil
+}
+
+func (m *MemoryDB) SwitchOptionsTypeByCode(uid string) (string, error) {
+	return m.SwitchOptionsTypeByCodeContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) SwitchOptionsTypeByCodeContext(ctx context.Context, uid string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	retStr := ""
+	err := m.updateOption(ctx, uid, AuditSwitchType, func(o *Option) (string, interface{}, interface{}) {
+		old := o.AlertType
+		if old == utils.DAY {
+			o.AlertType = utils.SWING
+			retStr = "Swing"
+		} else {
+			o.AlertType = utils.DAY
+			retStr = "Day"
+		}
+		return "alert_type", old, o.AlertType
+	})
+
+	if err != nil {
+		return "", err
+	}
+	return retStr, nil
+}
+
+func (m *MemoryDB) GetOption(uid string) (*Option, error) {
+	return m.GetOptionContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) GetOptionContext(ctx context.Context, uid string) (*Option, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	o, ok := m.store.optionOf(m.Guild, uid)
+	m.store.mu.RUnlock()
+
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get option %v: %v.", uid, sql.ErrNoRows.Error()))
+		return nil, notFound(sql.ErrNoRows, "option", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return nil, noMonitor("option", uid)
+	}
+	return &o, nil
+}
+
+func (m *MemoryDB) OptionPOIHit(uid string) error {
+	return m.OptionPOIHitContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) OptionPOIHitContext(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	o, ok := m.store.optionOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get option %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "option", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("option", uid)
+	}
+
+	entries := []*AuditEntry{o.auditEntry(ctx, AuditPOIHit).change("poi_hit", o.OptionUnderlyingPOIHit, true)}
+	o.OptionUnderlyingPOIHit = true
+
+	if o.GetState() == StatePending {
+		entry, err := o.moveTo(ctx, StateActive, m.now())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	m.store.options[uid] = o
+	m.store.audit(m.now(), entries...)
+	return nil
+}
+
+func (m *MemoryDB) OptionTransition(uid string, to State) error {
+	return m.OptionTransitionContext(context.Background(), uid, to)
+}
+
+func (m *MemoryDB) OptionTransitionContext(ctx context.Context, uid string, to State) error {
+	if to == StateClosed {
+		o, err := m.GetOptionContext(ctx, uid)
+		if err != nil {
+			return err
+		}
+		_, err = m.CloseOptionContext(ctx, uid, closeReason(o.GetState()), 0)
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	o, ok := m.store.optionOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get option %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "option", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("option", uid)
+	}
+
+	entry, err := o.moveTo(ctx, to, m.now())
+	if err != nil {
+		return err
+	}
+
+	m.store.options[uid] = o
+	m.store.audit(m.now(), entry)
+	return nil
+}
+
+func (m *MemoryDB) OptionSetNewHigh(uid string, price float32) error {
+	return m.OptionSetNewHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) OptionSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.OptionUpdateHighContext(ctx, uid, price)
+	return err
+}
+
+func (m *MemoryDB) OptionUpdateHigh(uid string, price float32) (bool, error) {
+	return m.OptionUpdateHighContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) OptionUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	o, ok := m.store.optionOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get Option %v : %v", uid, sql.ErrNoRows.Error()))
+		return false, notFound(sql.ErrNoRows, "option", uid)
+	}
+	if !(o.OptionHighest < price) {
+		return false, nil
+	}
+
+	entry := o.auditEntry(ctx, AuditUpdate).change("highest", o.OptionHighest, price)
+	o.OptionHighest = price
+	m.store.options[uid] = o
+	m.store.audit(m.now(), entry)
+	return true, nil
+}
+
+func (m *MemoryDB) OptionSetNewAvg(uid string, price float32) error {
+	return m.OptionSetNewAvgContext(context.Background(), uid, price)
+}
+
+func (m *MemoryDB) OptionSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := m.AddFillContext(ctx, AssetOption, uid, FillAvg, price, 0)
+	return err
+}
+
+func (m *MemoryDB) updateOption(ctx context.Context, uid, action string, update func(o *Option) (field string, before, after interface{})) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	o, ok := m.store.optionOf(m.Guild, uid)
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get option %v : %v", uid, sql.ErrNoRows.Error()))
+		return notFound(sql.ErrNoRows, "option", uid)
+	}
+	if !m.reg.monitored(m.Guild, uid) {
+		return noMonitor("option", uid)
+	}
+
+	entry := o.auditEntry(ctx, action)
+	entry.change(update(&o))
+	m.store.options[uid] = o
+	m.store.audit(m.now(), entry)
+	return nil
+}
+
+func (m *MemoryDB) InitialiseServer(guildID, permID, eod string) error {
+	return m.InitialiseServerContext(context.Background(), guildID, permID, eod)
+}
+
+func (m *MemoryDB) InitialiseServerContext(ctx context.Context, guildID, permID, eod string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if guildID != m.Guild {
+		return wrongGuild(guildID)
+	}
+
+	res, err := m.GetAllAlertersContext(ctx, guildID)
+
+	if err != nil {
+		m.store.mu.Lock()
+		m.store.channels["0"+guildID] = Channel{
+			UserGuildComposite: "0" + guildID,
+			UserID:             "0",
+			RoleID:             "0",
+			ChannelID:          "0",
+			EOD:                eod,
+			GuildID:            guildID,
+			PermissionsID:      permID,
+		}
+		m.store.mu.Unlock()
+		return nil
+	}
+
+	for _, v := range res {
+		if v.PermissionsID != permID {
+			// recreate all alerters with the correct role; assume theyre all dirty
+			for _, v2 := range res {
+				err = m.CreateAlerterContext(ctx, guildID, v2.ChannelID, v2.UserID, v2.RoleID, permID, eod)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) GetServerPerm(guildID string) (string, error) {
+	return m.GetServerPermContext(context.Background(), guildID)
+}
+
+func (m *MemoryDB) GetServerPermContext(ctx context.Context, guildID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	res, err := m.GetAlerterContext(ctx, guildID, "0")
+
+	if err != nil {
+		return "", err
+	}
+
+	return res.PermissionsID, nil
+}
+
+func (m *MemoryDB) GetEOD(guildID string) (string, error) {
+	return m.GetEODContext(context.Background(), guildID)
+}
+
+func (m *MemoryDB) GetEODContext(ctx context.Context, guildID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	res, err := m.GetAlerterContext(ctx, guildID, "0")
+
+	if err != nil {
+		return "", err
+	}
+
+	return res.EOD, nil
+}
+
+func (m *MemoryDB) CreateAlerter(guild, channelID, userID, roleID, permID, eod string) error {
+	return m.CreateAlerterContext(context.Background(), guild, channelID, userID, roleID, permID, eod)
+}
+
+func (m *MemoryDB) CreateAlerterContext(ctx context.Context, guild, channelID, userID, roleID, permID, eod string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if guild != m.Guild {
+		return wrongGuild(guild)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.channels[userID+guild] = Channel{
+		UserGuildComposite: userID + guild,
+		UserID:             userID,
+		RoleID:             roleID,
+		ChannelID:          channelID,
+		GuildID:            guild,
+		PermissionsID:      permID,
+		EOD:                eod,
+	}
+	return nil
+}
+
+func (m *MemoryDB) RemoveAlerter(guild, userID string) error {
+	return m.RemoveAlerterContext(context.Background(), guild, userID)
+}
+
+func (m *MemoryDB) RemoveAlerterContext(ctx context.Context, guild, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if guild != m.Guild {
+		return wrongGuild(guild)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.channels[userID+guild]; !ok {
+		return fmt.Errorf("Unable to remove Alerter %v : %w", userID, ErrNotFound)
+	}
+	delete(m.store.channels, userID+guild)
+	return nil
+}
+
+func (m *MemoryDB) GetAlerter(guild, userID string) (*Channel, error) {
+	return m.GetAlerterContext(context.Background(), guild, userID)
+}
+
+func (m *MemoryDB) GetAlerterContext(ctx context.Context, guild, userID string) (*Channel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if guild != m.Guild {
+		return nil, wrongGuild(guild)
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	a, ok := m.store.channels[userID+guild]
+	if !ok {
+		log.Println(fmt.Sprintf("Unable to get alerter %v : %v", userID, sql.ErrNoRows.Error()))
+		return nil, notFound(sql.ErrNoRows, "alerter", userID)
+	}
+	return &a, nil
+}
+
+func (m *MemoryDB) GetAllAlerters(guild string) ([]*Channel, error) {
+	return m.GetAllAlertersContext(context.Background(), guild)
+}
+
+func (m *MemoryDB) GetAllAlertersContext(ctx context.Context, guild string) ([]*Channel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if guild != m.Guild {
+		return nil, wrongGuild(guild)
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	allAlerters := make([]*Channel, 0)
+	for _, v := range m.store.channels {
+		if v.GuildID == guild {
+			a := v
+			allAlerters = append(allAlerters, &a)
+		}
+	}
+
+	if len(allAlerters) == 0 {
+		return nil, fmt.Errorf("no alerters found for %v : %w", guild, ErrNotFound)
+	}
+	sort.Slice(allAlerters, func(i, j int) bool {
+		return allAlerters[i].UserGuildComposite < allAlerters[j].UserGuildComposite
+	})
+	return allAlerters, nil
+}
+
+func (m *MemoryDB) RmAll() (*RemovalReport, error) {
+	return m.RmAllContext(context.Background())
+}
+
+func (m *MemoryDB) RmAllContext(ctx context.Context) (*RemovalReport, error) {
+	return m.RmAllCallerContext(ctx, "")
+}
+
+// RmAllCaller removes every alert made by caller in this guild. An empty caller matches everyone.
+func (m *MemoryDB) RmAllCaller(caller string) (*RemovalReport, error) {
+	return m.RmAllCallerContext(context.Background(), caller)
+}
+
+func (m *MemoryDB) RmAllCallerContext(ctx context.Context, caller string) (*RemovalReport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Println("Nuke called for " + caller + " !!!!!!!!!!!!!!!!!!!!!!")
+	report := &RemovalReport{}
+
+	m.store.mu.Lock()
+	for k, v := range m.store.stocks {
+		if v.StockGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			report.Stocks = append(report.Stocks, k)
+			delete(m.store.stocks, k)
+			m.store.archive(v.closed(CloseReasonNuked, 0, m.now()))
+			m.store.audit(m.now(), v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+		}
+	}
+	for k, v := range m.store.shorts {
+		if v.ShortGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			report.Shorts = append(report.Shorts, k)
+			delete(m.store.shorts, k)
+			m.store.archive(v.closed(CloseReasonNuked, 0, m.now()))
+			m.store.audit(m.now(), v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+		}
+	}
+	for k, v := range m.store.crypto {
+		if v.CryptoGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			report.Crypto = append(report.Crypto, k)
+			delete(m.store.crypto, k)
+			m.store.archive(v.closed(CloseReasonNuked, 0, m.now()))
+			m.store.audit(m.now(), v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+		}
+	}
+	for k, v := range m.store.options {
+		if v.OptionGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			report.Options = append(report.Options, k)
+			delete(m.store.options, k)
+			m.store.archive(v.closed(CloseReasonNuked, 0, m.now()))
+			m.store.audit(m.now(), v.auditEntry(ctx, AuditRemove).change("reason", "", CloseReasonNuked))
+		}
+	}
+	m.store.mu.Unlock()
+
+	signalRemoved(m.reg, m.Guild, report)
+
+	log.Println(fmt.Sprintf("Nuke completed, removed %v alerts!!!!!!!!!!!!!!!!!!!!!!", report.Total()))
+	return report, nil
+}
+
+func (m *MemoryDB) GetClosedAlerts(since time.Time) ([]*ClosedAlert, error) {
+	return m.GetClosedAlertsContext(context.Background(), since)
+}
+
+func (m *MemoryDB) GetClosedAlertsContext(ctx context.Context, since time.Time) ([]*ClosedAlert, error) {
+	return m.GetClosedAlertsCallerContext(ctx, "", since)
+}
+
+// GetClosedAlertsCaller returns caller's archived alerts closed at or after since, newest first. An empty caller matches everyone.
+func (m *MemoryDB) GetClosedAlertsCaller(caller string, since time.Time) ([]*ClosedAlert, error) {
+	return m.GetClosedAlertsCallerContext(context.Background(), caller, since)
+}
+
+func (m *MemoryDB) GetClosedAlertsCallerContext(ctx context.Context, caller string, since time.Time) ([]*ClosedAlert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	allClosed := make([]*ClosedAlert, 0)
+	for i := len(m.store.closed) - 1; i >= 0; i-- {
+		v := m.store.closed[i]
+		if v.GuildID == m.Guild && (caller == "" || v.Caller == caller) && !v.CloseTime.Before(since) {
+			allClosed = append(allClosed, &v)
+		}
+	}
+	sort.SliceStable(allClosed, func(i, j int) bool { return allClosed[i].CloseTime.After(allClosed[j].CloseTime) })
+
+	return allClosed, nil
+}
+
+func (m *MemoryDB) GetAuditLog(since time.Time) ([]*AuditEntry, error) {
+	return m.GetAuditLogContext(context.Background(), since)
+}
+
+func (m *MemoryDB) GetAuditLogContext(ctx context.Context, since time.Time) ([]*AuditEntry, error) {
+	return m.getAudit(ctx, func(e *AuditEntry) bool { return !e.At.Before(since) })
+}
+
+func (m *MemoryDB) GetAuditLogAlert(uid string) ([]*AuditEntry, error) {
+	return m.GetAuditLogAlertContext(context.Background(), uid)
+}
+
+func (m *MemoryDB) GetAuditLogAlertContext(ctx context.Context, uid string) ([]*AuditEntry, error) {
+	return m.getAudit(ctx, func(e *AuditEntry) bool { return e.AlertID == uid })
+}
+
+func (m *MemoryDB) GetAuditLogCaller(caller string) ([]*AuditEntry, error) {
+	return m.GetAuditLogCallerContext(context.Background(), caller)
+}
+
+func (m *MemoryDB) GetAuditLogCallerContext(ctx context.Context, caller string) ([]*AuditEntry, error) {
+	return m.getAudit(ctx, func(e *AuditEntry) bool { return e.Caller == caller })
+}
+
+func (m *MemoryDB) GetAuditLogActor(actor string) ([]*AuditEntry, error) {
+	return m.GetAuditLogActorContext(context.Background(), actor)
+}
+
+func (m *MemoryDB) GetAuditLogActorContext(ctx context.Context, actor string) ([]*AuditEntry, error) {
+	return m.getAudit(ctx, func(e *AuditEntry) bool { return e.Actor == actor })
+}
+
+func (m *MemoryDB) getAudit(ctx context.Context, match func(e *AuditEntry) bool) ([]*AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	entries := make([]*AuditEntry, 0)
+	for i := len(m.store.audits) - 1; i >= 0; i-- {
+		e := m.store.audits[i]
+		if e.GuildID == m.Guild && match(&e) {
+			entries = append(entries, &e)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryDB) GetAll() ([]*Stock, []*Short, []*Crypto, []*Option, error) {
+	return m.GetAllContext(context.Background())
+}
+
+func (m *MemoryDB) GetAllContext(ctx context.Context) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
+	return m.GetAllCallerContext(ctx, "")
+}
+
+// GetAllCaller returns every alert made by caller in this guild. An empty caller matches everyone.
+func (m *MemoryDB) GetAllCaller(caller string) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
+	return m.GetAllCallerContext(context.Background(), caller)
+}
+
+func (m *MemoryDB) GetAllCallerContext(ctx context.Context, caller string) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	allStocks := make([]*Stock, 0)
+	allShorts := make([]*Short, 0)
+	allOptions := make([]*Option, 0)
+	allCrypto := make([]*Crypto, 0)
+
+	for _, v := range m.store.stocks {
+		if v.StockGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			s := v
+			allStocks = append(allStocks, &s)
+		}
+	}
+	for _, v := range m.store.shorts {
+		if v.ShortGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			s := v
+			allShorts = append(allShorts, &s)
+		}
+	}
+	for _, v := range m.store.crypto {
+		if v.CryptoGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			c := v
+			allCrypto = append(allCrypto, &c)
+		}
+	}
+	for _, v := range m.store.options {
+		if v.OptionGuildID == m.Guild && (caller == "" || v.Caller == caller) {
+			o := v
+			allOptions = append(allOptions, &o)
+		}
+	}
+
+	sort.Slice(allStocks, func(i, j int) bool { return allStocks[i].StockCallTime.Before(allStocks[j].StockCallTime) })
+	sort.Slice(allShorts, func(i, j int) bool { return allShorts[i].ShortCallTime.Before(allShorts[j].ShortCallTime) })
+	sort.Slice(allCrypto, func(i, j int) bool { return allCrypto[i].CryptoCallTime.Before(allCrypto[j].CryptoCallTime) })
+	sort.Slice(allOptions, func(i, j int) bool { return allOptions[i].OptionCallTime.Before(allOptions[j].OptionCallTime) })
+
+	return allStocks, allShorts, allCrypto, allOptions, nil
+}
+
+func (m *MemoryDB) RefreshFromDB() ([]*Stock, []*Short, []*Crypto, []*Option, error) {
+	return m.RefreshFromDBContext(context.Background())
+}
+
+func (m *MemoryDB) RefreshFromDBContext(ctx context.Context) ([]*Stock, []*Short, []*Crypto, []*Option, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	m.reg.Track(m.Guild)
+	return m.GetAllContext(ctx)
+}
+
+func (m *MemoryDB) AddTarget(assetType, uid, series string, price float32) (*Target, error) {
+	return m.AddTargetContext(context.Background(), assetType, uid, series, price)
+}
+
+func (m *MemoryDB) AddTargetContext(ctx context.Context, assetType, uid, series string, price float32) (*Target, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tableFor(assetType); err != nil {
+		return nil, err
+	}
+	series, err := targetSeries(assetType, series)
+	if err != nil {
+		return nil, err
+	}
+	if price <= 0 {
+		return nil, invalidInput("target %v must be above zero", price)
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	caller, ok := m.store.callerOf(m.Guild, assetType, uid)
+	if !ok {
+		return nil, notFound(sql.ErrNoRows, assetType, uid)
+	}
+
+	target := seedTargets(m.Guild, uid, assetType, series, m.now(), price)[0]
+	if !m.store.addTarget(target) {
+		return nil, fmt.Errorf("%v target %v on %v %v : %w", series, price, assetType, uid, ErrAlreadyExists)
+	}
+	m.store.audit(m.now(), newAuditEntry(ctx, AuditUpdate, m.Guild, uid, assetType, caller, nil).change("target", "", price))
+	return target, nil
+}
+
+func (m *MemoryDB) RemoveTarget(targetID int64) error {
+	return m.RemoveTargetContext(context.Background(), targetID)
+}
+
+func (m *MemoryDB) RemoveTargetContext(ctx context.Context, targetID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	i, ok := m.store.targetOf(m.Guild, targetID)
+	if !ok {
+		return notFound(sql.ErrNoRows, "target", targetID)
+	}
+
+	t := m.store.targets[i]
+	m.store.targets = append(m.store.targets[:i], m.store.targets[i+1:]...)
+	caller, _ := m.store.callerOf(m.Guild, t.AssetType, t.AlertID)
+	m.store.audit(m.now(), newAuditEntry(ctx, AuditUpdate, m.Guild, t.AlertID, t.AssetType, caller, nil).change("target", t.Price, ""))
+	return nil
+}
+
+func (m *MemoryDB) HitTarget(targetID int64, price float32) (bool, error) {
+	return m.HitTargetContext(context.Background(), targetID, price)
+}
+
+func (m *MemoryDB) HitTargetContext(ctx context.Context, targetID int64, price float32) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	i, ok := m.store.targetOf(m.Guild, targetID)
+	if !ok {
+		return false, notFound(sql.ErrNoRows, "target", targetID)
+	}
+
+	t := &m.store.targets[i]
+	if t.Hit() {
+		return false, nil
+	}
+	t.HitPrice = price
+	t.HitTime = m.now()
+	caller, _ := m.store.callerOf(m.Guild, t.AssetType, t.AlertID)
+	m.store.audit(m.now(), newAuditEntry(ctx, AuditUpdate, m.Guild, t.AlertID, t.AssetType, caller, nil).change("target_hit", t.Price, price))
+	return true, nil
+}
+
+func (m *MemoryDB) GetTargets(assetType, uid string) ([]*Target, error) {
+	return m.GetTargetsContext(context.Background(), assetType, uid)
+}
+
+func (m *MemoryDB) GetTargetsContext(ctx context.Context, assetType, uid string) ([]*Target, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	return m.store.targetsWhere(func(t Target) bool {
+		return t.ClosedID == 0 && t.GuildID == m.Guild && t.AssetType == assetType && t.AlertID == uid
+	}), nil
+}
+
+func (m *MemoryDB) GetClosedTargets(closedID int64) ([]*Target, error) {
+	return m.GetClosedTargetsContext(context.Background(), closedID)
+}
+
+func (m *MemoryDB) GetClosedTargetsContext(ctx context.Context, closedID int64) ([]*Target, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	return m.store.targetsWhere(func(t Target) bool {
+		return t.ClosedID == closedID && t.GuildID == m.Guild
+	}), nil
+}
+
+func (m *MemoryDB) AddFill(assetType, uid, kind string, price, size float32) (*Position, error) {
+	return m.AddFillContext(context.Background(), assetType, uid, kind, price, size)
+}
+
+func (m *MemoryDB) AddFillContext(ctx context.Context, assetType, uid, kind string, price, size float32) (*Position, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tableFor(assetType); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	caller, ok := m.store.callerOf(m.Guild, assetType, uid)
+	if !ok {
+		return nil, notFound(sql.ErrNoRows, assetType, uid)
+	}
+
+	pos := NewPosition(m.store.fillsOf(m.Guild, assetType, uid), assetType == AssetShort)
+	if err := pos.check(kind, price, size); err != nil {
+		return nil, err
+	}
+
+	if kind == FillSell {
+		size = pos.Size
+	}
+	fill := &Fill{
+		GuildID:   m.Guild,
+		AlertID:   uid,
+		AssetType: assetType,
+		Kind:      kind,
+		Price:     price,
+		Size:      size,
+		Caller:    caller,
+		At:        m.now(),
+	}
+	m.store.addFill(fill)
+
+	before := pos.AvgCost
+	pos.apply(fill)
+	if pos.Open() && pos.AvgCost != before {
+		m.store.setStarting(m.Guild, assetType, uid, pos.AvgCost)
+	}
+	m.store.audit(m.now(), newAuditEntry(ctx, AuditUpdate, m.Guild, uid, assetType, caller, nil).change(kind, "", fmt.Sprintf("%v @ %v", size, price)))
+	return &pos, nil
+}
+
+func (m *MemoryDB) GetFills(assetType, uid string) ([]*Fill, error) {
+	return m.GetFillsContext(context.Background(), assetType, uid)
+}
+
+func (m *MemoryDB) GetFillsContext(ctx context.Context, assetType, uid string) ([]*Fill, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	return m.store.fillsOf(m.Guild, assetType, uid), nil
+}
+
+func (m *MemoryDB) GetClosedFills(closedID int64) ([]*Fill, error) {
+	return m.GetClosedFillsContext(context.Background(), closedID)
+}
+
+func (m *MemoryDB) GetClosedFillsContext(ctx context.Context, closedID int64) ([]*Fill, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	fills := make([]*Fill, 0)
+	for _, f := range m.store.fills {
+		if f.ClosedID == closedID && f.GuildID == m.Guild {
+			f := f
+			fills = append(fills, &f)
+		}
+	}
+	return fills, nil
+}
+
+func (m *MemoryDB) GetPosition(assetType, uid string) (*Position, error) {
+	return m.GetPositionContext(context.Background(), assetType, uid)
+}
+
+func (m *MemoryDB) GetPositionContext(ctx context.Context, assetType, uid string) (*Position, error) {
+	fills, err := m.GetFillsContext(ctx, assetType, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(fills) == 0 {
+		return nil, notFound(sql.ErrNoRows, assetType+" ledger", uid)
+	}
+
+	pos := NewPosition(fills, assetType == AssetShort)
+	return &pos, nil
+}
+
+func (m *MemoryDB) Registry() *AlertRegistry {
+	return m.reg
+}
+
+func (m *MemoryDB) GetExitChan(index string) chan bool {
+	return m.reg.Exit(m.Guild, index)
+}
+
+func (m *MemoryDB) GetExitChanExists(index string) (bool, chan bool) {
+	exitChan, exists := m.reg.OpenAt(m.Guild, index, m.now())
+	return exists, exitChan
+}
+
+func (m *MemoryDB) SetAndReturnNewExitChan(index string, exitChan chan bool) chan bool {
+	return m.reg.AttachAt(m.Guild, index, exitChan, m.now())
+}
+
+func (m *MemoryDB) SweepExpired(now time.Time, prices PriceFunc) (*RemovalReport, error) {
+	return m.SweepExpiredContext(context.Background(), now, prices)
+}
+
+func (m *MemoryDB) SweepExpiredContext(ctx context.Context, now time.Time, prices PriceFunc) (*RemovalReport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return sweepExpired(ctx, m, now, prices, func(ctx context.Context, t alertTable, uid string, from State, a stateful, entry *AuditEntry) error {
+		m.store.mu.Lock()
+		defer m.store.mu.Unlock()
+
+		if !m.store.setState(m.Guild, t.assetType, uid, from, a.GetState(), a.GetStateTimes()) {
+			return fmt.Errorf("%v %v is no longer %v : %w", t.assetType, uid, from, ErrIllegalTransition)
+		}
+		m.store.audit(m.now(), entry)
+		return nil
+	})
+}
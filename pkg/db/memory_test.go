@@ -0,0 +1,299 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+func TestCreateGetClose(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		stock, short, coin, option := b.id("stock"), b.id("short"), b.id("coin"), b.id("option")
+
+		exit, exists, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 110, 120, 0, 90, 0, 0, 100)
+		must(t, "CreateStock", err)
+		if exists {
+			t.Error("a new stock was reported as existing")
+		}
+		again, exists, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 110, 120, 0, 90, 0, 0, 100)
+		must(t, "CreateStock again", err)
+		if !exists || again != exit {
+			t.Error("creating the stock again didn't hand back its monitor")
+		}
+		_, _, err = r.CreateShort(short, "TSLA", "caller", utils.SWING, 180, 0, 0, 220, 0, 0, 200)
+		must(t, "CreateShort", err)
+		_, _, err = r.CreateCrypto(coin, "BTC", "other", 0, 0, 0, 0, 0, utils.SWING, 20000)
+		must(t, "CreateCrypto", err)
+		_, _, _, err = r.CreateOption(option, "oid", "caller", utils.SWING, "AAPL", "C", "20", "11", "2026", 150, 2, 0, 0, 0, 0, 145)
+		must(t, "CreateOption", err)
+
+		s, err := r.GetStock(stock)
+		must(t, "GetStock", err)
+		if s.StockTicker != "AAPL" || s.StockStarting != 100 || !s.StockCallTime.Equal(start) {
+			t.Errorf("got stock %+v", s)
+		}
+		if s.GetState() != StateActive {
+			t.Errorf("stock without a PoI is %v, want %v", s.GetState(), StateActive)
+		}
+
+		stocks, shorts, crypto, options, err := r.GetAll()
+		must(t, "GetAll", err)
+		if len(stocks) != 1 || len(shorts) != 1 || len(crypto) != 1 || len(options) != 1 {
+			t.Errorf("GetAll returned %d stocks, %d shorts, %d crypto, %d options", len(stocks), len(shorts), len(crypto), len(options))
+		}
+		stocks, shorts, crypto, options, err = r.GetAllCaller("other")
+		must(t, "GetAllCaller", err)
+		if len(stocks) != 0 || len(shorts) != 0 || len(crypto) != 1 || len(options) != 0 {
+			t.Errorf("GetAllCaller returned %d stocks, %d shorts, %d crypto, %d options", len(stocks), len(shorts), len(crypto), len(options))
+		}
+
+		b.clock.Advance(time.Hour)
+		closed, err := r.CloseStock(stock, CloseReasonTarget, 120)
+		must(t, "CloseStock", err)
+		if closed.ClosePrice != 120 || closed.PctGain != 20 || !closed.CloseTime.Equal(start.Add(time.Hour)) {
+			t.Errorf("closed %+v", closed)
+		}
+		if !signalled(exit) {
+			t.Error("the stock's monitor was not signalled")
+		}
+		_, err = r.GetStock(stock)
+		wantErr(t, "GetStock after close", err, ErrNotFound)
+		_, err = r.CloseStock(stock, CloseReasonTarget, 120)
+		wantErr(t, "CloseStock twice", err, ErrNotFound)
+
+		must(t, "RemoveShort", r.RemoveShort(short))
+		must(t, "RemoveCrypto", r.RemoveCrypto(coin))
+		must(t, "RemoveOptionByCode", r.RemoveOptionByCode(option))
+		_, err = r.GetShort(short)
+		wantErr(t, "GetShort after remove", err, ErrNotFound)
+		_, err = r.GetCrypto(coin)
+		wantErr(t, "GetCrypto after remove", err, ErrNotFound)
+		_, err = r.GetOption(option)
+		wantErr(t, "GetOption after remove", err, ErrNotFound)
+
+		all, err := r.GetClosedAlerts(time.Time{})
+		must(t, "GetClosedAlerts", err)
+		if len(all) != 4 {
+			t.Errorf("archived %d alerts, want 4", len(all))
+		}
+		mine, err := r.GetClosedAlertsCaller("other", time.Time{})
+		must(t, "GetClosedAlertsCaller", err)
+		if len(mine) != 1 || mine[0].AlertID != coin {
+			t.Errorf("archived %+v for other, want the crypto", mine)
+		}
+	})
+}
+
+func TestHighsAndLows(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		stock, short := b.id("stock"), b.id("short")
+
+		_, _, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, _, err = r.CreateShort(short, "TSLA", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 200)
+		must(t, "CreateShort", err)
+
+		for _, c := range []struct {
+			price float32
+			moved bool
+		}{{110, true}, {105, false}, {110, false}, {115, true}} {
+			moved, err := r.StockUpdateHigh(stock, c.price)
+			must(t, "StockUpdateHigh", err)
+			if moved != c.moved {
+				t.Errorf("StockUpdateHigh(%v) moved %v, want %v", c.price, moved, c.moved)
+			}
+		}
+		s, err := r.GetStock(stock)
+		must(t, "GetStock", err)
+		if s.StockHighest != 115 {
+			t.Errorf("stock high is %v, want 115", s.StockHighest)
+		}
+
+		for _, c := range []struct {
+			price float32
+			moved bool
+		}{{190, true}, {195, false}, {190, false}, {185, true}} {
+			moved, err := r.ShortUpdateLow(short, c.price)
+			must(t, "ShortUpdateLow", err)
+			if moved != c.moved {
+				t.Errorf("ShortUpdateLow(%v) moved %v, want %v", c.price, moved, c.moved)
+			}
+		}
+		sh, err := r.GetShort(short)
+		must(t, "GetShort", err)
+		if sh.ShortLowest != 185 {
+			t.Errorf("short low is %v, want 185", sh.ShortLowest)
+		}
+
+		_, err = r.StockUpdateHigh(b.id("missing"), 1)
+		wantErr(t, "StockUpdateHigh on a missing stock", err, ErrNotFound)
+	})
+}
+
+func TestPOIHit(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		stock, coin := b.id("stock"), b.id("coin")
+
+		_, _, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 0, 0, 95, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, _, err = r.CreateCrypto(coin, "BTC", "caller", 0, 0, 19000, 0, 0, utils.SWING, 20000)
+		must(t, "CreateCrypto", err)
+
+		s, err := r.GetStock(stock)
+		must(t, "GetStock", err)
+		if s.GetState() != StatePending {
+			t.Fatalf("stock with a PoI is %v, want %v", s.GetState(), StatePending)
+		}
+
+		b.clock.Advance(time.Minute)
+		must(t, "StockPOIHit", r.StockPOIHit(stock))
+		s, err = r.GetStock(stock)
+		must(t, "GetStock", err)
+		if !s.StockPOIHit || s.GetState() != StateActive {
+			t.Errorf("after the PoI hit the stock is %v with poi_hit %v", s.GetState(), s.StockPOIHit)
+		}
+		if at := s.GetStateTimes()[StateActive]; !at.Equal(start.Add(time.Minute)) {
+			t.Errorf("stock went active at %v, want %v", at, start.Add(time.Minute))
+		}
+
+		err = r.StockPOIHit(b.id("missing"))
+		wantErr(t, "StockPOIHit on a missing stock", err, ErrNotFound)
+
+		if !r.Registry().Cancel(b.id("g"), coin) {
+			t.Fatal("the crypto had no monitor to cancel")
+		}
+		err = r.CryptoPOIHit(coin)
+		wantErr(t, "CryptoPOIHit without a monitor", err, ErrNoMonitor)
+	})
+}
+
+func TestClosedKeepsDetails(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		stock := b.id("stock")
+
+		_, _, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 110, 120, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, err = r.AddFill(AssetStock, stock, FillAdd, 90, 1)
+		must(t, "AddFill", err)
+
+		targets, err := r.GetTargets(AssetStock, stock)
+		must(t, "GetTargets", err)
+		fills, err := r.GetFills(AssetStock, stock)
+		must(t, "GetFills", err)
+		if len(targets) != 2 || len(fills) != 2 {
+			t.Fatalf("open stock has %d targets and %d fills, want 2 and 2", len(targets), len(fills))
+		}
+
+		closed, err := r.CloseStock(stock, CloseReasonTarget, 120)
+		must(t, "CloseStock", err)
+
+		targets, err = r.GetTargets(AssetStock, stock)
+		must(t, "GetTargets after close", err)
+		fills, err = r.GetFills(AssetStock, stock)
+		must(t, "GetFills after close", err)
+		if len(targets) != 0 || len(fills) != 0 {
+			t.Errorf("closed stock still has %d open targets and %d open fills", len(targets), len(fills))
+		}
+
+		targets, err = r.GetClosedTargets(closed.ClosedID)
+		must(t, "GetClosedTargets", err)
+		fills, err = r.GetClosedFills(closed.ClosedID)
+		must(t, "GetClosedFills", err)
+		if len(targets) != 2 || len(fills) != 2 {
+			t.Errorf("archived %d targets and %d fills, want 2 and 2", len(targets), len(fills))
+		}
+
+		_, _, err = r.CreateStock(stock, "AAPL", "caller", utils.SWING, 130, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock reusing the ID", err)
+		targets, err = r.GetTargets(AssetStock, stock)
+		must(t, "GetTargets for the new stock", err)
+		if len(targets) != 1 {
+			t.Errorf("the new stock has %d targets, want only its own", len(targets))
+		}
+	})
+}
+
+func TestRecreateResetsDetails(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+		stock := b.id("stock")
+
+		_, _, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 110, 120, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, err = r.AddFill(AssetStock, stock, FillAdd, 90, 1)
+		must(t, "AddFill", err)
+
+		// Losing the monitor is what sends a create through to the store a second time.
+		r.Registry().Cancel(b.id("g"), stock)
+		_, exists, err := r.CreateStock(stock, "AAPL", "caller", utils.SWING, 130, 0, 0, 0, 0, 0, 105)
+		must(t, "CreateStock again", err)
+		if exists {
+			t.Error("the re-created stock was reported as still monitored")
+		}
+
+		pos, err := r.GetPosition(AssetStock, stock)
+		must(t, "GetPosition", err)
+		if pos.Size != 1 || pos.Entry != 105 || pos.AvgCost != 105 {
+			t.Errorf("after re-creating, the position is %+v, want 1 at 105", pos)
+		}
+		fills, err := r.GetFills(AssetStock, stock)
+		must(t, "GetFills", err)
+		if len(fills) != 1 || fills[0].Kind != FillBuy {
+			t.Errorf("after re-creating, the ledger is %+v, want just the new buy", fills)
+		}
+		targets, err := r.GetTargets(AssetStock, stock)
+		must(t, "GetTargets", err)
+		if len(targets) != 1 || targets[0].Price != 130 {
+			t.Errorf("after re-creating, the targets are %+v, want just 130", targets)
+		}
+	})
+}
+
+func TestRmAll(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b *backend) {
+		r := b.repo("g")
+
+		_, _, err := r.CreateStock(b.id("mine"), "AAPL", "caller", utils.SWING, 0, 0, 0, 0, 0, 0, 100)
+		must(t, "CreateStock", err)
+		_, _, err = r.CreateShort(b.id("theirs"), "TSLA", "other", utils.SWING, 0, 0, 0, 0, 0, 0, 200)
+		must(t, "CreateShort", err)
+
+		report, err := r.RmAllCaller("caller")
+		must(t, "RmAllCaller", err)
+		if len(report.Stocks) != 1 || report.Total() != 1 {
+			t.Errorf("RmAllCaller removed %+v, want only caller's stock", report)
+		}
+
+		report, err = r.RmAll()
+		must(t, "RmAll", err)
+		if len(report.Shorts) != 1 || report.Total() != 1 {
+			t.Errorf("RmAll removed %+v, want the remaining short", report)
+		}
+
+		stocks, shorts, crypto, options, err := r.GetAll()
+		must(t, "GetAll", err)
+		if len(stocks)+len(shorts)+len(crypto)+len(options) != 0 {
+			t.Error("alerts were left after RmAll")
+		}
+	})
+}
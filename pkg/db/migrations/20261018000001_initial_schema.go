@@ -0,0 +1,43 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// The tables as NewDB used to create them. IF NOT EXISTS keeps this a no-op on databases that predate migrations.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS "channels" ("user_guild_composite" VARCHAR NOT NULL, "user_id" VARCHAR, "role_id" VARCHAR, "guild_id" VARCHAR, "channel_id" VARCHAR, "permissions_id" VARCHAR, "eod" VARCHAR, PRIMARY KEY ("user_guild_composite"))`,
+			`CREATE TABLE IF NOT EXISTS "stocks" ("stock_alert_id" VARCHAR NOT NULL, "stock_guild_id" VARCHAR, "stock_ticker" VARCHAR, "stock_starting" REAL, "stock_e_pt" REAL, "stock_s_pt" REAL, "stock_expiry" BIGINT, "stock_highest" REAL, "stock_last_high" REAL, "stock_poi" REAL, "stock_stop" REAL, "stock_trailing_stop" REAL, "alert_type" BIGINT, "caller" VARCHAR, "stock_poi_hit" BOOLEAN, "stock_call_time" TIMESTAMPTZ, PRIMARY KEY ("stock_alert_id"))`,
+			`CREATE TABLE IF NOT EXISTS "shorts" ("short_alert_id" VARCHAR NOT NULL, "short_guild_id" VARCHAR, "short_ticker" VARCHAR, "short_starting" REAL, "short_s_pt" REAL, "short_e_pt" REAL, "short_expiry" BIGINT, "short_lowest" REAL, "short_last_low" REAL, "short_poi" REAL, "short_stop" REAL, "short_trailing_stop" REAL, "alert_type" BIGINT, "caller" VARCHAR, "short_poi_hit" BOOLEAN, "short_call_time" TIMESTAMPTZ, PRIMARY KEY ("short_alert_id"))`,
+			`CREATE TABLE IF NOT EXISTS "options" ("option_alert_id" VARCHAR NOT NULL, "option_guild_id" VARCHAR, "option_ticker" VARCHAR, "option_uid" VARCHAR, "option_contract_type" VARCHAR, "option_day" VARCHAR, "option_month" VARCHAR, "option_year" VARCHAR, "option_strike" REAL, "option_starting" REAL, "option_highest" REAL, "option_last_high" REAL, "option_trailing_stop" REAL, "option_underlying_poi" REAL, "option_underlying_stop" REAL, "option_underlying_starting" REAL, "alert_type" BIGINT, "caller" VARCHAR, "option_underlying_poi_hit" BOOLEAN, "option_call_time" TIMESTAMPTZ, PRIMARY KEY ("option_alert_id"))`,
+			`CREATE TABLE IF NOT EXISTS "cryptos" ("crypto_alert_id" VARCHAR NOT NULL, "crypto_guild_id" VARCHAR, "crypto_coin" VARCHAR, "crypto_starting" REAL, "crypto_s_pt" REAL, "crypto_e_pt" REAL, "crypto_expiry" BIGINT, "crypto_highest" REAL, "crypto_last_high" REAL, "crypto_stop" REAL, "crypto_trailing_stop" REAL, "crypto_poi" REAL, "alert_type" BIGINT, "caller" VARCHAR, "crypto_poi_hit" BOOLEAN, "crypto_call_time" TIMESTAMPTZ, PRIMARY KEY ("crypto_alert_id"))`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`DROP TABLE IF EXISTS "cryptos"`,
+			`DROP TABLE IF EXISTS "options"`,
+			`DROP TABLE IF EXISTS "shorts"`,
+			`DROP TABLE IF EXISTS "stocks"`,
+			`DROP TABLE IF EXISTS "channels"`,
+		)
+	})
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// closed_alerts keeps alerts around after they're removed, so their history isn't lost.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS "closed_alerts" ("closed_id" BIGSERIAL NOT NULL, "alert_id" VARCHAR, "guild_id" VARCHAR, "asset_type" VARCHAR, "ticker" VARCHAR, "contract" VARCHAR, "alert_type" BIGINT, "caller" VARCHAR, "starting" REAL, "highest" REAL, "call_time" TIMESTAMPTZ, "close_reason" VARCHAR, "close_price" REAL, "close_time" TIMESTAMPTZ, "pct_gain" REAL, PRIMARY KEY ("closed_id"))`,
+			`CREATE INDEX IF NOT EXISTS "closed_alerts_guild_close_time_idx" ON "closed_alerts" ("guild_id", "close_time" DESC)`,
+			`CREATE INDEX IF NOT EXISTS "closed_alerts_guild_caller_idx" ON "closed_alerts" ("guild_id", "caller")`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`DROP TABLE IF EXISTS "closed_alerts"`,
+		)
+	})
+}
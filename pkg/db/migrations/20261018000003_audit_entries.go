@@ -0,0 +1,46 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// audit_entries records every change to an alert. The trigger keeps it append-only.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS "audit_entries" ("audit_id" BIGSERIAL NOT NULL, "guild_id" VARCHAR, "alert_id" VARCHAR, "asset_type" VARCHAR, "caller" VARCHAR, "actor" VARCHAR, "action" VARCHAR, "field" VARCHAR, "before" VARCHAR, "after" VARCHAR, "snapshot" VARCHAR, "at" TIMESTAMPTZ, PRIMARY KEY ("audit_id"))`,
+			`CREATE INDEX IF NOT EXISTS "audit_entries_guild_alert_idx" ON "audit_entries" ("guild_id", "alert_id")`,
+			`CREATE INDEX IF NOT EXISTS "audit_entries_guild_caller_idx" ON "audit_entries" ("guild_id", "caller")`,
+			`CREATE INDEX IF NOT EXISTS "audit_entries_guild_actor_idx" ON "audit_entries" ("guild_id", "actor")`,
+			`CREATE OR REPLACE FUNCTION "audit_entries_append_only"() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS "audit_entries_append_only" ON "audit_entries"`,
+			`CREATE TRIGGER "audit_entries_append_only" BEFORE UPDATE OR DELETE ON "audit_entries" FOR EACH ROW EXECUTE PROCEDURE "audit_entries_append_only"()`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`DROP TABLE IF EXISTS "audit_entries"`,
+			`DROP FUNCTION IF EXISTS "audit_entries_append_only"()`,
+		)
+	})
+}
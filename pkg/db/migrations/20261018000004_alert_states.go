@@ -0,0 +1,52 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Alert lifecycle states. Existing alerts start out pending if they are still waiting on their PoI, otherwise active,
+// as of their call time.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`ALTER TABLE "stocks" ADD COLUMN IF NOT EXISTS "stock_state" VARCHAR, ADD COLUMN IF NOT EXISTS "stock_state_times" JSONB`,
+			`ALTER TABLE "shorts" ADD COLUMN IF NOT EXISTS "short_state" VARCHAR, ADD COLUMN IF NOT EXISTS "short_state_times" JSONB`,
+			`ALTER TABLE "cryptos" ADD COLUMN IF NOT EXISTS "crypto_state" VARCHAR, ADD COLUMN IF NOT EXISTS "crypto_state_times" JSONB`,
+			`ALTER TABLE "options" ADD COLUMN IF NOT EXISTS "option_state" VARCHAR, ADD COLUMN IF NOT EXISTS "option_state_times" JSONB`,
+			`ALTER TABLE "closed_alerts" ADD COLUMN IF NOT EXISTS "states" JSONB`,
+			`UPDATE "stocks" SET "stock_state" = CASE WHEN "stock_poi" <> 0 AND NOT "stock_poi_hit" THEN 'pending' ELSE 'active' END WHERE "stock_state" IS NULL`,
+			`UPDATE "shorts" SET "short_state" = CASE WHEN "short_poi" <> 0 AND NOT "short_poi_hit" THEN 'pending' ELSE 'active' END WHERE "short_state" IS NULL`,
+			`UPDATE "cryptos" SET "crypto_state" = CASE WHEN "crypto_poi" <> 0 AND NOT "crypto_poi_hit" THEN 'pending' ELSE 'active' END WHERE "crypto_state" IS NULL`,
+			`UPDATE "options" SET "option_state" = CASE WHEN "option_underlying_poi" <> 0 AND NOT "option_underlying_poi_hit" THEN 'pending' ELSE 'active' END WHERE "option_state" IS NULL`,
+			`UPDATE "stocks" SET "stock_state_times" = jsonb_build_object("stock_state", "stock_call_time") WHERE "stock_state_times" IS NULL`,
+			`UPDATE "shorts" SET "short_state_times" = jsonb_build_object("short_state", "short_call_time") WHERE "short_state_times" IS NULL`,
+			`UPDATE "cryptos" SET "crypto_state_times" = jsonb_build_object("crypto_state", "crypto_call_time") WHERE "crypto_state_times" IS NULL`,
+			`UPDATE "options" SET "option_state_times" = jsonb_build_object("option_state", "option_call_time") WHERE "option_state_times" IS NULL`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`ALTER TABLE "stocks" DROP COLUMN IF EXISTS "stock_state", DROP COLUMN IF EXISTS "stock_state_times"`,
+			`ALTER TABLE "shorts" DROP COLUMN IF EXISTS "short_state", DROP COLUMN IF EXISTS "short_state_times"`,
+			`ALTER TABLE "cryptos" DROP COLUMN IF EXISTS "crypto_state", DROP COLUMN IF EXISTS "crypto_state_times"`,
+			`ALTER TABLE "options" DROP COLUMN IF EXISTS "option_state", DROP COLUMN IF EXISTS "option_state_times"`,
+			`ALTER TABLE "closed_alerts" DROP COLUMN IF EXISTS "states"`,
+		)
+	})
+}
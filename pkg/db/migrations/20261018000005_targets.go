@@ -0,0 +1,46 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// targets holds every price target of an alert, with when and where it was hit. Stock, short and crypto alerts
+// carry their existing s_pt and e_pt over.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS "targets" ("target_id" BIGSERIAL NOT NULL, "guild_id" VARCHAR, "alert_id" VARCHAR, "asset_type" VARCHAR, "series" VARCHAR, "price" REAL, "hit_price" REAL, "hit_time" TIMESTAMPTZ, "created_at" TIMESTAMPTZ, PRIMARY KEY ("target_id"))`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "targets_alert_price_idx" ON "targets" ("guild_id", "asset_type", "alert_id", "series", "price")`,
+			`INSERT INTO "targets" ("guild_id", "alert_id", "asset_type", "series", "price", "hit_price", "created_at")
+SELECT "stock_guild_id", "stock_alert_id", 'stock', 'price', pt, 0, "stock_call_time" FROM "stocks", unnest(ARRAY["stock_s_pt", "stock_e_pt"]) AS pt WHERE pt <> 0
+ON CONFLICT DO NOTHING`,
+			`INSERT INTO "targets" ("guild_id", "alert_id", "asset_type", "series", "price", "hit_price", "created_at")
+SELECT "short_guild_id", "short_alert_id", 'short', 'price', pt, 0, "short_call_time" FROM "shorts", unnest(ARRAY["short_s_pt", "short_e_pt"]) AS pt WHERE pt <> 0
+ON CONFLICT DO NOTHING`,
+			`INSERT INTO "targets" ("guild_id", "alert_id", "asset_type", "series", "price", "hit_price", "created_at")
+SELECT "crypto_guild_id", "crypto_alert_id", 'crypto', 'price', pt, 0, "crypto_call_time" FROM "cryptos", unnest(ARRAY["crypto_s_pt", "crypto_e_pt"]) AS pt WHERE pt <> 0
+ON CONFLICT DO NOTHING`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`DROP TABLE IF EXISTS "targets"`,
+		)
+	})
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// fills is the ledger of entries into and exits out of each alert. Existing alerts open with a buy of 1 at their
+// starting price as of their call time, which is the best record of their entry left.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`CREATE TABLE IF NOT EXISTS "fills" ("fill_id" BIGSERIAL NOT NULL, "guild_id" VARCHAR, "alert_id" VARCHAR, "asset_type" VARCHAR, "kind" VARCHAR, "price" REAL, "size" REAL, "caller" VARCHAR, "at" TIMESTAMPTZ, PRIMARY KEY ("fill_id"))`,
+			`CREATE INDEX IF NOT EXISTS "fills_guild_alert_idx" ON "fills" ("guild_id", "asset_type", "alert_id")`,
+			`INSERT INTO "fills" ("guild_id", "alert_id", "asset_type", "kind", "price", "size", "caller", "at")
+SELECT "stock_guild_id", "stock_alert_id", 'stock', 'buy', "stock_starting", 1, "caller", "stock_call_time" FROM "stocks"`,
+			`INSERT INTO "fills" ("guild_id", "alert_id", "asset_type", "kind", "price", "size", "caller", "at")
+SELECT "short_guild_id", "short_alert_id", 'short', 'buy', "short_starting", 1, "caller", "short_call_time" FROM "shorts"`,
+			`INSERT INTO "fills" ("guild_id", "alert_id", "asset_type", "kind", "price", "size", "caller", "at")
+SELECT "crypto_guild_id", "crypto_alert_id", 'crypto', 'buy', "crypto_starting", 1, "caller", "crypto_call_time" FROM "cryptos"`,
+			`INSERT INTO "fills" ("guild_id", "alert_id", "asset_type", "kind", "price", "size", "caller", "at")
+SELECT "option_guild_id", "option_alert_id", 'option', 'buy', "option_starting", 1, "caller", "option_call_time" FROM "options"`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`DROP TABLE IF EXISTS "fills"`,
+		)
+	})
+}
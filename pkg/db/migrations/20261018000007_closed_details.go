@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// closed_details keeps a closed alert's targets and fills, filed under the closed_alerts row it was archived as,
+// instead of deleting them. Only open targets need to be unique, so a reused alert ID can set the same ones again.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`ALTER TABLE "targets" ADD COLUMN IF NOT EXISTS "closed_id" BIGINT`,
+			`ALTER TABLE "fills" ADD COLUMN IF NOT EXISTS "closed_id" BIGINT`,
+			`DROP INDEX IF EXISTS "targets_alert_price_idx"`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "targets_alert_price_idx" ON "targets" ("guild_id", "asset_type", "alert_id", "series", "price") WHERE "closed_id" IS NULL`,
+			`CREATE INDEX IF NOT EXISTS "targets_closed_idx" ON "targets" ("guild_id", "closed_id")`,
+			`CREATE INDEX IF NOT EXISTS "fills_closed_idx" ON "fills" ("guild_id", "closed_id")`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`DROP INDEX IF EXISTS "fills_closed_idx"`,
+			`DROP INDEX IF EXISTS "targets_closed_idx"`,
+			`DELETE FROM "targets" WHERE "closed_id" IS NOT NULL`,
+			`DELETE FROM "fills" WHERE "closed_id" IS NOT NULL`,
+			`DROP INDEX IF EXISTS "targets_alert_price_idx"`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "targets_alert_price_idx" ON "targets" ("guild_id", "asset_type", "alert_id", "series", "price")`,
+			`ALTER TABLE "fills" DROP COLUMN IF EXISTS "closed_id"`,
+			`ALTER TABLE "targets" DROP COLUMN IF EXISTS "closed_id"`,
+		)
+	})
+}
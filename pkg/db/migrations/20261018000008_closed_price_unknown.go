@@ -0,0 +1,36 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// closed_price_unknown flags archived alerts closed without a real price, such as expired ones, so their pct_gain
+// isn't mistaken for a result.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`ALTER TABLE "closed_alerts" ADD COLUMN IF NOT EXISTS "price_unknown" BOOLEAN NOT NULL DEFAULT FALSE`,
+		)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return execInTx(ctx, db,
+			`ALTER TABLE "closed_alerts" DROP COLUMN IF EXISTS "price_unknown"`,
+		)
+	})
+}
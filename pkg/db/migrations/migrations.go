@@ -0,0 +1,131 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+)
+
+// Migrations holds every schema change, registered by the numbered files in this package.
+// Each file must be named <14 digit timestamp>_<description>.go; the timestamp is the version.
+var Migrations = migrate.NewMigrations()
+
+// lockKey is the Postgres advisory lock held while migrating, "harpe" in ASCII.
+const lockKey int64 = 0x6861727065
+
+// locked runs fn holding lockKey, so bot processes starting together migrate one after another instead of racing to
+// apply the same migration. Advisory locks belong to a session, so one connection is held for the duration.
+func locked(ctx context.Context, db *bun.DB, fn func() error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get a connection to lock migrations on: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", lockKey); err != nil {
+		return fmt.Errorf("unable to lock migrations: %w", err)
+	}
+	defer func() {
+		// A cancelled ctx must not leave the lock held on a connection going back to the pool.
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(?)", lockKey); err != nil {
+			log.Println(fmt.Sprintf("Unable to unlock migrations : %v", err))
+		}
+	}()
+
+	return fn()
+}
+
+// Up applies every migration that has not been applied yet, as a single group, holding the migration lock.
+func Up(ctx context.Context, db *bun.DB) (*migrate.MigrationGroup, error) {
+	migrator := migrate.NewMigrator(db, Migrations)
+
+	var group *migrate.MigrationGroup
+	err := locked(ctx, db, func() (err error) {
+		if err = migrator.Init(ctx); err != nil {
+			return fmt.Errorf("unable to create migration tables: %w", err)
+		}
+
+		if group, err = migrator.Migrate(ctx); err != nil {
+			return fmt.Errorf("unable to migrate: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return group, err
+	}
+
+	if group.IsZero() {
+		log.Println("Schema up to date")
+	} else {
+		log.Println("Migrated to " + group.String())
+	}
+	return group, nil
+}
+
+// Down rolls back the last group of applied migrations, holding the migration lock.
+func Down(ctx context.Context, db *bun.DB) (*migrate.MigrationGroup, error) {
+	migrator := migrate.NewMigrator(db, Migrations)
+
+	var group *migrate.MigrationGroup
+	err := locked(ctx, db, func() (err error) {
+		if err = migrator.Init(ctx); err != nil {
+			return fmt.Errorf("unable to create migration tables: %w", err)
+		}
+
+		if group, err = migrator.Rollback(ctx); err != nil {
+			return fmt.Errorf("unable to rollback: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return group, err
+	}
+
+	if group.IsZero() {
+		log.Println("Nothing to roll back")
+	} else {
+		log.Println("Rolled back " + group.String())
+	}
+	return group, nil
+}
+
+// Status returns every known migration, with the applied ones carrying their group ID.
+func Status(ctx context.Context, db *bun.DB) (migrate.MigrationSlice, error) {
+	migrator := migrate.NewMigrator(db, Migrations)
+
+	if err := migrator.Init(ctx); err != nil {
+		return nil, fmt.Errorf("unable to create migration tables: %w", err)
+	}
+
+	return migrator.MigrationsWithStatus(ctx)
+}
+
+// execInTx runs each statement in order inside one transaction, so a migration either fully applies or not at all.
+func execInTx(ctx context.Context, db *bun.DB, stmts ...string) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -17,34 +17,37 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"sync"
 	"time"
 
 	"github.com/m1k8/harpe/pkg/utils"
+	"github.com/uptrace/bun"
 )
 
 func (d *DB) CreateOption(uid, oID, author string, alertType int, ticker, contractType, day, month, year string, price, starting, pt, poi, stop, tstop, underStart float32) (chan bool, string, bool, error) {
+	return d.CreateOptionContext(context.Background(), uid, oID, author, alertType, ticker, contractType, day, month, year, price, starting, pt, poi, stop, tstop, underStart)
+}
 
-	chanMap.LoadOrStore(d.Guild, &sync.Map{})
-	exists, exitChan := d.GetExitChanExists(uid)
-
-	if exists {
-		return exitChan, oID, exists, nil
-	}
-
+func (d *DB) CreateOptionContext(ctx context.Context, uid, oID, author string, alertType int, ticker, contractType, day, month, year string, price, starting, pt, poi, stop, tstop, underStart float32) (chan bool, string, bool, error) {
 	if len(year) != 4 {
-		return nil, "", false, errors.New("invalid Syntax - year is incorrect")
+		return nil, "", false, invalidInput("invalid Syntax - year is incorrect")
 	}
 
 	if len(month) > 2 || len(month) == 0 {
-		return nil, "", false, errors.New("invalid Syntax - month is incorrect")
+		return nil, "", false, invalidInput("invalid Syntax - month is incorrect")
 	}
 
 	if len(day) > 2 || len(day) == 0 {
-		return nil, "", false, errors.New("invalid Syntax - day is incorrect")
+		return nil, "", false, invalidInput("invalid Syntax - day is incorrect")
+	}
+
+	exists, exitChan := d.GetExitChanExists(uid)
+
+	if exists {
+		return exitChan, oID, exists, nil
 	}
 	s := &Option{
 		OptionAlertID:            uid,
@@ -58,7 +61,7 @@ func (d *DB) CreateOption(uid, oID, author string, alertType int, ticker, contra
 		OptionStrike:             price,
 		OptionStarting:           starting,
 		AlertType:                alertType,
-		OptionCallTime:           time.Now(),
+		OptionCallTime:           d.now(),
 		OptionHighest:            starting,
 		OptionLastHigh:           starting,
 		OptionTrailingStop:       tstop,
@@ -67,11 +70,16 @@ func (d *DB) CreateOption(uid, oID, author string, alertType int, ticker, contra
 		OptionUnderlyingStarting: underStart,
 		OptionUnderlyingPOIHit:   false,
 		Caller:                   author,
+		OptionState:              initialState(poi, false),
 	}
+	s.OptionStateTimes = initialTimes(s.OptionState, s.OptionCallTime)
 
-	_, err := d.db.NewInsert().Model(s).On("CONFLICT (option_alert_id) DO UPDATE").Exec(context.Background())
+	targets := seedTargets(d.Guild, uid, AssetOption, TargetPremium, d.now(), pt)
+	fill := openingFill(d.Guild, uid, AssetOption, author, s.OptionStarting, s.OptionCallTime)
+	err := d.createAudited(ctx, uid, s, optionTable, targets, fill, s.auditEntry(ctx, AuditCreate))
 
 	if err != nil {
+		d.reg.Cancel(d.Guild, uid)
 		log.Println(fmt.Sprintf("Unable to create option %v: %v.", uid, err.Error()))
 		return nil, oID, false, err
 	}
@@ -80,42 +88,77 @@ func (d *DB) CreateOption(uid, oID, author string, alertType int, ticker, contra
 }
 
 func (d *DB) RemoveOptionByCode(uid string) error {
-	contxt := context.Background()
+	return d.RemoveOptionByCodeContext(context.Background(), uid)
+}
 
-	s := &Option{
-		OptionGuildID: d.Guild,
-		OptionAlertID: uid,
-	}
-	_, err := d.db.NewDelete().Model(s).Where("option_alert_id = ?", uid).Exec(contxt)
+func (d *DB) RemoveOptionByCodeContext(ctx context.Context, uid string) error {
+	_, err := d.CloseOptionContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to remove option %v: %v.", uid, err.Error()))
-		return err
-	}
-	clearFromSyncMap(chanMap, d.Guild, uid)
-	return nil
+// CloseOption removes the option and archives it with reason. A zero price archives it at its highest price.
+func (d *DB) CloseOption(uid, reason string, price float32) (*ClosedAlert, error) {
+	return d.CloseOptionContext(context.Background(), uid, reason, price)
 }
 
-func (d *DB) SwitchOptionsTypeByCode(uid string) (string, error) {
-	contxt := context.Background()
-	retStr := ""
+func (d *DB) CloseOptionContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	o := &Option{}
+	var closed *ClosedAlert
+
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().Model(o).Where("option_alert_id = ?", uid).Where("option_guild_id = ?", d.Guild).Returning("*").Exec(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("Unable to remove option %v : %w", uid, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("Unable to remove option %v : %w", uid, ErrNotFound)
+		}
+
+		closed = o.closed(reason, price, d.now())
+		_, err = tx.NewInsert().Model(closed).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err = archiveDetails(ctx, tx, d.Guild, closed); err != nil {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), o.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	})
 
-	s, err := d.GetOption(uid)
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get Option %v : %v", uid, err.Error()))
-		return "", err
+		log.Println(fmt.Sprintf("Unable to close option %v : %v", uid, err.Error()))
+		return nil, err
 	}
+	d.reg.Cancel(d.Guild, uid)
+	return closed, nil
+}
 
-	old := s.AlertType
+func (d *DB) SwitchOptionsTypeByCode(uid string) (string, error) {
+	return d.SwitchOptionsTypeByCodeContext(context.Background(), uid)
+}
 
-	if old == utils.DAY {
-		s.AlertType = utils.SWING
-		retStr = "Swing"
-	} else {
-		s.AlertType = utils.DAY
-		retStr = "Day"
-	}
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (option_alert_id) DO UPDATE").Exec(contxt)
+func (d *DB) SwitchOptionsTypeByCodeContext(ctx context.Context, uid string) (string, error) {
+	retStr := ""
+
+	s := &Option{}
+	err := d.patch(ctx, optionTable, uid, s, func() ([]string, []*AuditEntry, error) {
+		old := s.AlertType
+		entry := s.auditEntry(ctx, AuditSwitchType)
+
+		if old == utils.DAY {
+			s.AlertType = utils.SWING
+			retStr = "Swing"
+		} else {
+			s.AlertType = utils.DAY
+			retStr = "Day"
+		}
+		entry.change("alert_type", old, s.AlertType)
+		return []string{"alert_type"}, []*AuditEntry{entry}, nil
+	})
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update Option %v : %v", uid, err.Error()))
@@ -126,42 +169,47 @@ func (d *DB) SwitchOptionsTypeByCode(uid string) (string, error) {
 }
 
 func (d *DB) GetOption(uid string) (*Option, error) {
+	return d.GetOptionContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
-
+func (d *DB) GetOptionContext(ctx context.Context, uid string) (*Option, error) {
 	s := &Option{
 		OptionGuildID: d.Guild,
 		OptionAlertID: uid,
 	}
-	err := d.db.NewSelect().Model(s).Where("option_alert_id = ?", uid).Scan(contxt)
+	err := d.db.NewSelect().Model(s).Where("option_alert_id = ?", uid).Where("option_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get option %v: %v.", uid, err.Error()))
-		return nil, err
+		return nil, notFound(err, "option", uid)
 	}
-	gMap, ok := chanMap.Load(d.Guild)
-	if ok {
-		gMapCast := gMap.(*sync.Map)
-		_, ok := gMapCast.Load(uid)
-		if !ok {
-			err = errors.New(fmt.Sprintf("Unable to get alert channel for option %v. Please try recreating this alert, or calling !refresh then running this command again.", uid))
-			return nil, err
-		}
+	if !d.reg.monitored(d.Guild, uid) {
+		err = noMonitor("option", uid)
+		log.Println(err.Error())
+		return nil, err
 	}
 	return s, nil
 }
 
 func (d *DB) OptionPOIHit(uid string) error {
-	contxt := context.Background()
-	s, err := d.GetOption(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get option %v : %v", uid, err.Error()))
-		return err
-	}
-
-	s.OptionUnderlyingPOIHit = true
+	return d.OptionPOIHitContext(context.Background(), uid)
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (option_alert_id) DO UPDATE").Exec(contxt)
+func (d *DB) OptionPOIHitContext(ctx context.Context, uid string) error {
+	s := &Option{}
+	err := d.patch(ctx, optionTable, uid, s, func() ([]string, []*AuditEntry, error) {
+		entries := []*AuditEntry{s.auditEntry(ctx, AuditPOIHit).change("poi_hit", s.OptionUnderlyingPOIHit, true)}
+		s.OptionUnderlyingPOIHit = true
+
+		if s.GetState() == StatePending {
+			entry, err := s.moveTo(ctx, StateActive, d.now())
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return []string{"option_underlying_poi_hit", optionTable.state, optionTable.stateTimes}, entries, nil
+	})
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update option %v : %v", uid, err.Error()))
@@ -171,48 +219,133 @@ func (d *DB) OptionPOIHit(uid string) error {
 	return nil
 }
 
-func (d *DB) OptionSetNewHigh(uid string, price float32) error {
-	contxt := context.Background()
+// OptionTransition moves the option to state to, refusing any move its lifecycle does not allow.
+// Moving it to StateClosed closes it, archiving it with the reason its current state implies.
+func (d *DB) OptionTransition(uid string, to State) error {
+	return d.OptionTransitionContext(context.Background(), uid, to)
+}
 
-	s, err := d.GetOption(uid)
+func (d *DB) OptionTransitionContext(ctx context.Context, uid string, to State) error {
+	s, err := d.GetOptionContext(ctx, uid)
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get Option %v : %v", uid, err.Error()))
 		return err
 	}
 
-	s.OptionHighest = price
+	if to == StateClosed {
+		_, err = d.CloseOptionContext(ctx, uid, closeReason(s.GetState()), 0)
+		return err
+	}
+
+	stored := s.OptionState
+	entry, err := s.moveTo(ctx, to, d.now())
+	if err != nil {
+		return err
+	}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (option_alert_id) DO UPDATE").Exec(contxt)
+	err = d.setState(ctx, optionTable, uid, stored, s.OptionState, s.OptionStateTimes, entry)
 
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to update Option %v : %v", uid, err.Error()))
+		log.Println(fmt.Sprintf("Unable to update option %v : %v", uid, err.Error()))
 		return err
 	}
 
 	return nil
 }
 
+func (d *DB) OptionSetNewHigh(uid string, price float32) error {
+	return d.OptionSetNewHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) OptionSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.OptionUpdateHighContext(ctx, uid, price)
+	return err
+}
+
+// OptionUpdateHigh records price as the new high only if it is above the current one, in a single statement,
+// and reports whether it did.
+func (d *DB) OptionUpdateHigh(uid string, price float32) (bool, error) {
+	return d.OptionUpdateHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) OptionUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error) {
+	return d.move(ctx, optionHigh, uid, price)
+}
+
+// OptionSetNewAvg declares price the new average cost, as a FillAvg in the ledger rather than by overwriting the entry.
 func (d *DB) OptionSetNewAvg(uid string, price float32) error {
-	contxt := context.Background()
+	return d.OptionSetNewAvgContext(context.Background(), uid, price)
+}
 
-	s, err := d.GetOption(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get Option %v : %v", uid, err.Error()))
-		return err
+func (d *DB) OptionSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.AddFillContext(ctx, AssetOption, uid, FillAvg, price, 0)
+	return err
+}
+
+// Symbol is the option's OCC symbol, built from its ticker, contract type, expiration and strike.
+func (o Option) Symbol() (utils.OptionSymbol, error) {
+	return utils.NewOptionSymbol(o.OptionTicker, o.OptionContractType, o.OptionDay, o.OptionMonth, o.OptionYear, float64(o.OptionStrike))
+}
+
+func (o Option) GetPctGain(highest float32) float32 {
+	return ((highest - o.OptionStarting) / o.OptionStarting) * 100
+}
+
+func (o Option) closed(reason string, price float32, now time.Time) *ClosedAlert {
+	if price == PriceUnknown {
+		return o.closed(reason, 0, now).unpriced()
+	}
+	if price == 0 {
+		price = o.OptionHighest
 	}
 
-	s.OptionStarting = price
+	return &ClosedAlert{
+		AlertID:     o.OptionAlertID,
+		GuildID:     o.OptionGuildID,
+		AssetType:   AssetOption,
+		Ticker:      o.OptionTicker,
+		Contract:    o.OptionUid,
+		AlertType:   o.AlertType,
+		Caller:      o.Caller,
+		Starting:    o.OptionStarting,
+		Highest:     o.OptionHighest,
+		CallTime:    o.OptionCallTime,
+		CloseReason: reason,
+		ClosePrice:  price,
+		CloseTime:   now,
+		PctGain:     o.GetPctGain(price),
+		States:      closedTimes(o.GetStateTimes(), now),
+	}
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (option_alert_id) DO UPDATE").Exec(contxt)
+func (o *Option) auditEntry(ctx context.Context, action string) *AuditEntry {
+	return newAuditEntry(ctx, action, o.OptionGuildID, o.OptionAlertID, AssetOption, o.Caller, o)
+}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to update Option %v : %v", uid, err.Error()))
-		return err
+// GetState returns the option's lifecycle state, working it out for alerts stored before states were recorded.
+func (o Option) GetState() State {
+	if o.OptionState == "" {
+		return initialState(o.OptionUnderlyingPoI, o.OptionUnderlyingPOIHit)
 	}
+	return o.OptionState
+}
 
-	return nil
+// GetStateTimes returns when the option entered each state it has been in.
+func (o Option) GetStateTimes() StateTimes {
+	if len(o.OptionStateTimes) == 0 {
+		return initialTimes(o.GetState(), o.OptionCallTime)
+	}
+	return o.OptionStateTimes
 }
 
-func (o Option) GetPctGain(highest float32) float32 {
-	return ((highest - o.OptionStarting) / o.OptionStarting) * 100
+func (o *Option) moveTo(ctx context.Context, to State, at time.Time) (*AuditEntry, error) {
+	from := o.GetState()
+	entry := o.auditEntry(ctx, AuditTransition).change("state", from, to)
+
+	times := o.GetStateTimes()
+	if err := advance(&o.OptionState, &times, from, to, at); err != nil {
+		return nil, err
+	}
+	o.OptionStateTimes = times
+	return entry, nil
 }
@@ -0,0 +1,338 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertRegistry keeps track of the monitor behind every open alert, per guild.
+// Each alert gets a context that is cancelled when the alert is closed, its guild is cancelled or the registry shuts down.
+// The exit channel older monitors select on receives true and is closed at the same moment, exactly once.
+// It is safe for concurrent use.
+type AlertRegistry struct {
+	mu       sync.Mutex
+	guilds   map[string]map[string]*monitor
+	held     sync.WaitGroup
+	shutdown bool
+}
+
+// Monitor describes a registered alert.
+type Monitor struct {
+	Guild string
+	ID    string
+	Since time.Time
+}
+
+type monitor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	exit   chan bool
+	since  time.Time
+	held   bool
+	once   sync.Once
+}
+
+func NewAlertRegistry() *AlertRegistry {
+	return &AlertRegistry{
+		guilds: make(map[string]map[string]*monitor),
+	}
+}
+
+// stop cancels the monitor and signals its exit channel without ever blocking. Callers hold r.mu.
+func (m *monitor) stop() {
+	m.once.Do(func() {
+		m.cancel()
+		signal(m.exit)
+	})
+}
+
+// signal sends true on exit if there is room and closes it.
+func signal(exit chan bool) {
+	select {
+	case exit <- true:
+	default:
+	}
+	close(exit)
+}
+
+func newMonitor(exit chan bool, since time.Time) *monitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &monitor{
+		ctx:    ctx,
+		cancel: cancel,
+		exit:   exit,
+		since:  since,
+	}
+}
+
+// guild returns the monitors of guildID, creating the guild if needed. Callers hold r.mu.
+func (r *AlertRegistry) guild(guildID string) map[string]*monitor {
+	g, ok := r.guilds[guildID]
+	if !ok {
+		g = make(map[string]*monitor)
+		r.guilds[guildID] = g
+	}
+	return g
+}
+
+// open returns the monitor for id, creating it as of now if needed, and whether it already existed. Callers hold r.mu.
+func (r *AlertRegistry) open(guildID, id string, now time.Time) (*monitor, bool) {
+	g := r.guild(guildID)
+	if m, ok := g[id]; ok {
+		return m, true
+	}
+
+	m := newMonitor(make(chan bool, 1), now)
+	if r.shutdown {
+		m.stop()
+		return m, false
+	}
+	g[id] = m
+	return m, false
+}
+
+// Register hands out the context for an alert's monitor, and whether the alert was already registered.
+// The returned cancel func deregisters the alert; monitors should call it when they exit, which Shutdown waits for.
+// After Shutdown every registration comes back already cancelled.
+func (r *AlertRegistry) Register(guildID, id string) (context.Context, context.CancelFunc, bool) {
+	return r.RegisterAt(guildID, id, time.Now())
+}
+
+// RegisterAt is Register, recording a new registration as made at now.
+func (r *AlertRegistry) RegisterAt(guildID, id string, now time.Time) (context.Context, context.CancelFunc, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, exists := r.open(guildID, id, now)
+	if r.shutdown {
+		return m.ctx, func() {}, exists
+	}
+	if !m.held {
+		m.held = true
+		r.held.Add(1)
+	}
+
+	var release sync.Once
+	return m.ctx, func() {
+		release.Do(func() {
+			r.mu.Lock()
+			if r.guilds[guildID][id] == m {
+				delete(r.guilds[guildID], id)
+			}
+			m.stop()
+			r.mu.Unlock()
+			r.held.Done()
+		})
+	}, exists
+}
+
+// Open returns the exit channel for an alert, creating it if needed, and whether the alert was already registered.
+func (r *AlertRegistry) Open(guildID, id string) (chan bool, bool) {
+	return r.OpenAt(guildID, id, time.Now())
+}
+
+// OpenAt is Open, recording a new registration as made at now.
+func (r *AlertRegistry) OpenAt(guildID, id string, now time.Time) (chan bool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, exists := r.open(guildID, id, now)
+	if exists {
+		log.Println("Alert " + id + " exists!")
+	}
+	return m.exit, exists
+}
+
+// Attach registers an alert with a caller supplied exit channel. An existing registration keeps its context but
+// swaps to exit, signalling and closing the channel it replaces so the monitor selecting on it stops.
+func (r *AlertRegistry) Attach(guildID, id string, exit chan bool) chan bool {
+	return r.AttachAt(guildID, id, exit, time.Now())
+}
+
+// AttachAt is Attach, recording a new registration as made at now.
+func (r *AlertRegistry) AttachAt(guildID, id string, exit chan bool, now time.Time) chan bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g := r.guild(guildID)
+	if m, ok := g[id]; ok {
+		if m.exit != exit {
+			signal(m.exit)
+			m.exit = exit
+		}
+		return exit
+	}
+
+	m := newMonitor(exit, now)
+	if r.shutdown {
+		m.stop()
+		return exit
+	}
+	g[id] = m
+	return exit
+}
+
+// Lookup returns the context of a registered alert.
+func (r *AlertRegistry) Lookup(guildID, id string) (context.Context, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.guilds[guildID][id]
+	if !ok {
+		return nil, false
+	}
+	return m.ctx, true
+}
+
+// Exit returns the exit channel of a registered alert, or nil.
+func (r *AlertRegistry) Exit(guildID, id string) chan bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.guilds[guildID][id]
+	if !ok {
+		return nil
+	}
+	return m.exit
+}
+
+// Has reports whether an alert is registered.
+func (r *AlertRegistry) Has(guildID, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.guilds[guildID][id]
+	return ok
+}
+
+// Track marks guildID as monitored, so its alerts are expected to be registered from now on.
+func (r *AlertRegistry) Track(guildID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.guild(guildID)
+}
+
+// Tracking reports whether guildID has been loaded into the registry.
+func (r *AlertRegistry) Tracking(guildID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.guilds[guildID]
+	return ok
+}
+
+// monitored is false only when the guild is tracked and the alert has no monitor.
+func (r *AlertRegistry) monitored(guildID, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.guilds[guildID]
+	if !ok {
+		return true
+	}
+	_, ok = g[id]
+	return ok
+}
+
+// Cancel stops the monitor of an alert and deregisters it, reporting whether it was registered.
+func (r *AlertRegistry) Cancel(guildID, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.guilds[guildID][id]
+	if !ok {
+		return false
+	}
+	delete(r.guilds[guildID], id)
+	m.stop()
+	return true
+}
+
+// CancelGuild stops every monitor in guildID and returns the IDs it cancelled.
+func (r *AlertRegistry) CancelGuild(guildID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(r.guilds[guildID]))
+	for id, m := range r.guilds[guildID] {
+		m.stop()
+		ids = append(ids, id)
+	}
+	delete(r.guilds, guildID)
+	sort.Strings(ids)
+	return ids
+}
+
+// List returns the monitors registered in guildID, ordered by ID.
+func (r *AlertRegistry) List(guildID string) []Monitor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]Monitor, 0, len(r.guilds[guildID]))
+	for id, m := range r.guilds[guildID] {
+		list = append(list, Monitor{Guild: guildID, ID: id, Since: m.since})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Guilds returns every guild with a monitor registered, sorted.
+func (r *AlertRegistry) Guilds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	guilds := make([]string, 0, len(r.guilds))
+	for g, ids := range r.guilds {
+		if len(ids) > 0 {
+			guilds = append(guilds, g)
+		}
+	}
+	sort.Strings(guilds)
+	return guilds
+}
+
+// Shutdown stops every monitor, refuses new ones and waits for monitors handed out by Register to release,
+// or for ctx to be done.
+func (r *AlertRegistry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shutdown = true
+	for _, g := range r.guilds {
+		for _, m := range g {
+			m.stop()
+		}
+	}
+	r.guilds = make(map[string]map[string]*monitor)
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.held.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAttachReplacesExit(t *testing.T) {
+	r := NewAlertRegistry()
+	old, _ := r.OpenAt("g", "a", start)
+	ctx, _ := r.Lookup("g", "a")
+
+	exit := make(chan bool, 1)
+	if got := r.AttachAt("g", "a", exit, start.Add(time.Minute)); got != exit {
+		t.Fatalf("AttachAt returned %v, want the channel it was given", got)
+	}
+	if !signalled(old) {
+		t.Error("the replaced exit channel was never signalled")
+	}
+	if _, open := <-old; open {
+		t.Error("the replaced exit channel is still open")
+	}
+	if r.Exit("g", "a") != exit {
+		t.Error("the registration doesn't use the new exit channel")
+	}
+	if ctx.Err() != nil {
+		t.Error("replacing the exit channel cancelled the alert's context")
+	}
+	if list := r.List("g"); len(list) != 1 || !list[0].Since.Equal(start) {
+		t.Errorf("List = %+v, want the one registration from %v", list, start)
+	}
+
+	r.AttachAt("g", "a", exit, start.Add(time.Minute))
+	select {
+	case <-exit:
+		t.Error("attaching the same channel again signalled it")
+	default:
+	}
+
+	r.Cancel("g", "a")
+	if !signalled(exit) {
+		t.Error("cancelling the alert didn't signal its new exit channel")
+	}
+}
+
+func TestAttachAfterShutdown(t *testing.T) {
+	r := NewAlertRegistry()
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	exit := make(chan bool, 1)
+	r.AttachAt("g", "a", exit, start)
+	if !signalled(exit) {
+		t.Error("attaching after shutdown didn't stop the monitor at once")
+	}
+	if r.Has("g", "a") {
+		t.Error("attaching after shutdown registered the alert")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+)
+
+// Repository is everything Kronos needs from a guild-scoped alert store.
+// *DB is the Postgres backed implementation, *MemoryDB keeps everything in process.
+// Every call has a ...Context variant honouring cancellation; the plain one uses context.Background().
+type Repository interface {
+	CreateStock(uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error)
+	CreateStockContext(ctx context.Context, uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error)
+	RemoveStock(uid string) error
+	RemoveStockContext(ctx context.Context, uid string) error
+	CloseStock(uid, reason string, price float32) (*ClosedAlert, error)
+	CloseStockContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error)
+	GetStock(uid string) (*Stock, error)
+	GetStockContext(ctx context.Context, uid string) (*Stock, error)
+	StockPOIHit(uid string) error
+	StockPOIHitContext(ctx context.Context, uid string) error
+	StockTransition(uid string, to State) error
+	StockTransitionContext(ctx context.Context, uid string, to State) error
+	StockSetNewHigh(uid string, price float32) error
+	StockSetNewHighContext(ctx context.Context, uid string, price float32) error
+	StockUpdateHigh(uid string, price float32) (bool, error)
+	StockUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error)
+	StockSetNewAvg(uid string, price float32) error
+	StockSetNewAvgContext(ctx context.Context, uid string, price float32) error
+
+	CreateShort(uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error)
+	CreateShortContext(ctx context.Context, uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error)
+	RemoveShort(uid string) error
+	RemoveShortContext(ctx context.Context, uid string) error
+	CloseShort(uid, reason string, price float32) (*ClosedAlert, error)
+	CloseShortContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error)
+	GetShort(uid string) (*Short, error)
+	GetShortContext(ctx context.Context, uid string) (*Short, error)
+	ShortPOIHit(uid string) error
+	ShortPOIHitContext(ctx context.Context, uid string) error
+	ShortTransition(uid string, to State) error
+	ShortTransitionContext(ctx context.Context, uid string, to State) error
+	ShortSetNewHigh(uid string, price float32) error
+	ShortSetNewHighContext(ctx context.Context, uid string, price float32) error
+	ShortUpdateLow(uid string, price float32) (bool, error)
+	ShortUpdateLowContext(ctx context.Context, uid string, price float32) (bool, error)
+	ShortSetNewAvg(uid string, price float32) error
+	ShortSetNewAvgContext(ctx context.Context, uid string, price float32) error
+
+	CreateCrypto(uid, coin, author string, spt, ept, poi, stop, tstop float32, alertType int, starting float32) (chan bool, bool, error)
+	CreateCryptoContext(ctx context.Context, uid, coin, author string, spt, ept, poi, stop, tstop float32, alertType int, starting float32) (chan bool, bool, error)
+	RemoveCrypto(uid string) error
+	RemoveCryptoContext(ctx context.Context, uid string) error
+	CloseCrypto(uid, reason string, price float32) (*ClosedAlert, error)
+	CloseCryptoContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error)
+	GetCrypto(uid string) (*Crypto, error)
+	GetCryptoContext(ctx context.Context, uid string) (*Crypto, error)
+	CryptoPOIHit(uid string) error
+	CryptoPOIHitContext(ctx context.Context, uid string) error
+	CryptoTransition(uid string, to State) error
+	CryptoTransitionContext(ctx context.Context, uid string, to State) error
+	CryptoSetNewHigh(uid string, price float32) error
+	CryptoSetNewHighContext(ctx context.Context, uid string, price float32) error
+	CryptoUpdateHigh(uid string, price float32) (bool, error)
+	CryptoUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error)
+	CryptoSetNewAvg(uid string, price float32) error
+	CryptoSetNewAvgContext(ctx context.Context, uid string, price float32) error
+
+	CreateOption(uid, oID, author string, alertType int, ticker, contractType, day, month, year string, price, starting, pt, poi, stop, tstop, underStart float32) (chan bool, string, bool, error)
+	CreateOptionContext(ctx context.Context, uid, oID, author string, alertType int, ticker, contractType, day, month, year string, price, starting, pt, poi, stop, tstop, underStart float32) (chan bool, string, bool, error)
+	RemoveOptionByCode(uid string) error
+	RemoveOptionByCodeContext(ctx context.Context, uid string) error
+	CloseOption(uid, reason string, price float32) (*ClosedAlert, error)
+	CloseOptionContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error)
+	SwitchOptionsTypeByCode(uid string) (string, error)
+	SwitchOptionsTypeByCodeContext(ctx context.Context, uid string) (string, error)
+	GetOption(uid string) (*Option, error)
+	GetOptionContext(ctx context.Context, uid string) (*Option, error)
+	OptionPOIHit(uid string) error
+	OptionPOIHitContext(ctx context.Context, uid string) error
+	OptionTransition(uid string, to State) error
+	OptionTransitionContext(ctx context.Context, uid string, to State) error
+	OptionSetNewHigh(uid string, price float32) error
+	OptionSetNewHighContext(ctx context.Context, uid string, price float32) error
+	OptionUpdateHigh(uid string, price float32) (bool, error)
+	OptionUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error)
+	OptionSetNewAvg(uid string, price float32) error
+	OptionSetNewAvgContext(ctx context.Context, uid string, price float32) error
+
+	AddTarget(assetType, uid, series string, price float32) (*Target, error)
+	AddTargetContext(ctx context.Context, assetType, uid, series string, price float32) (*Target, error)
+	RemoveTarget(targetID int64) error
+	RemoveTargetContext(ctx context.Context, targetID int64) error
+	HitTarget(targetID int64, price float32) (bool, error)
+	HitTargetContext(ctx context.Context, targetID int64, price float32) (bool, error)
+	GetTargets(assetType, uid string) ([]*Target, error)
+	GetTargetsContext(ctx context.Context, assetType, uid string) ([]*Target, error)
+	GetClosedTargets(closedID int64) ([]*Target, error)
+	GetClosedTargetsContext(ctx context.Context, closedID int64) ([]*Target, error)
+
+	AddFill(assetType, uid, kind string, price, size float32) (*Position, error)
+	AddFillContext(ctx context.Context, assetType, uid, kind string, price, size float32) (*Position, error)
+	GetFills(assetType, uid string) ([]*Fill, error)
+	GetFillsContext(ctx context.Context, assetType, uid string) ([]*Fill, error)
+	GetPosition(assetType, uid string) (*Position, error)
+	GetPositionContext(ctx context.Context, assetType, uid string) (*Position, error)
+	GetClosedFills(closedID int64) ([]*Fill, error)
+	GetClosedFillsContext(ctx context.Context, closedID int64) ([]*Fill, error)
+
+	InitialiseServer(guildID, permID, eod string) error
+	InitialiseServerContext(ctx context.Context, guildID, permID, eod string) error
+	GetServerPerm(guildID string) (string, error)
+	GetServerPermContext(ctx context.Context, guildID string) (string, error)
+	GetEOD(guildID string) (string, error)
+	GetEODContext(ctx context.Context, guildID string) (string, error)
+	CreateAlerter(guild, channelID, userID, roleID, permID, eod string) error
+	CreateAlerterContext(ctx context.Context, guild, channelID, userID, roleID, permID, eod string) error
+	RemoveAlerter(guild, userID string) error
+	RemoveAlerterContext(ctx context.Context, guild, userID string) error
+	GetAlerter(guild, userID string) (*Channel, error)
+	GetAlerterContext(ctx context.Context, guild, userID string) (*Channel, error)
+	GetAllAlerters(guild string) ([]*Channel, error)
+	GetAllAlertersContext(ctx context.Context, guild string) ([]*Channel, error)
+
+	RmAll() (*RemovalReport, error)
+	RmAllContext(ctx context.Context) (*RemovalReport, error)
+	RmAllCaller(caller string) (*RemovalReport, error)
+	RmAllCallerContext(ctx context.Context, caller string) (*RemovalReport, error)
+	SweepExpired(now time.Time, prices PriceFunc) (*RemovalReport, error)
+	SweepExpiredContext(ctx context.Context, now time.Time, prices PriceFunc) (*RemovalReport, error)
+	GetAll() ([]*Stock, []*Short, []*Crypto, []*Option, error)
+	GetAllContext(ctx context.Context) ([]*Stock, []*Short, []*Crypto, []*Option, error)
+	GetAllCaller(caller string) ([]*Stock, []*Short, []*Crypto, []*Option, error)
+	GetAllCallerContext(ctx context.Context, caller string) ([]*Stock, []*Short, []*Crypto, []*Option, error)
+	RefreshFromDB() ([]*Stock, []*Short, []*Crypto, []*Option, error)
+	RefreshFromDBContext(ctx context.Context) ([]*Stock, []*Short, []*Crypto, []*Option, error)
+
+	GetClosedAlerts(since time.Time) ([]*ClosedAlert, error)
+	GetClosedAlertsContext(ctx context.Context, since time.Time) ([]*ClosedAlert, error)
+	GetClosedAlertsCaller(caller string, since time.Time) ([]*ClosedAlert, error)
+	GetClosedAlertsCallerContext(ctx context.Context, caller string, since time.Time) ([]*ClosedAlert, error)
+
+	GetAuditLog(since time.Time) ([]*AuditEntry, error)
+	GetAuditLogContext(ctx context.Context, since time.Time) ([]*AuditEntry, error)
+	GetAuditLogAlert(uid string) ([]*AuditEntry, error)
+	GetAuditLogAlertContext(ctx context.Context, uid string) ([]*AuditEntry, error)
+	GetAuditLogCaller(caller string) ([]*AuditEntry, error)
+	GetAuditLogCallerContext(ctx context.Context, caller string) ([]*AuditEntry, error)
+	GetAuditLogActor(actor string) ([]*AuditEntry, error)
+	GetAuditLogActorContext(ctx context.Context, actor string) ([]*AuditEntry, error)
+
+	Clock() clock.Clock
+	IsTradingHours() bool
+	Registry() *AlertRegistry
+	GetExitChan(index string) chan bool
+	GetExitChanExists(index string) (bool, chan bool)
+	SetAndReturnNewExitChan(index string, exitChan chan bool) chan bool
+}
+
+var (
+	_ Repository = (*DB)(nil)
+	_ Repository = (*MemoryDB)(nil)
+)
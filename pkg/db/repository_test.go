@@ -0,0 +1,119 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+)
+
+// testDSN is the Postgres the suites also run against when set, e.g.
+// HARPE_TEST_DSN=postgres://postgres:@localhost:5432/harpe_test?sslmode=disable go test ./pkg/db/...
+// It is migrated up first. Rows are never cleaned up, so point it at a throwaway database.
+const testDSN = "HARPE_TEST_DSN"
+
+// start is a Wednesday, mid-session in New York, well clear of any holiday.
+var start = time.Date(2026, 10, 14, 11, 0, 0, 0, calendar.Location())
+
+// backend is one Repository implementation under test. Every guild it hands out shares one store, the way every DB
+// from one Client shares a pool, and tells the time with clock.
+type backend struct {
+	name  string
+	clock *clock.Fake
+
+	prefix string
+	guild  func(guildID string) Repository
+}
+
+// id makes name unique to this run, since Postgres keeps alert and guild IDs from earlier ones.
+func (b *backend) id(name string) string {
+	return b.prefix + name
+}
+
+// repo returns the Repository for guild name.
+func (b *backend) repo(name string) Repository {
+	return b.guild(b.id(name))
+}
+
+// eachBackend runs fn against a fresh MemoryDB and, with HARPE_TEST_DSN set, against Postgres.
+func eachBackend(t *testing.T, fn func(t *testing.T, b *backend)) {
+	t.Run("memory", func(t *testing.T) {
+		fake := clock.NewFake(start)
+		root := NewMemoryDB("").WithClock(fake)
+		fn(t, &backend{
+			name:  "memory",
+			clock: fake,
+			guild: func(guildID string) Repository {
+				return root.WithGuild(guildID)
+			},
+		})
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(testDSN)
+		if dsn == "" {
+			t.Skip(testDSN + " is not set")
+		}
+
+		fake := clock.NewFake(start)
+		client, err := Connect(context.Background(), Options{DSN: dsn, Clock: fake})
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		fn(t, &backend{
+			name:   "postgres",
+			clock:  fake,
+			prefix: strconv.FormatInt(time.Now().UnixNano(), 36) + "-",
+			guild: func(guildID string) Repository {
+				return client.Guild(guildID)
+			},
+		})
+	})
+}
+
+// wantErr fails t unless err wraps target.
+func wantErr(t *testing.T, what string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("%v: got error %v, want %v", what, err, target)
+	}
+}
+
+// must fails t now if err isn't nil.
+func must(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%v: %v", what, err)
+	}
+}
+
+// signalled reports whether exit fires within a second.
+func signalled(exit chan bool) bool {
+	select {
+	case <-exit:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
@@ -2,15 +2,20 @@ package db
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 )
 
 func (d *DB) InitialiseServer(guildID, permID, eod string) error {
-	contxt := context.Background()
+	return d.InitialiseServerContext(context.Background(), guildID, permID, eod)
+}
+
+func (d *DB) InitialiseServerContext(ctx context.Context, guildID, permID, eod string) error {
+	if guildID != d.Guild {
+		return wrongGuild(guildID)
+	}
 
-	res, err := d.GetAllAlerters(guildID)
+	res, err := d.GetAllAlertersContext(ctx, guildID)
 
 	if err != nil {
 		a := &Channel{
@@ -23,7 +28,7 @@ func (d *DB) InitialiseServer(guildID, permID, eod string) error {
 			PermissionsID:      permID,
 		}
 
-		_, err := d.db.NewInsert().Model(a).On("CONFLICT (user_guild_composite) DO UPDATE").Exec(contxt)
+		_, err := d.db.NewInsert().Model(a).On("CONFLICT (user_guild_composite) DO UPDATE").Exec(ctx)
 
 		if err != nil {
 			log.Println(fmt.Sprintf("Unable to create alerter %v : %v", a, err.Error()))
@@ -36,7 +41,7 @@ func (d *DB) InitialiseServer(guildID, permID, eod string) error {
 		if v.PermissionsID != permID {
 			// recreate all alerters with the correct role; assume theyre all dirty
 			for _, v2 := range res {
-				err = d.CreateAlerter(guildID, v2.ChannelID, v2.UserID, v2.RoleID, permID, eod)
+				err = d.CreateAlerterContext(ctx, guildID, v2.ChannelID, v2.UserID, v2.RoleID, permID, eod)
 				if err != nil {
 					log.Println(err)
 				}
@@ -50,7 +55,11 @@ func (d *DB) InitialiseServer(guildID, permID, eod string) error {
 }
 
 func (d *DB) GetServerPerm(guildID string) (string, error) {
-	res, err := d.GetAlerter(guildID, "0")
+	return d.GetServerPermContext(context.Background(), guildID)
+}
+
+func (d *DB) GetServerPermContext(ctx context.Context, guildID string) (string, error) {
+	res, err := d.GetAlerterContext(ctx, guildID, "0")
 
 	if err != nil {
 		return "", err
@@ -60,7 +69,11 @@ func (d *DB) GetServerPerm(guildID string) (string, error) {
 }
 
 func (d *DB) GetEOD(guildID string) (string, error) {
-	res, err := d.GetAlerter(guildID, "0")
+	return d.GetEODContext(context.Background(), guildID)
+}
+
+func (d *DB) GetEODContext(ctx context.Context, guildID string) (string, error) {
+	res, err := d.GetAlerterContext(ctx, guildID, "0")
 
 	if err != nil {
 		return "", err
@@ -70,10 +83,12 @@ func (d *DB) GetEOD(guildID string) (string, error) {
 }
 
 func (d *DB) CreateAlerter(guild, channelID, userID, roleID, permID, eod string) error {
-	contxt := context.Background()
+	return d.CreateAlerterContext(context.Background(), guild, channelID, userID, roleID, permID, eod)
+}
 
+func (d *DB) CreateAlerterContext(ctx context.Context, guild, channelID, userID, roleID, permID, eod string) error {
 	if guild != d.Guild {
-		return errors.New("Incorrect Guild!")
+		return wrongGuild(guild)
 	}
 	comp := userID + guild
 	a := &Channel{
@@ -86,7 +101,7 @@ func (d *DB) CreateAlerter(guild, channelID, userID, roleID, permID, eod string)
 		EOD:                eod,
 	}
 
-	_, err := d.db.NewInsert().Model(a).On("CONFLICT (user_guild_composite) DO UPDATE").Exec(contxt)
+	_, err := d.db.NewInsert().Model(a).On("CONFLICT (user_guild_composite) DO UPDATE").Exec(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to create alerter %v : %v", a, err.Error()))
@@ -97,10 +112,12 @@ func (d *DB) CreateAlerter(guild, channelID, userID, roleID, permID, eod string)
 }
 
 func (d *DB) RemoveAlerter(guild, userID string) error {
-	contxt := context.Background()
+	return d.RemoveAlerterContext(context.Background(), guild, userID)
+}
 
+func (d *DB) RemoveAlerterContext(ctx context.Context, guild, userID string) error {
 	if guild != d.Guild {
-		return errors.New("Incorrect Guild!")
+		return wrongGuild(guild)
 	}
 	comp := userID + guild
 	a := &Channel{
@@ -109,48 +126,52 @@ func (d *DB) RemoveAlerter(guild, userID string) error {
 		GuildID:            guild,
 	}
 
-	res, err := d.db.NewDelete().Model(a).Where("user_guild_composite = ?", comp).Exec(contxt)
-	rowsAffected, _ := res.RowsAffected()
+	res, err := d.db.NewDelete().Model(a).Where("user_guild_composite = ?", comp).Exec(ctx)
 
-	if rowsAffected == 0 {
-		err = errors.New(fmt.Sprintf("Unable to remove Alerter %v : NOT FOUND", userID))
-		return err
-	}
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to delete alerter %v : %v", userID, err.Error()))
 		return err
 	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("Unable to remove Alerter %v : %w", userID, ErrNotFound)
+	}
 	return nil
 
 }
 
 func (d *DB) GetAlerter(guild, userID string) (*Channel, error) {
+	return d.GetAlerterContext(context.Background(), guild, userID)
+}
+
+func (d *DB) GetAlerterContext(ctx context.Context, guild, userID string) (*Channel, error) {
 	if guild != d.Guild {
-		return nil, errors.New("Incorrect Guild!")
+		return nil, wrongGuild(guild)
 	}
-	contxt := context.Background()
 	comp := userID + guild
 	a := &Channel{
 		UserGuildComposite: comp,
 		UserID:             userID,
 		GuildID:            guild,
 	}
-	err := d.db.NewSelect().Model(a).Where("user_guild_composite = ?", comp).Scan(contxt, a)
+	err := d.db.NewSelect().Model(a).Where("user_guild_composite = ?", comp).Scan(ctx, a)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get alerter %v : %v", userID, err.Error()))
-		return nil, err
+		return nil, notFound(err, "alerter", userID)
 	}
 	return a, nil
 }
 
 func (d *DB) GetAllAlerters(guild string) ([]*Channel, error) {
+	return d.GetAllAlertersContext(context.Background(), guild)
+}
+
+func (d *DB) GetAllAlertersContext(ctx context.Context, guild string) ([]*Channel, error) {
 	if guild != d.Guild {
-		return nil, errors.New("Incorrect Guild!")
+		return nil, wrongGuild(guild)
 	}
 	allAlerters := make([]*Channel, 0)
-	contxt := context.Background()
-	err := d.db.NewSelect().Model(&allAlerters).Where("guild_id = ?", guild).Scan(contxt, &allAlerters)
+	err := d.db.NewSelect().Model(&allAlerters).Where("guild_id = ?", guild).Scan(ctx, &allAlerters)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get alerters %v : %v", guild, err.Error()))
@@ -158,7 +179,7 @@ func (d *DB) GetAllAlerters(guild string) ([]*Channel, error) {
 	}
 
 	if len(allAlerters) == 0 {
-		return nil, fmt.Errorf("no alerters found for %v", guild)
+		return nil, fmt.Errorf("no alerters found for %v : %w", guild, ErrNotFound)
 	}
 	return allAlerters, nil
 }
@@ -17,24 +17,26 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"sync"
 	"time"
+
+	"github.com/uptrace/bun"
 )
 
 func (d *DB) CreateShort(uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
+	return d.CreateShortContext(context.Background(), uid, stock, author, alertType, spt, ept, poi, stop, tstop, expiry, starting)
+}
 
-	chanMap.LoadOrStore(d.Guild, &sync.Map{})
+func (d *DB) CreateShortContext(ctx context.Context, uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
 	exists, exitChan := d.GetExitChanExists(uid)
 
 	if exists {
 		return exitChan, exists, nil
 	}
 
-	contxt := context.Background()
-
 	s := &Short{
 		ShortAlertID:      uid,
 		ShortGuildID:      d.Guild,
@@ -48,15 +50,20 @@ func (d *DB) CreateShort(uid, stock, author string, alertType int, spt, ept, poi
 		ShortTrailingStop: tstop,
 		ShortLastLow:      starting,
 		AlertType:         alertType,
-		ShortCallTime:     time.Now(),
+		ShortCallTime:     d.now(),
 		ShortPOIHit:       false,
 		ShortLowest:       starting,
 		Caller:            author,
+		ShortState:        initialState(poi, false),
 	}
+	s.ShortStateTimes = initialTimes(s.ShortState, s.ShortCallTime)
 
-	_, err := d.db.NewInsert().Model(s).On("CONFLICT (short_alert_id) DO UPDATE").Exec(contxt)
+	targets := seedTargets(d.Guild, uid, AssetShort, TargetPrice, d.now(), spt, ept)
+	fill := openingFill(d.Guild, uid, AssetShort, author, s.ShortStarting, s.ShortCallTime)
+	err := d.createAudited(ctx, uid, s, shortTable, targets, fill, s.auditEntry(ctx, AuditCreate))
 
 	if err != nil {
+		d.reg.Cancel(d.Guild, uid)
 		log.Println(fmt.Sprintf("Unable to create short %v : %v", stock, err.Error()))
 		return nil, false, err
 	}
@@ -65,65 +72,97 @@ func (d *DB) CreateShort(uid, stock, author string, alertType int, spt, ept, poi
 }
 
 func (d *DB) RemoveShort(uid string) error {
+	return d.RemoveShortContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
+func (d *DB) RemoveShortContext(ctx context.Context, uid string) error {
+	_, err := d.CloseShortContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
 
-	s := &Short{
-		ShortGuildID: d.Guild,
-		ShortAlertID: uid,
-	}
-	res, err := d.db.NewDelete().Model(s).Where("short_alert_id = ?", uid).Exec(contxt)
-	rowsAffected, _ := res.RowsAffected()
+// CloseShort removes the short and archives it with reason. A zero price archives it at its lowest price.
+func (d *DB) CloseShort(uid, reason string, price float32) (*ClosedAlert, error) {
+	return d.CloseShortContext(context.Background(), uid, reason, price)
+}
+
+func (d *DB) CloseShortContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	s := &Short{}
+	var closed *ClosedAlert
+
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().Model(s).Where("short_alert_id = ?", uid).Where("short_guild_id = ?", d.Guild).Returning("*").Exec(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("Unable to remove short %v : %w", uid, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("Unable to remove short %v : %w", uid, ErrNotFound)
+		}
+
+		closed = s.closed(reason, price, d.now())
+		_, err = tx.NewInsert().Model(closed).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err = archiveDetails(ctx, tx, d.Guild, closed); err != nil {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), s.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	})
 
-	if rowsAffected == 0 {
-		err = errors.New(fmt.Sprintf("Unable to remove Short %v : NOT FOUND", uid))
-		return err
-	}
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to delete short %v : %v", uid, err.Error()))
-		return err
+		log.Println(fmt.Sprintf("Unable to close short %v : %v", uid, err.Error()))
+		return nil, err
 	}
-	clearFromSyncMap(chanMap, d.Guild, uid)
-	return nil
+	d.reg.Cancel(d.Guild, uid)
+	return closed, nil
 }
 
 func (d *DB) GetShort(uid string) (*Short, error) {
+	return d.GetShortContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
+func (d *DB) GetShortContext(ctx context.Context, uid string) (*Short, error) {
 	s := &Short{
 		ShortGuildID: d.Guild,
 		ShortAlertID: uid,
 	}
-	err := d.db.NewSelect().Model(s).Where("short_alert_id = ?", uid).Scan(contxt)
+	err := d.db.NewSelect().Model(s).Where("short_alert_id = ?", uid).Where("short_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, err.Error()))
-		return nil, err
+		return nil, notFound(err, "short", uid)
 	}
-	gMap, ok := chanMap.Load(d.Guild)
-	if ok {
-		gMapCast := gMap.(*sync.Map)
-		_, ok := gMapCast.Load(uid)
-		if !ok {
-			log.Println(fmt.Sprintf("Unable to get alert channel for short %v. Please try recreating this alert, or calling !refresh then running this command again.", uid))
-			return nil, err
-		}
+	if !d.reg.monitored(d.Guild, uid) {
+		err = noMonitor("short", uid)
+		log.Println(err.Error())
+		return nil, err
 	}
 	return s, nil
 }
 
 func (d *DB) ShortPOIHit(uid string) error {
-	contxt := context.Background()
-
-	s, err := d.GetShort(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, err.Error()))
-		return err
-	}
-
-	s.ShortPOIHit = true
+	return d.ShortPOIHitContext(context.Background(), uid)
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (short_alert_id) DO UPDATE").Exec(contxt)
+func (d *DB) ShortPOIHitContext(ctx context.Context, uid string) error {
+	s := &Short{}
+	err := d.patch(ctx, shortTable, uid, s, func() ([]string, []*AuditEntry, error) {
+		entries := []*AuditEntry{s.auditEntry(ctx, AuditPOIHit).change("poi_hit", s.ShortPOIHit, true)}
+		s.ShortPOIHit = true
+
+		if s.GetState() == StatePending {
+			entry, err := s.moveTo(ctx, StateActive, d.now())
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return []string{"short_poi_hit", shortTable.state, shortTable.stateTimes}, entries, nil
+	})
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update short %v : %v", uid, err.Error()))
@@ -133,18 +172,31 @@ func (d *DB) ShortPOIHit(uid string) error {
 	return nil
 }
 
-func (d *DB) ShortSetNewHigh(uid string, price float32) error {
-	contxt := context.Background()
+// ShortTransition moves the short to state to, refusing any move its lifecycle does not allow.
+// Moving it to StateClosed closes it, archiving it with the reason its current state implies.
+func (d *DB) ShortTransition(uid string, to State) error {
+	return d.ShortTransitionContext(context.Background(), uid, to)
+}
 
-	s, err := d.GetShort(uid)
+func (d *DB) ShortTransitionContext(ctx context.Context, uid string, to State) error {
+	s, err := d.GetShortContext(ctx, uid)
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, err.Error()))
 		return err
 	}
 
-	s.ShortLowest = price
+	if to == StateClosed {
+		_, err = d.CloseShortContext(ctx, uid, closeReason(s.GetState()), 0)
+		return err
+	}
+
+	stored := s.ShortState
+	entry, err := s.moveTo(ctx, to, d.now())
+	if err != nil {
+		return err
+	}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (short_alert_id) DO UPDATE").Exec(contxt)
+	err = d.setState(ctx, shortTable, uid, stored, s.ShortState, s.ShortStateTimes, entry)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update short %v : %v", uid, err.Error()))
@@ -154,27 +206,94 @@ func (d *DB) ShortSetNewHigh(uid string, price float32) error {
 	return nil
 }
 
+func (d *DB) ShortSetNewHigh(uid string, price float32) error {
+	return d.ShortSetNewHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) ShortSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.ShortUpdateLowContext(ctx, uid, price)
+	return err
+}
+
+// ShortUpdateLow records price as the new low only if it is below the current one, in a single statement,
+// and reports whether it did.
+func (d *DB) ShortUpdateLow(uid string, price float32) (bool, error) {
+	return d.ShortUpdateLowContext(context.Background(), uid, price)
+}
+
+func (d *DB) ShortUpdateLowContext(ctx context.Context, uid string, price float32) (bool, error) {
+	return d.move(ctx, shortLow, uid, price)
+}
+
+// ShortSetNewAvg declares price the new average cost, as a FillAvg in the ledger rather than by overwriting the entry.
 func (d *DB) ShortSetNewAvg(uid string, price float32) error {
-	contxt := context.Background()
+	return d.ShortSetNewAvgContext(context.Background(), uid, price)
+}
 
-	s, err := d.GetShort(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get short %v : %v", uid, err.Error()))
-		return err
+func (d *DB) ShortSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.AddFillContext(ctx, AssetShort, uid, FillAvg, price, 0)
+	return err
+}
+
+func (s Short) GetPctGain(highest float32) float32 {
+	return ((highest - s.ShortStarting) / s.ShortStarting) * 100
+}
+
+func (s Short) closed(reason string, price float32, now time.Time) *ClosedAlert {
+	if price == PriceUnknown {
+		return s.closed(reason, 0, now).unpriced()
+	}
+	if price == 0 {
+		price = s.ShortLowest
 	}
 
-	s.ShortStarting = price
+	return &ClosedAlert{
+		AlertID:     s.ShortAlertID,
+		GuildID:     s.ShortGuildID,
+		AssetType:   AssetShort,
+		Ticker:      s.ShortTicker,
+		Contract:    "",
+		AlertType:   s.AlertType,
+		Caller:      s.Caller,
+		Starting:    s.ShortStarting,
+		Highest:     s.ShortLowest,
+		CallTime:    s.ShortCallTime,
+		CloseReason: reason,
+		ClosePrice:  price,
+		CloseTime:   now,
+		PctGain:     s.GetPctGain(price),
+		States:      closedTimes(s.GetStateTimes(), now),
+	}
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (short_alert_id) DO UPDATE").Exec(contxt)
+func (s *Short) auditEntry(ctx context.Context, action string) *AuditEntry {
+	return newAuditEntry(ctx, action, s.ShortGuildID, s.ShortAlertID, AssetShort, s.Caller, s)
+}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to update short %v : %v", uid, err.Error()))
-		return err
+// GetState returns the short's lifecycle state, working it out for alerts stored before states were recorded.
+func (s Short) GetState() State {
+	if s.ShortState == "" {
+		return initialState(s.ShortPoI, s.ShortPOIHit)
 	}
+	return s.ShortState
+}
 
-	return nil
+// GetStateTimes returns when the short entered each state it has been in.
+func (s Short) GetStateTimes() StateTimes {
+	if len(s.ShortStateTimes) == 0 {
+		return initialTimes(s.GetState(), s.ShortCallTime)
+	}
+	return s.ShortStateTimes
 }
 
-func (s Short) GetPctGain(highest float32) float32 {
-	return ((highest - s.ShortStarting) / s.ShortStarting) * 100
+func (s *Short) moveTo(ctx context.Context, to State, at time.Time) (*AuditEntry, error) {
+	from := s.GetState()
+	entry := s.auditEntry(ctx, AuditTransition).change("state", from, to)
+
+	times := s.GetStateTimes()
+	if err := advance(&s.ShortState, &times, from, to, at); err != nil {
+		return nil, err
+	}
+	s.ShortStateTimes = times
+	return entry, nil
 }
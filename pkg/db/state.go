@@ -0,0 +1,142 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// State is where an alert is in its lifecycle. Every asset type shares the same states and transitions.
+type State string
+
+const (
+	StatePending         State = "pending" // waiting for its PoI
+	StateActive          State = "active"
+	StateTargetHit       State = "target_hit"
+	StateStopped         State = "stopped"
+	StateTrailingStopped State = "trailing_stopped"
+	StateExpired         State = "expired"
+	StateClosed          State = "closed"
+)
+
+// StateTimes records when an alert last entered each state it has been in.
+type StateTimes map[State]time.Time
+
+var transitions = map[State][]State{
+	StatePending:         {StateActive, StateStopped, StateExpired, StateClosed},
+	StateActive:          {StateTargetHit, StateStopped, StateTrailingStopped, StateExpired, StateClosed},
+	StateTargetHit:       {StateTargetHit, StateStopped, StateTrailingStopped, StateExpired, StateClosed},
+	StateStopped:         {StateClosed},
+	StateTrailingStopped: {StateClosed},
+	StateExpired:         {StateClosed},
+}
+
+// CanTransition reports whether the lifecycle allows moving from s to to.
+// Hitting a further target is the only move back into the same state.
+func (s State) CanTransition(to State) bool {
+	for _, v := range transitions[s] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Done reports whether an alert in this state no longer needs monitoring.
+func (s State) Done() bool {
+	switch s {
+	case StateStopped, StateTrailingStopped, StateExpired, StateClosed:
+		return true
+	}
+	return false
+}
+
+// initialState is where a new alert starts: pending until its PoI is hit, if it has one.
+func initialState(poi float32, poiHit bool) State {
+	if poi != 0 && !poiHit {
+		return StatePending
+	}
+	return StateActive
+}
+
+// initialTimes is the history of an alert stored before states were recorded.
+func initialTimes(state State, callTime time.Time) StateTimes {
+	return StateTimes{state: callTime}
+}
+
+// advance moves an alert from one state to another, stamping when it did.
+func advance(state *State, times *StateTimes, from, to State, at time.Time) error {
+	if !from.CanTransition(to) {
+		return fmt.Errorf("unable to move from %v to %v : %w", from, to, ErrIllegalTransition)
+	}
+
+	t := make(StateTimes, len(*times)+1)
+	for k, v := range *times {
+		t[k] = v
+	}
+	t[to] = at
+
+	*state = to
+	*times = t
+	return nil
+}
+
+// closeReason is the reason an alert in state is archived with when it is moved to StateClosed.
+func closeReason(state State) string {
+	switch state {
+	case StateTargetHit:
+		return CloseReasonTarget
+	case StateStopped, StateTrailingStopped:
+		return CloseReasonStopped
+	case StateExpired:
+		return CloseReasonExpired
+	}
+	return CloseReasonRemoved
+}
+
+// closedTimes is the history archived with a closed alert.
+func closedTimes(times StateTimes, at time.Time) StateTimes {
+	t := make(StateTimes, len(times)+1)
+	for k, v := range times {
+		t[k] = v
+	}
+	t[StateClosed] = at
+	return t
+}
+
+// setState saves a transition, provided nobody else moved the alert out of from, its stored state, in the meantime.
+func (d *DB) setState(ctx context.Context, t alertTable, uid string, from, to State, times StateTimes, entries ...*AuditEntry) error {
+	return d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().Model(t.model).
+			Set("? = ?", bun.Ident(t.state), to).
+			Set("? = ?", bun.Ident(t.stateTimes), times).
+			Where("? = ?", bun.Ident(t.pk), uid).
+			Where("? = ?", bun.Ident(t.guild), d.Guild).
+			Where("COALESCE(?, '') = ?", bun.Ident(t.state), from).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("%v %v is no longer %v : %w", t.assetType, uid, from, ErrIllegalTransition)
+		}
+		return writeAudit(ctx, tx, d.now(), entries...)
+	})
+}
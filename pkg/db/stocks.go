@@ -17,23 +17,26 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"sync"
 	"time"
+
+	"github.com/uptrace/bun"
 )
 
 func (d *DB) CreateStock(uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
+	return d.CreateStockContext(context.Background(), uid, stock, author, alertType, spt, ept, poi, stop, tstop, expiry, starting)
+}
 
-	chanMap.LoadOrStore(d.Guild, &sync.Map{})
+func (d *DB) CreateStockContext(ctx context.Context, uid, stock, author string, alertType int, spt, ept, poi, stop, tstop float32, expiry int64, starting float32) (chan bool, bool, error) {
 	exists, exitChan := d.GetExitChanExists(uid)
 
 	if exists {
 		return exitChan, exists, nil
 	}
 
-	contxt := context.Background()
 	s := &Stock{
 		StockAlertID:      uid,
 		StockGuildID:      d.Guild,
@@ -47,16 +50,21 @@ func (d *DB) CreateStock(uid, stock, author string, alertType int, spt, ept, poi
 		StockPoI:          poi,
 		StockTrailingStop: tstop,
 		AlertType:         alertType,
-		StockCallTime:     time.Now(),
+		StockCallTime:     d.now(),
 		StockPOIHit:       false,
 		StockHighest:      starting,
 		Caller:            author,
+		StockState:        initialState(poi, false),
 	}
+	s.StockStateTimes = initialTimes(s.StockState, s.StockCallTime)
 
-	_, err := d.db.NewInsert().Model(s).On("CONFLICT (stock_alert_id) DO UPDATE").Exec(contxt)
+	targets := seedTargets(d.Guild, uid, AssetStock, TargetPrice, d.now(), spt, ept)
+	fill := openingFill(d.Guild, uid, AssetStock, author, s.StockStarting, s.StockCallTime)
+	err := d.createAudited(ctx, uid, s, stockTable, targets, fill, s.auditEntry(ctx, AuditCreate))
 
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to create Crypto %v : %v", uid, err.Error()))
+		d.reg.Cancel(d.Guild, uid)
+		log.Println(fmt.Sprintf("Unable to create Stock %v : %v", uid, err.Error()))
 		return nil, false, err
 	}
 
@@ -64,61 +72,97 @@ func (d *DB) CreateStock(uid, stock, author string, alertType int, spt, ept, poi
 }
 
 func (d *DB) RemoveStock(uid string) error {
+	return d.RemoveStockContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
+func (d *DB) RemoveStockContext(ctx context.Context, uid string) error {
+	_, err := d.CloseStockContext(ctx, uid, CloseReasonRemoved, 0)
+	return err
+}
 
-	s := &Stock{
-		StockGuildID: d.Guild,
-		StockAlertID: uid,
-	}
-	_, err := d.db.NewDelete().Model(s).Where("stock_alert_id = ?", uid).Exec(contxt)
+// CloseStock removes the stock and archives it with reason. A zero price archives it at its highest price.
+func (d *DB) CloseStock(uid, reason string, price float32) (*ClosedAlert, error) {
+	return d.CloseStockContext(context.Background(), uid, reason, price)
+}
+
+func (d *DB) CloseStockContext(ctx context.Context, uid, reason string, price float32) (*ClosedAlert, error) {
+	s := &Stock{}
+	var closed *ClosedAlert
+
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().Model(s).Where("stock_alert_id = ?", uid).Where("stock_guild_id = ?", d.Guild).Returning("*").Exec(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("Unable to remove Stock %v : %w", uid, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("Unable to remove Stock %v : %w", uid, ErrNotFound)
+		}
+
+		closed = s.closed(reason, price, d.now())
+		_, err = tx.NewInsert().Model(closed).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err = archiveDetails(ctx, tx, d.Guild, closed); err != nil {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), s.auditEntry(ctx, AuditRemove).change("reason", "", reason))
+	})
 
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to delete Stock %v : %v", uid, err.Error()))
-		return err
+		log.Println(fmt.Sprintf("Unable to close Stock %v : %v", uid, err.Error()))
+		return nil, err
 	}
-	clearFromSyncMap(chanMap, d.Guild, uid)
-	return nil
+	d.reg.Cancel(d.Guild, uid)
+	return closed, nil
 }
 
 func (d *DB) GetStock(uid string) (*Stock, error) {
+	return d.GetStockContext(context.Background(), uid)
+}
 
-	contxt := context.Background()
-
+func (d *DB) GetStockContext(ctx context.Context, uid string) (*Stock, error) {
 	s := &Stock{
 		StockGuildID: d.Guild,
 		StockAlertID: uid,
 	}
-	err := d.db.NewSelect().Model(s).Where("stock_alert_id = ?", uid).Scan(contxt)
+	err := d.db.NewSelect().Model(s).Where("stock_alert_id = ?", uid).Where("stock_guild_id = ?", d.Guild).Scan(ctx)
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, err.Error()))
-		return nil, err
+		return nil, notFound(err, "stock", uid)
 	}
-	gMap, ok := chanMap.Load(d.Guild)
-	if ok {
-		gMapCast := gMap.(*sync.Map)
-		_, ok := gMapCast.Load(uid)
-		if !ok {
-			log.Println(fmt.Sprintf("Unable to get alert channel for stock %v. Please try recreating this alert, or calling !refresh then running this command again.", uid))
-			return nil, err
-		}
+	if !d.reg.monitored(d.Guild, uid) {
+		err = noMonitor("stock", uid)
+		log.Println(err.Error())
+		return nil, err
 	}
 	return s, nil
 }
 
 func (d *DB) StockPOIHit(uid string) error {
-	contxt := context.Background()
-
-	s, err := d.GetStock(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get stock %v : %v", uid, err.Error()))
-		return err
-	}
-
-	s.StockPOIHit = true
+	return d.StockPOIHitContext(context.Background(), uid)
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (stock_alert_id) DO UPDATE").Exec(contxt)
+func (d *DB) StockPOIHitContext(ctx context.Context, uid string) error {
+	s := &Stock{}
+	err := d.patch(ctx, stockTable, uid, s, func() ([]string, []*AuditEntry, error) {
+		entries := []*AuditEntry{s.auditEntry(ctx, AuditPOIHit).change("poi_hit", s.StockPOIHit, true)}
+		s.StockPOIHit = true
+
+		if s.GetState() == StatePending {
+			entry, err := s.moveTo(ctx, StateActive, d.now())
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return []string{"stock_poi_hit", stockTable.state, stockTable.stateTimes}, entries, nil
+	})
 
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update stock %v : %v", uid, err.Error()))
@@ -128,30 +172,32 @@ func (d *DB) StockPOIHit(uid string) error {
 	return nil
 }
 
-func (d *DB) StockSetNewHigh(uid string, price float32) error {
-	contxt := context.Background()
+// StockTransition moves the stock to state to, refusing any move its lifecycle does not allow.
+// Moving it to StateClosed closes it, archiving it with the reason its current state implies.
+func (d *DB) StockTransition(uid string, to State) error {
+	return d.StockTransitionContext(context.Background(), uid, to)
+}
 
-	s, err := d.GetStock(uid)
+func (d *DB) StockTransitionContext(ctx context.Context, uid string, to State) error {
+	s, err := d.GetStockContext(ctx, uid)
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, err.Error()))
 		return err
 	}
 
-	s.StockHighest = price
-
-	res, err := d.db.NewInsert().Model(s).On("CONFLICT (stock_alert_id) DO UPDATE").Exec(contxt)
-	rowsAffected, _ := res.RowsAffected()
-
-	if rowsAffected == 0 {
-		err = errors.New(fmt.Sprintf("Unable to remove Stock %v : NOT FOUND", uid))
+	if to == StateClosed {
+		_, err = d.CloseStockContext(ctx, uid, closeReason(s.GetState()), 0)
 		return err
 	}
 
+	stored := s.StockState
+	entry, err := s.moveTo(ctx, to, d.now())
 	if err != nil {
-		log.Println(fmt.Sprintf("Unable to remove Stock %v : %v", uid, err.Error()))
 		return err
 	}
 
+	err = d.setState(ctx, stockTable, uid, stored, s.StockState, s.StockStateTimes, entry)
+
 	if err != nil {
 		log.Println(fmt.Sprintf("Unable to update stock %v : %v", uid, err.Error()))
 		return err
@@ -160,27 +206,94 @@ func (d *DB) StockSetNewHigh(uid string, price float32) error {
 	return nil
 }
 
+func (d *DB) StockSetNewHigh(uid string, price float32) error {
+	return d.StockSetNewHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) StockSetNewHighContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.StockUpdateHighContext(ctx, uid, price)
+	return err
+}
+
+// StockUpdateHigh records price as the new high only if it is above the current one, in a single statement,
+// and reports whether it did.
+func (d *DB) StockUpdateHigh(uid string, price float32) (bool, error) {
+	return d.StockUpdateHighContext(context.Background(), uid, price)
+}
+
+func (d *DB) StockUpdateHighContext(ctx context.Context, uid string, price float32) (bool, error) {
+	return d.move(ctx, stockHigh, uid, price)
+}
+
+// StockSetNewAvg declares price the new average cost, as a FillAvg in the ledger rather than by overwriting the entry.
 func (d *DB) StockSetNewAvg(uid string, price float32) error {
-	contxt := context.Background()
+	return d.StockSetNewAvgContext(context.Background(), uid, price)
+}
 
-	s, err := d.GetStock(uid)
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to get Stock %v : %v", uid, err.Error()))
-		return err
+func (d *DB) StockSetNewAvgContext(ctx context.Context, uid string, price float32) error {
+	_, err := d.AddFillContext(ctx, AssetStock, uid, FillAvg, price, 0)
+	return err
+}
+
+func (s Stock) GetPctGain(highest float32) float32 {
+	return ((highest - s.StockStarting) / s.StockStarting) * 100
+}
+
+func (s Stock) closed(reason string, price float32, now time.Time) *ClosedAlert {
+	if price == PriceUnknown {
+		return s.closed(reason, 0, now).unpriced()
+	}
+	if price == 0 {
+		price = s.StockHighest
 	}
 
-	s.StockStarting = price
+	return &ClosedAlert{
+		AlertID:     s.StockAlertID,
+		GuildID:     s.StockGuildID,
+		AssetType:   AssetStock,
+		Ticker:      s.StockTicker,
+		Contract:    "",
+		AlertType:   s.AlertType,
+		Caller:      s.Caller,
+		Starting:    s.StockStarting,
+		Highest:     s.StockHighest,
+		CallTime:    s.StockCallTime,
+		CloseReason: reason,
+		ClosePrice:  price,
+		CloseTime:   now,
+		PctGain:     s.GetPctGain(price),
+		States:      closedTimes(s.GetStateTimes(), now),
+	}
+}
 
-	_, err = d.db.NewInsert().Model(s).On("CONFLICT (stock_alert_id) DO UPDATE").Exec(contxt)
+func (s *Stock) auditEntry(ctx context.Context, action string) *AuditEntry {
+	return newAuditEntry(ctx, action, s.StockGuildID, s.StockAlertID, AssetStock, s.Caller, s)
+}
 
-	if err != nil {
-		log.Println(fmt.Sprintf("Unable to update stock %v : %v", uid, err.Error()))
-		return err
+// GetState returns the stock's lifecycle state, working it out for alerts stored before states were recorded.
+func (s Stock) GetState() State {
+	if s.StockState == "" {
+		return initialState(s.StockPoI, s.StockPOIHit)
 	}
+	return s.StockState
+}
 
-	return nil
+// GetStateTimes returns when the stock entered each state it has been in.
+func (s Stock) GetStateTimes() StateTimes {
+	if len(s.StockStateTimes) == 0 {
+		return initialTimes(s.GetState(), s.StockCallTime)
+	}
+	return s.StockStateTimes
 }
 
-func (s Stock) GetPctGain(highest float32) float32 {
-	return ((highest - s.StockStarting) / s.StockStarting) * 100
+func (s *Stock) moveTo(ctx context.Context, to State, at time.Time) (*AuditEntry, error) {
+	from := s.GetState()
+	entry := s.auditEntry(ctx, AuditTransition).change("state", from, to)
+
+	times := s.GetStateTimes()
+	if err := advance(&s.StockState, &times, from, to, at); err != nil {
+		return nil, err
+	}
+	s.StockStateTimes = times
+	return entry, nil
 }
@@ -18,6 +18,7 @@ package db
 import (
 	"time"
 
+	"github.com/m1k8/harpe/pkg/clock"
 	"github.com/uptrace/bun"
 )
 
@@ -52,6 +53,8 @@ type Stock struct {
 	Caller            string
 	StockPOIHit       bool
 	StockCallTime     time.Time
+	StockState        State
+	StockStateTimes   StateTimes
 }
 
 type Short struct {
@@ -71,6 +74,8 @@ type Short struct {
 	Caller            string
 	ShortPOIHit       bool
 	ShortCallTime     time.Time
+	ShortState        State
+	ShortStateTimes   StateTimes
 }
 
 type Option struct {
@@ -94,6 +99,8 @@ type Option struct {
 	Caller                   string
 	OptionUnderlyingPOIHit   bool
 	OptionCallTime           time.Time
+	OptionState              State
+	OptionStateTimes         StateTimes
 }
 
 type Crypto struct {
@@ -113,9 +120,77 @@ type Crypto struct {
 	Caller             string
 	CryptoPOIHit       bool
 	CryptoCallTime     time.Time
+	CryptoState        State
+	CryptoStateTimes   StateTimes
+}
+
+const (
+	AssetStock  = "stock"
+	AssetShort  = "short"
+	AssetCrypto = "crypto"
+	AssetOption = "option"
+)
+
+// alertTable names an alert table and the columns every alert table has under its own prefix.
+type alertTable struct {
+	model      interface{}
+	table      string
+	alias      string
+	pk         string
+	guild      string
+	state      string
+	stateTimes string
+	starting   string
+	assetType  string
+}
+
+var (
+	stockTable  = alertTable{(*Stock)(nil), "stocks", "stock", "stock_alert_id", "stock_guild_id", "stock_state", "stock_state_times", "stock_starting", AssetStock}
+	shortTable  = alertTable{(*Short)(nil), "shorts", "short", "short_alert_id", "short_guild_id", "short_state", "short_state_times", "short_starting", AssetShort}
+	cryptoTable = alertTable{(*Crypto)(nil), "cryptos", "crypto", "crypto_alert_id", "crypto_guild_id", "crypto_state", "crypto_state_times", "crypto_starting", AssetCrypto}
+	optionTable = alertTable{(*Option)(nil), "options", "option", "option_alert_id", "option_guild_id", "option_state", "option_state_times", "option_starting", AssetOption}
+)
+
+const (
+	CloseReasonRemoved = "removed"
+	CloseReasonNuked   = "nuked"
+	CloseReasonStopped = "stopped"
+	CloseReasonTarget  = "target"
+	CloseReasonExpired = "expired"
+)
+
+// PriceUnknown is the price to close an alert at when nobody knows what it last traded at. Passing 0 instead closes
+// it at its best price so far.
+const PriceUnknown float32 = -1
+
+// ClosedAlert is what's left of an alert of any asset type once it has been removed.
+// Highest is the lowest price for shorts, Contract is only set for options, and States is its lifecycle up to closing.
+type ClosedAlert struct {
+	ClosedID    int64 `bun:",pk,autoincrement"`
+	AlertID     string
+	GuildID     string
+	AssetType   string
+	Ticker      string
+	Contract    string
+	AlertType   int
+	Caller      string
+	Starting    float32
+	Highest     float32
+	CallTime    time.Time
+	CloseReason string
+	ClosePrice  float32
+	CloseTime   time.Time
+	PctGain     float32
+	States      StateTimes
+
+	// PriceUnknown is set when the alert was closed without a price, e.g. expired with no quote to hand. ClosePrice
+	// and PctGain are then zero and say nothing about the call.
+	PriceUnknown bool `bun:",notnull,default:false"`
 }
 
 type DB struct {
 	Guild string
 	db    *bun.DB
+	reg   *AlertRegistry
+	clock clock.Clock
 }
@@ -0,0 +1,335 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// The price series a target is measured on. Options target their premium or their underlying, everything else its own price.
+const (
+	TargetPrice      = "price"
+	TargetPremium    = "premium"
+	TargetUnderlying = "underlying"
+)
+
+// Target is one price target on an alert. HitPrice and HitTime stay zero until it is hit, and ClosedID until the
+// alert is closed, when the target is kept with the ClosedAlert it was archived as.
+type Target struct {
+	TargetID  int64 `bun:",pk,autoincrement"`
+	ClosedID  int64 `bun:",nullzero"`
+	GuildID   string
+	AlertID   string
+	AssetType string
+	Series    string
+	Price     float32
+	HitPrice  float32
+	HitTime   time.Time `bun:",nullzero"`
+	CreatedAt time.Time
+}
+
+func (t *Target) Hit() bool {
+	return !t.HitTime.IsZero()
+}
+
+func tableFor(assetType string) (alertTable, error) {
+	switch assetType {
+	case AssetStock:
+		return stockTable, nil
+	case AssetShort:
+		return shortTable, nil
+	case AssetCrypto:
+		return cryptoTable, nil
+	case AssetOption:
+		return optionTable, nil
+	}
+	return alertTable{}, invalidInput("unknown asset type %q", assetType)
+}
+
+// targetSeries checks series makes sense for assetType, defaulting it to the premium for options and the price otherwise.
+func targetSeries(assetType, series string) (string, error) {
+	if assetType == AssetOption {
+		switch series {
+		case "":
+			return TargetPremium, nil
+		case TargetPremium, TargetUnderlying:
+			return series, nil
+		}
+	} else if series == "" || series == TargetPrice {
+		return TargetPrice, nil
+	}
+	return "", invalidInput("%v targets cannot be on the %v", assetType, series)
+}
+
+// seedTargets are the targets an alert is created with; zero prices are skipped.
+func seedTargets(guildID, uid, assetType, series string, at time.Time, prices ...float32) []*Target {
+	targets := make([]*Target, 0, len(prices))
+	for _, p := range prices {
+		if p == 0 {
+			continue
+		}
+		targets = append(targets, &Target{
+			GuildID:   guildID,
+			AlertID:   uid,
+			AssetType: assetType,
+			Series:    series,
+			Price:     p,
+			CreatedAt: at,
+		})
+	}
+	return targets
+}
+
+func insertTargets(ctx context.Context, idb bun.IDB, targets []*Target) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	_, err := idb.NewInsert().Model(&targets).On("CONFLICT DO NOTHING").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to save targets: %w", err)
+	}
+	return nil
+}
+
+// resetDetails drops the open targets and fills of the guild's alert uid, for when it is being replaced.
+func resetDetails(ctx context.Context, idb bun.IDB, guildID, assetType, uid string) error {
+	for _, model := range []interface{}{(*Target)(nil), (*Fill)(nil)} {
+		_, err := idb.NewDelete().Model(model).
+			Where("guild_id = ?", guildID).
+			Where("asset_type = ?", assetType).
+			Where("alert_id = ?", uid).
+			Where("closed_id IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to reset %v %v : %w", assetType, uid, err)
+		}
+	}
+	return nil
+}
+
+// archiveDetails files the targets and fills of alerts that have just been closed under the ClosedAlert each was
+// archived as, keeping their hit history and realized gains while a reused alert ID starts afresh.
+func archiveDetails(ctx context.Context, idb bun.IDB, guildID string, closed ...*ClosedAlert) error {
+	for _, model := range []interface{}{(*Target)(nil), (*Fill)(nil)} {
+		for _, c := range closed {
+			_, err := idb.NewUpdate().Model(model).
+				Set("closed_id = ?", c.ClosedID).
+				Where("guild_id = ?", guildID).
+				Where("asset_type = ?", c.AssetType).
+				Where("alert_id = ?", c.AlertID).
+				Where("closed_id IS NULL").
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("unable to archive %v %v details: %w", c.AssetType, c.AlertID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// alertCaller returns who called an alert in the guild, which also proves it exists there.
+func (d *DB) alertCaller(ctx context.Context, idb bun.IDB, t alertTable, uid string) (string, error) {
+	var caller string
+	err := idb.NewSelect().Model(t.model).Column("caller").
+		Where("? = ?", bun.Ident(t.pk), uid).
+		Where("? = ?", bun.Ident(t.guild), d.Guild).
+		Scan(ctx, &caller)
+	return caller, notFound(err, t.assetType, uid)
+}
+
+// AddTarget adds a price target to an alert. Series is TargetPremium or TargetUnderlying for options, defaulting to
+// the premium, and TargetPrice for everything else. Adding the same target twice fails with ErrAlreadyExists.
+func (d *DB) AddTarget(assetType, uid, series string, price float32) (*Target, error) {
+	return d.AddTargetContext(context.Background(), assetType, uid, series, price)
+}
+
+func (d *DB) AddTargetContext(ctx context.Context, assetType, uid, series string, price float32) (*Target, error) {
+	t, err := tableFor(assetType)
+	if err != nil {
+		return nil, err
+	}
+	series, err = targetSeries(assetType, series)
+	if err != nil {
+		return nil, err
+	}
+	if price <= 0 {
+		return nil, invalidInput("target %v must be above zero", price)
+	}
+
+	target := seedTargets(d.Guild, uid, assetType, series, d.now(), price)[0]
+
+	err = d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		caller, err := d.alertCaller(ctx, tx, t, uid)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.NewInsert().Model(target).On("CONFLICT DO NOTHING").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return fmt.Errorf("%v target %v on %v %v : %w", series, price, assetType, uid, ErrAlreadyExists)
+		}
+
+		return writeAudit(ctx, tx, d.now(), newAuditEntry(ctx, AuditUpdate, d.Guild, uid, assetType, caller, nil).change("target", "", price))
+	})
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to add target to %v %v : %v", assetType, uid, err.Error()))
+		return nil, err
+	}
+	return target, nil
+}
+
+// RemoveTarget deletes a target by ID.
+func (d *DB) RemoveTarget(targetID int64) error {
+	return d.RemoveTargetContext(context.Background(), targetID)
+}
+
+func (d *DB) RemoveTargetContext(ctx context.Context, targetID int64) error {
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		target := &Target{}
+		res, err := tx.NewDelete().Model(target).
+			Where("target_id = ?", targetID).
+			Where("guild_id = ?", d.Guild).
+			Where("closed_id IS NULL").
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return notFound(sql.ErrNoRows, "target", targetID)
+		}
+
+		t, err := tableFor(target.AssetType)
+		if err != nil {
+			return err
+		}
+		caller, err := d.alertCaller(ctx, tx, t, target.AlertID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), newAuditEntry(ctx, AuditUpdate, d.Guild, target.AlertID, target.AssetType, caller, nil).change("target", target.Price, ""))
+	})
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to remove target %v : %v", targetID, err.Error()))
+		return err
+	}
+	return nil
+}
+
+// HitTarget marks a target hit at price, reporting whether it did; a target that was already hit is left alone.
+func (d *DB) HitTarget(targetID int64, price float32) (bool, error) {
+	return d.HitTargetContext(context.Background(), targetID, price)
+}
+
+func (d *DB) HitTargetContext(ctx context.Context, targetID int64, price float32) (bool, error) {
+	hit := false
+	err := d.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		target := &Target{}
+		res, err := tx.NewUpdate().Model(target).
+			Set("hit_price = ?", price).
+			Set("hit_time = ?", d.now()).
+			Where("target_id = ?", targetID).
+			Where("guild_id = ?", d.Guild).
+			Where("closed_id IS NULL").
+			Where("hit_time IS NULL").
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			exists, err := tx.NewSelect().Model((*Target)(nil)).Where("target_id = ?", targetID).Where("guild_id = ?", d.Guild).Where("closed_id IS NULL").Exists(ctx)
+			if err == nil && !exists {
+				err = notFound(sql.ErrNoRows, "target", targetID)
+			}
+			return err
+		}
+
+		hit = true
+		t, err := tableFor(target.AssetType)
+		if err != nil {
+			return err
+		}
+		caller, err := d.alertCaller(ctx, tx, t, target.AlertID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return writeAudit(ctx, tx, d.now(), newAuditEntry(ctx, AuditUpdate, d.Guild, target.AlertID, target.AssetType, caller, nil).change("target_hit", target.Price, price))
+	})
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to hit target %v : %v", targetID, err.Error()))
+		return false, err
+	}
+	return hit, nil
+}
+
+// GetTargets returns an open alert's targets, by series then price.
+func (d *DB) GetTargets(assetType, uid string) ([]*Target, error) {
+	return d.GetTargetsContext(context.Background(), assetType, uid)
+}
+
+func (d *DB) GetTargetsContext(ctx context.Context, assetType, uid string) ([]*Target, error) {
+	targets := make([]*Target, 0)
+	err := d.db.NewSelect().Model(&targets).
+		Where("guild_id = ?", d.Guild).
+		Where("asset_type = ?", assetType).
+		Where("alert_id = ?", uid).
+		Where("closed_id IS NULL").
+		Order("series", "price").
+		Scan(ctx)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get targets for %v %v : %v", assetType, uid, err.Error()))
+		return nil, err
+	}
+	return targets, nil
+}
+
+// GetClosedTargets returns the targets a closed alert had when it was closed, by series then price.
+func (d *DB) GetClosedTargets(closedID int64) ([]*Target, error) {
+	return d.GetClosedTargetsContext(context.Background(), closedID)
+}
+
+func (d *DB) GetClosedTargetsContext(ctx context.Context, closedID int64) ([]*Target, error) {
+	targets := make([]*Target, 0)
+	err := d.db.NewSelect().Model(&targets).
+		Where("guild_id = ?", d.Guild).
+		Where("closed_id = ?", closedID).
+		Order("series", "price").
+		Scan(ctx)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to get targets for closed alert %v : %v", closedID, err.Error()))
+		return nil, err
+	}
+	return targets, nil
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package market
+
+import (
+	"time"
+	_ "time/tzdata" // so New York's DST rules never depend on the host's zoneinfo
+)
+
+// Session is which part of the trading day a time falls in.
+type Session int
+
+const (
+	Closed Session = iota
+	PreMarket
+	Regular
+	AfterHours
+)
+
+func (s Session) String() string {
+	switch s {
+	case PreMarket:
+		return "pre-market"
+	case Regular:
+		return "regular"
+	case AfterHours:
+		return "after-hours"
+	}
+	return "closed"
+}
+
+// Session times, as hours and minutes after midnight in New York.
+const (
+	preMarketOpen   = 4 * time.Hour
+	regularOpen     = 9*time.Hour + 30*time.Minute
+	regularClose    = 16 * time.Hour
+	earlyClose      = 13 * time.Hour
+	afterHoursClose = 20 * time.Hour
+	earlyAfterHours = 17 * time.Hour
+)
+
+// Calendar is the NYSE trading calendar: its sessions, holidays and early closes, all in New York time.
+type Calendar struct {
+	loc *time.Location
+}
+
+var nyse = newNYSE()
+
+func newNYSE() *Calendar {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic(err.Error()) // unreachable with time/tzdata embedded
+	}
+	return &Calendar{loc: loc}
+}
+
+// NYSE returns the New York Stock Exchange calendar. A Calendar never changes, so every caller shares the one.
+func NYSE() *Calendar {
+	return nyse
+}
+
+// Location is New York.
+func (c *Calendar) Location() *time.Location {
+	return c.loc
+}
+
+// IsTradingDay reports whether the market opens at all on the date of t.
+func (c *Calendar) IsTradingDay(t time.Time) bool {
+	switch t.In(c.loc).Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	_, holiday := c.Holiday(t)
+	return !holiday
+}
+
+func (c *Calendar) midnight(t time.Time) time.Time {
+	y, m, d := t.In(c.loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, c.loc)
+}
+
+// at is the time offset after midnight on the date of day, which is correct across DST changes as none happen
+// during trading hours.
+func (c *Calendar) at(day time.Time, offset time.Duration) time.Time {
+	return c.midnight(day).Add(offset)
+}
+
+// Hours returns the regular open and close on the date of t, and false if the market doesn't open that day.
+func (c *Calendar) Hours(t time.Time) (open, close time.Time, ok bool) {
+	if !c.IsTradingDay(t) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	closeAt := regularClose
+	if c.EarlyClose(t) {
+		closeAt = earlyClose
+	}
+	return c.at(t, regularOpen), c.at(t, closeAt), true
+}
+
+// SessionAt returns the session t falls in. Pre-market runs from 4am, after-hours until 8pm, or 5pm after an early close.
+func (c *Calendar) SessionAt(t time.Time) Session {
+	open, close, ok := c.Hours(t)
+	if !ok {
+		return Closed
+	}
+
+	afterHours := afterHoursClose
+	if c.EarlyClose(t) {
+		afterHours = earlyAfterHours
+	}
+
+	switch {
+	case t.Before(c.at(t, preMarketOpen)):
+		return Closed
+	case t.Before(open):
+		return PreMarket
+	case t.Before(close):
+		return Regular
+	case t.Before(c.at(t, afterHours)):
+		return AfterHours
+	}
+	return Closed
+}
+
+// IsOpen reports whether t is during the regular session.
+func (c *Calendar) IsOpen(t time.Time) bool {
+	return c.SessionAt(t) == Regular
+}
+
+// NextOpen returns the first regular open after t.
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	for day := c.midnight(t); ; day = c.midnight(day.AddDate(0, 0, 1)) {
+		if open, _, ok := c.Hours(day); ok && open.After(t) {
+			return open
+		}
+	}
+}
+
+// NextClose returns the close of the regular session t is in or, outside one, of the next.
+func (c *Calendar) NextClose(t time.Time) time.Time {
+	for day := c.midnight(t); ; day = c.midnight(day.AddDate(0, 0, 1)) {
+		if _, close, ok := c.Hours(day); ok && close.After(t) {
+			return close
+		}
+	}
+}
+
+// LastClose returns the regular close on the date of t or, if the market doesn't open that day, on the last
+// trading day before it.
+func (c *Calendar) LastClose(t time.Time) time.Time {
+	for day := c.midnight(t); ; day = c.midnight(day.Add(-time.Hour)) {
+		if _, close, ok := c.Hours(day); ok {
+			return close
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+var ny = NYSE().Location()
+
+func at(y int, m time.Month, d, hour, min int) time.Time {
+	return time.Date(y, m, d, hour, min, 0, 0, ny)
+}
+
+func TestHolidays(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{at(2026, time.January, 1, 12, 0), "New Year's Day"},
+		{at(2026, time.January, 19, 12, 0), "Martin Luther King, Jr. Day"},
+		{at(2026, time.February, 16, 12, 0), "Washington's Birthday"},
+		{at(2026, time.April, 3, 12, 0), "Good Friday"},
+		{at(2026, time.May, 25, 12, 0), "Memorial Day"},
+		{at(2026, time.June, 19, 12, 0), "Juneteenth National Independence Day"},
+		{at(2026, time.July, 3, 12, 0), "Independence Day"}, // Saturday, observed the Friday before
+		{at(2026, time.September, 7, 12, 0), "Labor Day"},
+		{at(2026, time.November, 26, 12, 0), "Thanksgiving Day"},
+		{at(2026, time.December, 25, 12, 0), "Christmas Day"},
+		{at(2027, time.March, 26, 12, 0), "Good Friday"},
+		{at(2027, time.June, 18, 12, 0), "Juneteenth National Independence Day"}, // Saturday
+		{at(2027, time.July, 5, 12, 0), "Independence Day"},                      // Sunday, observed the Monday after
+		{at(2027, time.December, 24, 12, 0), "Christmas Day"},                    // Saturday
+		{at(2022, time.January, 17, 12, 0), "Martin Luther King, Jr. Day"},
+		{at(2023, time.January, 2, 12, 0), "New Year's Day"}, // Sunday
+		{at(2024, time.March, 29, 12, 0), "Good Friday"},
+		{at(2025, time.April, 18, 12, 0), "Good Friday"},
+
+		// Trading days that look like they might not be.
+		{at(2026, time.December, 28, 12, 0), ""},
+		{at(2021, time.December, 31, 12, 0), ""}, // New Year's Day 2022 is a Saturday and isn't observed
+		{at(2027, time.December, 31, 12, 0), ""},
+		{at(2021, time.June, 18, 12, 0), ""}, // before Juneteenth was a market holiday
+		{at(2026, time.October, 12, 12, 0), ""},
+		{at(2026, time.November, 11, 12, 0), ""},
+	}
+	for _, tt := range tests {
+		name, ok := NYSE().Holiday(tt.date)
+		if name != tt.want || ok != (tt.want != "") {
+			t.Errorf("Holiday(%v) = %q, %v, want %q", tt.date.Format("2006-01-02"), name, ok, tt.want)
+		}
+		if NYSE().IsTradingDay(tt.date) == ok {
+			t.Errorf("IsTradingDay(%v) = %v with holiday %q", tt.date.Format("2006-01-02"), !ok, name)
+		}
+	}
+}
+
+func TestEarlyClose(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want bool
+	}{
+		{at(2025, time.July, 3, 12, 0), true},
+		{at(2025, time.November, 28, 12, 0), true},
+		{at(2025, time.December, 24, 12, 0), true},
+		{at(2026, time.November, 27, 12, 0), true},
+		{at(2026, time.December, 24, 12, 0), true},
+		{at(2026, time.July, 3, 12, 0), false},      // the Independence Day holiday itself
+		{at(2027, time.December, 24, 12, 0), false}, // the Christmas Day holiday itself
+		{at(2027, time.November, 26, 12, 0), true},
+		{at(2026, time.December, 31, 12, 0), false},
+		{at(2026, time.October, 14, 12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := NYSE().EarlyClose(tt.date); got != tt.want {
+			t.Errorf("EarlyClose(%v) = %v, want %v", tt.date.Format("2006-01-02"), got, tt.want)
+		}
+	}
+
+	_, close, ok := NYSE().Hours(at(2026, time.November, 27, 12, 0))
+	if !ok || !close.Equal(at(2026, time.November, 27, 13, 0)) {
+		t.Errorf("the day after Thanksgiving closes at %v, want 1pm", close)
+	}
+}
+
+func TestSessionAt(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want Session
+	}{
+		{at(2026, time.October, 14, 3, 59), Closed},
+		{at(2026, time.October, 14, 4, 0), PreMarket},
+		{at(2026, time.October, 14, 9, 29), PreMarket},
+		{at(2026, time.October, 14, 9, 30), Regular},
+		{at(2026, time.October, 14, 15, 59), Regular},
+		{at(2026, time.October, 14, 16, 0), AfterHours},
+		{at(2026, time.October, 14, 19, 59), AfterHours},
+		{at(2026, time.October, 14, 20, 0), Closed},
+		{at(2026, time.November, 27, 12, 59), Regular},
+		{at(2026, time.November, 27, 13, 0), AfterHours},
+		{at(2026, time.November, 27, 16, 59), AfterHours},
+		{at(2026, time.November, 27, 17, 0), Closed},
+		{at(2026, time.November, 26, 11, 0), Closed},
+		{at(2026, time.October, 17, 11, 0), Closed},
+	}
+	for _, tt := range tests {
+		if got := NYSE().SessionAt(tt.at); got != tt.want {
+			t.Errorf("SessionAt(%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestHoursAcrossDST(t *testing.T) {
+	for _, tt := range []struct {
+		date time.Time
+		want time.Time
+	}{
+		{at(2026, time.March, 6, 12, 0), time.Date(2026, time.March, 6, 14, 30, 0, 0, time.UTC)},
+		{at(2026, time.March, 9, 12, 0), time.Date(2026, time.March, 9, 13, 30, 0, 0, time.UTC)},
+		{at(2026, time.October, 30, 12, 0), time.Date(2026, time.October, 30, 13, 30, 0, 0, time.UTC)},
+		{at(2026, time.November, 2, 12, 0), time.Date(2026, time.November, 2, 14, 30, 0, 0, time.UTC)},
+	} {
+		if open, _, ok := NYSE().Hours(tt.date); !ok || !open.Equal(tt.want) {
+			t.Errorf("%v opens at %v, want %v", tt.date.Format("2006-01-02"), open.UTC(), tt.want)
+		}
+	}
+}
+
+func TestNextOpenAndClose(t *testing.T) {
+	tests := []struct {
+		name      string
+		at        time.Time
+		nextOpen  time.Time
+		nextClose time.Time
+	}{
+		{"before the open", at(2026, time.October, 14, 8, 0), at(2026, time.October, 14, 9, 30), at(2026, time.October, 14, 16, 0)},
+		{"during the session", at(2026, time.October, 14, 11, 0), at(2026, time.October, 15, 9, 30), at(2026, time.October, 14, 16, 0)},
+		{"at the close", at(2026, time.October, 14, 16, 0), at(2026, time.October, 15, 9, 30), at(2026, time.October, 15, 16, 0)},
+		{"friday evening", at(2026, time.October, 16, 17, 0), at(2026, time.October, 19, 9, 30), at(2026, time.October, 19, 16, 0)},
+		{"saturday", at(2026, time.October, 17, 11, 0), at(2026, time.October, 19, 9, 30), at(2026, time.October, 19, 16, 0)},
+		{"before good friday", at(2026, time.April, 2, 17, 0), at(2026, time.April, 6, 9, 30), at(2026, time.April, 6, 16, 0)},
+		{"before thanksgiving", at(2026, time.November, 25, 16, 30), at(2026, time.November, 27, 9, 30), at(2026, time.November, 27, 13, 0)},
+		{"new year's eve", at(2026, time.December, 31, 16, 0), at(2027, time.January, 4, 9, 30), at(2027, time.January, 4, 16, 0)},
+	}
+	for _, tt := range tests {
+		if got := NYSE().NextOpen(tt.at); !got.Equal(tt.nextOpen) {
+			t.Errorf("%v: NextOpen = %v, want %v", tt.name, got, tt.nextOpen)
+		}
+		if got := NYSE().NextClose(tt.at); !got.Equal(tt.nextClose) {
+			t.Errorf("%v: NextClose = %v, want %v", tt.name, got, tt.nextClose)
+		}
+	}
+}
+
+func TestLastClose(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{"trading day", at(2026, time.October, 16, 0, 0), at(2026, time.October, 16, 16, 0)},
+		{"after the close", at(2026, time.October, 16, 18, 0), at(2026, time.October, 16, 16, 0)},
+		{"early close", at(2026, time.November, 27, 0, 0), at(2026, time.November, 27, 13, 0)},
+		{"saturday", at(2026, time.October, 17, 0, 0), at(2026, time.October, 16, 16, 0)},
+		{"good friday", at(2027, time.March, 26, 0, 0), at(2027, time.March, 25, 16, 0)},
+		{"new year's weekend", at(2027, time.January, 3, 0, 0), at(2026, time.December, 31, 16, 0)},
+	}
+	for _, tt := range tests {
+		if got := NYSE().LastClose(tt.at); !got.Equal(tt.want) {
+			t.Errorf("%v: LastClose = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
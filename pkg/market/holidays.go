@@ -0,0 +1,132 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package market
+
+import (
+	"time"
+)
+
+// Holiday returns the name of the NYSE holiday the market is closed for on the date of t, in New York.
+func (c *Calendar) Holiday(t time.Time) (string, bool) {
+	y, m, d := t.In(c.loc).Date()
+	for _, h := range c.holidays(y) {
+		if h.month == m && h.day == d {
+			return h.name, true
+		}
+	}
+	return "", false
+}
+
+// EarlyClose reports whether the market closes at 1pm on the date of t: the day before Independence Day, the day
+// after Thanksgiving and Christmas Eve, whenever they are trading days.
+func (c *Calendar) EarlyClose(t time.Time) bool {
+	if !c.IsTradingDay(t) {
+		return false
+	}
+
+	y, m, d := t.In(c.loc).Date()
+	switch {
+	case m == time.July && d == 3:
+		return true
+	case m == time.December && d == 24:
+		return true
+	case m == time.November:
+		thanksgiving := nthWeekday(y, time.November, time.Thursday, 4)
+		return d == thanksgiving+1
+	}
+	return false
+}
+
+type holiday struct {
+	name  string
+	month time.Month
+	day   int
+}
+
+// holidays are the NYSE holidays of year, as observed.
+func (c *Calendar) holidays(year int) []holiday {
+	hs := []holiday{
+		observed("New Year's Day", year, time.January, 1, false),
+		{"Washington's Birthday", time.February, nthWeekday(year, time.February, time.Monday, 3)},
+		goodFriday(year),
+		{"Memorial Day", time.May, lastWeekday(year, time.May, time.Monday)},
+		observed("Independence Day", year, time.July, 4, true),
+		{"Labor Day", time.September, nthWeekday(year, time.September, time.Monday, 1)},
+		{"Thanksgiving Day", time.November, nthWeekday(year, time.November, time.Thursday, 4)},
+		observed("Christmas Day", year, time.December, 25, true),
+	}
+	if year >= 1998 {
+		hs = append(hs, holiday{"Martin Luther King, Jr. Day", time.January, nthWeekday(year, time.January, time.Monday, 3)})
+	}
+	if year >= 2022 {
+		hs = append(hs, observed("Juneteenth National Independence Day", year, time.June, 19, true))
+	}
+	return hs
+}
+
+// observed moves a fixed holiday on a Sunday to the Monday after and, if saturdays is set, one on a Saturday to the
+// Friday before.
+func observed(name string, year int, month time.Month, day int, saturdays bool) holiday {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	switch t.Weekday() {
+	case time.Sunday:
+		t = t.AddDate(0, 0, 1)
+	case time.Saturday:
+		if saturdays {
+			t = t.AddDate(0, 0, -1)
+		}
+	}
+	// NYSE doesn't close on the Friday before a Saturday New Year's Day, as it would be the end of the previous year.
+	if t.Year() != year || t.Weekday() == time.Saturday {
+		return holiday{name: name}
+	}
+	return holiday{name, t.Month(), t.Day()}
+}
+
+// nthWeekday is the day of month of the nth weekday in it.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) int {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return 1 + offset + (n-1)*7
+}
+
+// lastWeekday is the day of month of the last weekday in it.
+func lastWeekday(year int, month time.Month, weekday time.Weekday) int {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+	offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+	return last.Day() - offset
+}
+
+// goodFriday is two days before Easter Sunday, found with the anonymous Gregorian algorithm.
+func goodFriday(year int) holiday {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -2)
+	return holiday{"Good Friday", t.Month(), t.Day()}
+}
@@ -0,0 +1,427 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package poll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/db"
+	"github.com/m1k8/harpe/pkg/polygon"
+	"github.com/m1k8/harpe/pkg/quotes"
+	"github.com/m1k8/harpe/pkg/types"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// Snapshotter fetches the snapshots of many tickers in one request. *polygon.Client is one.
+type Snapshotter interface {
+	Snapshots(ctx context.Context, tickers []string) (*types.UniversalSnapshotResponse, error)
+}
+
+// AlertRef is an open alert watching a ticker.
+type AlertRef struct {
+	Guild     string
+	ID        string
+	AssetType string
+}
+
+// Price is a ticker's latest price from one poll, along with every alert watching it.
+// Underlying, Bid and Ask are only set for options.
+type Price struct {
+	Ticker     string
+	Price      float64
+	Underlying float64
+	Bid        float64
+	Ask        float64
+	At         time.Time
+	Alerts     []AlertRef
+	Snapshot   types.UniversalSnapshot
+}
+
+// Ticker returns how Polygon names assetType's symbol: AAPL, X:BTCUSD or O:AAPL261218C00150000.
+func Ticker(assetType, symbol string) (string, error) {
+	switch assetType {
+	case db.AssetStock, db.AssetShort:
+		return strings.ToUpper(strings.TrimSpace(symbol)), nil
+	case db.AssetCrypto:
+		from, to := quotes.SplitPair(symbol)
+		return "X:" + from + to, nil
+	case db.AssetOption:
+		s, err := utils.ParseOptionSymbol(symbol)
+		if err != nil {
+			return "", err
+		}
+		return s.Polygon(), nil
+	}
+	return "", db.ErrInvalidInput
+}
+
+// Group groups open alerts, as GetAll returns them, by the ticker they watch.
+func Group(stocks []*db.Stock, shorts []*db.Short, cryptos []*db.Crypto, options []*db.Option) map[string][]AlertRef {
+	groups := make(map[string][]AlertRef)
+	add := func(guild, id, assetType, ticker string) {
+		if ticker == "" {
+			return
+		}
+		groups[ticker] = append(groups[ticker], AlertRef{Guild: guild, ID: id, AssetType: assetType})
+	}
+	addSymbol := func(guild, id, assetType, symbol string) {
+		if ticker, err := Ticker(assetType, symbol); err == nil {
+			add(guild, id, assetType, ticker)
+		}
+	}
+
+	for _, v := range stocks {
+		addSymbol(v.StockGuildID, v.StockAlertID, db.AssetStock, v.StockTicker)
+	}
+	for _, v := range shorts {
+		addSymbol(v.ShortGuildID, v.ShortAlertID, db.AssetShort, v.ShortTicker)
+	}
+	for _, v := range cryptos {
+		addSymbol(v.CryptoGuildID, v.CryptoAlertID, db.AssetCrypto, v.CryptoCoin)
+	}
+	for _, v := range options {
+		if s, err := v.Symbol(); err == nil {
+			add(v.OptionGuildID, v.OptionAlertID, db.AssetOption, s.Polygon())
+		}
+	}
+	return groups
+}
+
+type subscriber struct {
+	ch chan Price
+}
+
+// Scheduler polls the prices of every open alert in Repos, one snapshot request per BatchSize tickers however
+// many alerts share them, and hands them to subscribers. It polls every Open during NYSE's regular session and every
+// Closed otherwise, each give or take Jitter (a fraction of the interval), and never makes more than Budget
+// requests a minute across all guilds.
+type Scheduler struct {
+	Source    Snapshotter
+	Repos     []db.Repository
+	Open      time.Duration
+	Closed    time.Duration
+	Jitter    float64
+	Budget    int
+	BatchSize int
+	Clock     clock.Clock
+
+	mu     sync.Mutex
+	subs   map[string]map[*subscriber]struct{}
+	rounds map[chan []Price]struct{}
+	next   time.Time
+}
+
+// NewScheduler returns a scheduler polling source for the alerts in repos, one per guild, every 5 seconds in
+// trading hours and every minute otherwise, within 100 requests a minute. A *polygon.Client source is used FailFast,
+// since a rate limited poll is abandoned rather than waited out.
+func NewScheduler(source Snapshotter, repos ...db.Repository) *Scheduler {
+	if c, ok := source.(*polygon.Client); ok {
+		source = c.FailFast()
+	}
+	return &Scheduler{
+		Source:    source,
+		Repos:     repos,
+		Open:      5 * time.Second,
+		Closed:    time.Minute,
+		Jitter:    0.1,
+		Budget:    100,
+		BatchSize: polygon.MaxSnapshots,
+		Clock:     clock.System(),
+	}
+}
+
+func (s *Scheduler) now() time.Time {
+	return clock.OrSystem(s.Clock).Now()
+}
+
+// Interval is how long to wait after a poll at now, before jitter.
+func (s *Scheduler) Interval(now time.Time) time.Duration {
+	if db.IsTradingHoursAt(now) {
+		return s.Open
+	}
+	return s.Closed
+}
+
+func (s *Scheduler) jitter(d time.Duration) time.Duration {
+	if s.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*s.Jitter*float64(d))
+}
+
+// Run polls until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		if err := s.Poll(ctx); err != nil && ctx.Err() == nil {
+			log.Println(fmt.Sprintf("Unable to poll prices : %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.jitter(s.Interval(s.now()))):
+		}
+	}
+}
+
+// wait blocks until the budget allows another request.
+func (s *Scheduler) wait(ctx context.Context) error {
+	if s.Budget <= 0 {
+		return ctx.Err()
+	}
+
+	now := s.now()
+	s.mu.Lock()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	s.next = at.Add(time.Minute / time.Duration(s.Budget))
+	s.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	return ctx.Err()
+}
+
+// collect groups every open alert in every guild, plus anything subscribed to directly, by ticker. A guild that
+// can't be read is skipped for this poll.
+func (s *Scheduler) collect(ctx context.Context) map[string][]AlertRef {
+	groups := make(map[string][]AlertRef)
+	for _, r := range s.Repos {
+		stocks, shorts, cryptos, options, err := r.GetAllContext(ctx)
+		if err != nil {
+			log.Println(fmt.Sprintf("Unable to get alerts to poll : %v", err))
+			continue
+		}
+		for ticker, alerts := range Group(stocks, shorts, cryptos, options) {
+			groups[ticker] = append(groups[ticker], alerts...)
+		}
+	}
+
+	s.mu.Lock()
+	for ticker := range s.subs {
+		if _, ok := groups[ticker]; !ok {
+			groups[ticker] = nil
+		}
+	}
+	s.mu.Unlock()
+	return groups
+}
+
+// Poll fetches the price of every open alert's ticker once and delivers them. A failed batch is logged and skipped,
+// unless Polygon is rate limiting, when the rest of the poll is abandoned.
+func (s *Scheduler) Poll(ctx context.Context) error {
+	groups := s.collect(ctx)
+	if len(groups) == 0 {
+		return ctx.Err()
+	}
+
+	tickers := make([]string, 0, len(groups))
+	for ticker := range groups {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+
+	size := s.BatchSize
+	if size <= 0 || size > polygon.MaxSnapshots {
+		size = polygon.MaxSnapshots
+	}
+
+	round := make([]Price, 0, len(tickers))
+	for len(tickers) > 0 {
+		n := size
+		if n > len(tickers) {
+			n = len(tickers)
+		}
+		batch := tickers[:n]
+		tickers = tickers[n:]
+
+		if err := s.wait(ctx); err != nil {
+			return err
+		}
+
+		res, err := s.Source.Snapshots(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, polygon.ErrRateLimited) {
+				s.deliverRound(round)
+				return err
+			}
+			log.Println(fmt.Sprintf("Unable to poll %v tickers : %v", len(batch), err))
+			continue
+		}
+
+		for _, snap := range res.Results {
+			alerts, asked := groups[snap.Ticker]
+			p, ok := s.price(snap)
+			if !asked || !ok {
+				continue
+			}
+			p.Alerts = alerts
+			s.deliver(p)
+			round = append(round, p)
+		}
+	}
+
+	s.deliverRound(round)
+	return nil
+}
+
+// price reads the price out of a snapshot: the quote midpoint for options, else the last trade, else the session.
+func (s *Scheduler) price(snap types.UniversalSnapshot) (Price, bool) {
+	if snap.Error != "" {
+		return Price{}, false
+	}
+
+	p := Price{Ticker: snap.Ticker, Snapshot: snap}
+	if strings.HasPrefix(snap.Ticker, "O:") {
+		p.Price = snap.LastQuote.Midpoint
+		p.Bid = snap.LastQuote.Bid
+		p.Ask = snap.LastQuote.Ask
+		p.Underlying = snap.UnderlyingAsset.Price
+	}
+	if p.Price <= 0 {
+		p.Price = snap.LastTrade.Price
+	}
+	if p.Price <= 0 {
+		p.Price = snap.Session.Price
+	}
+	if p.Price <= 0 {
+		return Price{}, false
+	}
+
+	switch {
+	case snap.LastTrade.SipTimestamp > 0:
+		p.At = time.Unix(0, snap.LastTrade.SipTimestamp)
+	case snap.LastQuote.LastUpdated > 0:
+		p.At = time.Unix(0, snap.LastQuote.LastUpdated)
+	default:
+		p.At = s.now()
+	}
+	return p, true
+}
+
+func (s *Scheduler) deliver(p Price) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subs[p.Ticker] {
+		select {
+		case sub.ch <- p:
+		default:
+			select {
+			case <-sub.ch:
+			default:
+			}
+			sub.ch <- p
+		}
+	}
+}
+
+func (s *Scheduler) deliverRound(round []Price) {
+	if len(round) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.rounds {
+		select {
+		case ch <- round:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- round
+		}
+	}
+}
+
+// Subscribe delivers the price of assetType's symbol after every poll until ctx is done, when the channel is
+// closed. The symbol is polled while subscribed to even if no alert watches it. A slow reader only ever sees the
+// latest price.
+func (s *Scheduler) Subscribe(ctx context.Context, assetType, symbol string) (<-chan Price, error) {
+	ticker, err := Ticker(assetType, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subscriber{ch: make(chan Price, 1)}
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[string]map[*subscriber]struct{})
+	}
+	if s.subs[ticker] == nil {
+		s.subs[ticker] = make(map[*subscriber]struct{})
+	}
+	s.subs[ticker][sub] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		delete(s.subs[ticker], sub)
+		if len(s.subs[ticker]) == 0 {
+			delete(s.subs, ticker)
+		}
+		close(sub.ch)
+		s.mu.Unlock()
+	}()
+
+	return sub.ch, nil
+}
+
+// SubscribeAll delivers every price from each poll at once, with the alerts watching it, until ctx is done. A slow
+// reader only ever sees the latest poll.
+func (s *Scheduler) SubscribeAll(ctx context.Context) <-chan []Price {
+	ch := make(chan []Price, 1)
+	s.mu.Lock()
+	if s.rounds == nil {
+		s.rounds = make(map[chan []Price]struct{})
+	}
+	s.rounds[ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		delete(s.rounds, ch)
+		close(ch)
+		s.mu.Unlock()
+	}()
+
+	return ch
+}
+
+var _ Snapshotter = (*polygon.Client)(nil)
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package poll
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/db"
+	"github.com/m1k8/harpe/pkg/market"
+	"github.com/m1k8/harpe/pkg/polygon"
+	"github.com/m1k8/harpe/pkg/types"
+)
+
+// snapshots is a Snapshotter quoting every ticker at prices[ticker], recording each batch it is asked for.
+type snapshots struct {
+	mu      sync.Mutex
+	prices  map[string]float64
+	batches [][]string
+	err     error
+}
+
+func (f *snapshots) Snapshots(_ context.Context, tickers []string) (*types.UniversalSnapshotResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, append([]string(nil), tickers...))
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	res := &types.UniversalSnapshotResponse{}
+	for _, ticker := range tickers {
+		snap := types.UniversalSnapshot{Ticker: ticker}
+		snap.LastTrade.Price = f.prices[ticker]
+		res.Results = append(res.Results, snap)
+	}
+	return res, nil
+}
+
+func (f *snapshots) set(ticker string, price float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prices[ticker] = price
+}
+
+var start = time.Date(2026, 10, 14, 11, 0, 0, 0, market.NYSE().Location())
+
+func newTestScheduler(source Snapshotter) (*Scheduler, *clock.Fake) {
+	c := clock.NewFake(start)
+	s := NewScheduler(source)
+	s.Clock = c
+	s.Budget = 0
+	return s, c
+}
+
+func subscribe(t *testing.T, ctx context.Context, s *Scheduler, symbols ...string) map[string]<-chan Price {
+	t.Helper()
+	subs := make(map[string]<-chan Price)
+	for _, symbol := range symbols {
+		ch, err := s.Subscribe(ctx, db.AssetStock, symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs[symbol] = ch
+	}
+	return subs
+}
+
+func TestGroup(t *testing.T) {
+	stocks := []*db.Stock{
+		{StockGuildID: "a", StockAlertID: "1", StockTicker: "aapl"},
+		{StockGuildID: "b", StockAlertID: "2", StockTicker: "AAPL"},
+	}
+	shorts := []*db.Short{{ShortGuildID: "a", ShortAlertID: "3", ShortTicker: "AAPL"}}
+	options := []*db.Option{
+		{OptionGuildID: "a", OptionAlertID: "4", OptionTicker: "AAPL", OptionContractType: "C", OptionDay: "18", OptionMonth: "12", OptionYear: "2026", OptionStrike: 150},
+		{OptionGuildID: "a", OptionAlertID: "5", OptionTicker: "AAPL", OptionContractType: "C", OptionDay: "31", OptionMonth: "02", OptionYear: "2026", OptionStrike: 150},
+	}
+
+	got := Group(stocks, shorts, nil, options)
+	want := map[string][]AlertRef{
+		"AAPL": {
+			{Guild: "a", ID: "1", AssetType: db.AssetStock},
+			{Guild: "b", ID: "2", AssetType: db.AssetStock},
+			{Guild: "a", ID: "3", AssetType: db.AssetShort},
+		},
+		"O:AAPL261218C00150000": {{Guild: "a", ID: "4", AssetType: db.AssetOption}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Group = %v, want %v, leaving out the option with no valid symbol", got, want)
+	}
+}
+
+func TestPollBatches(t *testing.T) {
+	source := &snapshots{prices: map[string]float64{"A": 1, "B": 2, "C": 3, "D": 4, "E": 5}}
+	s, _ := newTestScheduler(source)
+	s.BatchSize = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subs := subscribe(t, ctx, s, "E", "D", "C", "B", "A")
+
+	if err := s.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"A", "B"}, {"C", "D"}, {"E"}}
+	if !reflect.DeepEqual(source.batches, want) {
+		t.Errorf("polled in batches %v, want %v", source.batches, want)
+	}
+	for symbol, ch := range subs {
+		if p := <-ch; p.Price != source.prices[symbol] || !p.At.Equal(start) {
+			t.Errorf("%v delivered %+v, want %v at %v", symbol, p, source.prices[symbol], start)
+		}
+	}
+}
+
+func TestPollAbandonedWhenRateLimited(t *testing.T) {
+	source := &snapshots{prices: map[string]float64{"A": 1, "B": 2}, err: polygon.ErrRateLimited}
+	s, _ := newTestScheduler(source)
+	s.BatchSize = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscribe(t, ctx, s, "A", "B")
+
+	if err := s.Poll(ctx); !errors.Is(err, polygon.ErrRateLimited) {
+		t.Errorf("rate limited poll returned %v, want %v", err, polygon.ErrRateLimited)
+	}
+	if len(source.batches) != 1 {
+		t.Errorf("polled %v batches after being rate limited, want 1", len(source.batches))
+	}
+}
+
+func TestInterval(t *testing.T) {
+	s, _ := newTestScheduler(&snapshots{})
+	s.Open, s.Closed = time.Second, time.Hour
+
+	ny := market.NYSE().Location()
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Duration
+	}{
+		{"regular session", start, time.Second},
+		{"pre-market", time.Date(2026, 10, 14, 8, 0, 0, 0, ny), time.Hour},
+		{"weekend", time.Date(2026, 10, 17, 11, 0, 0, 0, ny), time.Hour},
+		{"thanksgiving", time.Date(2026, 11, 26, 11, 0, 0, 0, ny), time.Hour},
+	}
+	for _, tt := range tests {
+		if got := s.Interval(tt.at); got != tt.want {
+			t.Errorf("%v: Interval = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	s, _ := newTestScheduler(&snapshots{})
+	s.Jitter = 0.25
+
+	d := 4 * time.Second
+	lo, hi := 3*time.Second, 5*time.Second
+	var below, above bool
+	for i := 0; i < 1000; i++ {
+		got := s.jitter(d)
+		if got < lo || got > hi {
+			t.Fatalf("jitter(%v) = %v, outside %v to %v", d, got, lo, hi)
+		}
+		below = below || got < d
+		above = above || got > d
+	}
+	if !below || !above {
+		t.Errorf("jitter(%v) never went both ways in 1000 tries", d)
+	}
+
+	s.Jitter = 0
+	if got := s.jitter(d); got != d {
+		t.Errorf("jitter(%v) with no jitter = %v", d, got)
+	}
+}
+
+func TestWaitBudget(t *testing.T) {
+	s, c := newTestScheduler(&snapshots{})
+	s.Budget = 1
+
+	ctx := context.Background()
+	if err := s.wait(ctx); err != nil {
+		t.Fatalf("first request waited: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := s.wait(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second request in the same minute returned %v, want to wait out the minute", err)
+	}
+
+	// The abandoned wait still took the next slot, so the clock has to move two minutes to free another.
+	c.Advance(2 * time.Minute)
+	done := make(chan error, 1)
+	go func() { done <- s.wait(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("request a minute later is still waiting")
+	}
+
+	s.Budget = 6000 // a request every 10ms
+	s.next = time.Time{}
+	began := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := s.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if took := time.Since(began); took < 30*time.Millisecond {
+		t.Errorf("4 requests at 6000 a minute took %v, want at least 30ms", took)
+	}
+}
+
+func TestSubscribeLatestOnly(t *testing.T) {
+	source := &snapshots{prices: map[string]float64{"A": 1}}
+	s, _ := newTestScheduler(source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := subscribe(t, ctx, s, "A")["A"]
+	rounds := s.SubscribeAll(ctx)
+
+	for _, price := range []float64{1, 2, 3} {
+		source.set("A", price)
+		if err := s.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p := <-ch; p.Price != 3 {
+		t.Errorf("slow subscriber got %v, want only the latest price 3", p.Price)
+	}
+	if round := <-rounds; len(round) != 1 || round[0].Price != 3 {
+		t.Errorf("slow SubscribeAll got %+v, want only the latest poll", round)
+	}
+	select {
+	case p := <-ch:
+		t.Errorf("subscriber got a stale %+v after the latest", p)
+	case round := <-rounds:
+		t.Errorf("SubscribeAll got a stale %+v after the latest", round)
+	default:
+	}
+
+	cancel()
+	for range ch {
+	}
+	for range rounds {
+	}
+}
+
+func TestNewSchedulerFailsFast(t *testing.T) {
+	client := polygon.NewClient(config.StocksConfig{})
+	s := NewScheduler(client)
+
+	got, ok := s.Source.(*polygon.Client)
+	if !ok || !got.FailOnRateLimit {
+		t.Errorf("source %#v should be the client set to fail fast", s.Source)
+	}
+	if client.FailOnRateLimit {
+		t.Error("NewScheduler changed the client it was given")
+	}
+}
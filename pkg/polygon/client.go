@@ -0,0 +1,240 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package polygon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/types"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+const DefaultBaseURL = "https://api.polygon.io"
+
+// Client talks to Polygon's REST API. Requests that fail with a 429, a 5xx or a network error are retried up to
+// Retries times, backing off exponentially from Backoff or waiting as long as Polygon's Retry-After asks.
+// With FailOnRateLimit set a 429 comes straight back as ErrRateLimited instead, for callers with somewhere else to go.
+type Client struct {
+	BaseURL         string
+	Key             string
+	HTTP            *http.Client
+	Retries         int
+	Backoff         time.Duration
+	FailOnRateLimit bool
+}
+
+// NewClient returns a client for the endpoint and key in the stocks section of config.json, defaulting to
+// DefaultBaseURL when no endpoint is set.
+func NewClient(cfg config.StocksConfig) *Client {
+	base := cfg.E
+	if base == "" {
+		base = DefaultBaseURL
+	}
+
+	return &Client{
+		BaseURL: strings.TrimRight(base, "/"),
+		Key:     cfg.Key,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+		Retries: 3,
+		Backoff: 250 * time.Millisecond,
+	}
+}
+
+// FailFast returns a copy of c that returns a 429 at once rather than waiting it out.
+func (c *Client) FailFast() *Client {
+	fast := *c
+	fast.FailOnRateLimit = true
+	return &fast
+}
+
+// LastQuote returns the last trade of a stock, e.g. AAPL. The endpoint only covers stocks; crypto pairs go through
+// LastCryptoTrade.
+func (c *Client) LastQuote(ctx context.Context, ticker string) (*types.LastQuoteResponse, error) {
+	res := &types.LastQuoteResponse{}
+	if err := c.get(ctx, "/v2/last/trade/"+url.PathEscape(ticker), res); err != nil {
+		return nil, fmt.Errorf("unable to get last quote for %v: %w", ticker, err)
+	}
+	return res, nil
+}
+
+// LastCryptoTrade returns the last trade of the pair from/to, e.g. BTC and USD.
+func (c *Client) LastCryptoTrade(ctx context.Context, from, to string) (*types.LastCryptoTradeResponse, error) {
+	res := &types.LastCryptoTradeResponse{}
+	if err := c.get(ctx, fmt.Sprintf("/v1/last/crypto/%v/%v", url.PathEscape(from), url.PathEscape(to)), res); err != nil {
+		return nil, fmt.Errorf("unable to get last trade for %v/%v: %w", from, to, err)
+	}
+	return res, nil
+}
+
+// OptionSnapshot returns the snapshot of one option contract: its day, last quote, greeks and underlying.
+func (c *Client) OptionSnapshot(ctx context.Context, symbol utils.OptionSymbol) (*types.Snapshot, error) {
+	path := fmt.Sprintf("/v3/snapshot/options/%v/%v", url.PathEscape(symbol.Root), url.PathEscape(symbol.Polygon()))
+
+	res := &types.Snapshot{}
+	if err := c.get(ctx, path, res); err != nil {
+		return nil, fmt.Errorf("unable to get snapshot for %v: %w", symbol, err)
+	}
+	return res, nil
+}
+
+// UnderlyingPrice returns the price of an option's underlying, from its snapshot, or from the underlying's last
+// trade when the snapshot doesn't carry one.
+func (c *Client) UnderlyingPrice(ctx context.Context, symbol utils.OptionSymbol) (float64, error) {
+	snap, err := c.OptionSnapshot(ctx, symbol)
+	if err != nil {
+		return 0, err
+	}
+	if p := snap.Results.UnderlyingAsset.Price; p > 0 {
+		return p, nil
+	}
+
+	quote, err := c.LastQuote(ctx, symbol.Root)
+	if err != nil {
+		return 0, err
+	}
+	return quote.Results.P, nil
+}
+
+// MaxSnapshots is the most tickers Snapshots can ask for at once.
+const MaxSnapshots = 250
+
+// Snapshots returns the snapshots of up to MaxSnapshots tickers of any kind - AAPL, O:AAPL261218C00150000 or
+// X:BTCUSD - in one request. Tickers Polygon doesn't know come back with their Error set.
+func (c *Client) Snapshots(ctx context.Context, tickers []string) (*types.UniversalSnapshotResponse, error) {
+	if len(tickers) > MaxSnapshots {
+		return nil, fmt.Errorf("unable to get %v snapshots at once, the most is %v", len(tickers), MaxSnapshots)
+	}
+
+	q := url.Values{}
+	q.Set("ticker.any_of", strings.Join(tickers, ","))
+	q.Set("limit", strconv.Itoa(MaxSnapshots))
+
+	res := &types.UniversalSnapshotResponse{}
+	if err := c.get(ctx, "/v3/snapshot?"+q.Encode(), res); err != nil {
+		return nil, fmt.Errorf("unable to get snapshots for %v tickers: %w", len(tickers), err)
+	}
+	return res, nil
+}
+
+type errorBody struct {
+	Status    string `json:"status"`
+	RequestID string `json:"request_id"`
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+}
+
+// get fetches path and decodes the JSON response into out, retrying what is worth retrying.
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var wait time.Duration
+		wait, err = c.try(ctx, path, out)
+		if err == nil || wait < 0 || attempt >= c.Retries {
+			return err
+		}
+		if c.FailOnRateLimit && errors.Is(err, ErrRateLimited) {
+			return err
+		}
+
+		if wait == 0 {
+			wait = c.Backoff << attempt
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// try makes one request. It returns how long to wait before trying again: 0 for the usual backoff, or negative if
+// there is no point retrying.
+func (c *Client) try(ctx context.Context, path string, out interface{}) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Key)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		return 0, fmt.Errorf("%v: %w", err, ErrUnavailable)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", err, ErrUnavailable)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+
+		var eb errorBody
+		if json.Unmarshal(body, &eb) == nil {
+			apiErr.RequestID = eb.RequestID
+			if eb.Error != "" {
+				apiErr.Message = eb.Error
+			} else if eb.Message != "" {
+				apiErr.Message = eb.Message
+			}
+		}
+
+		if !apiErr.retryable() {
+			return -1, apiErr
+		}
+		return retryAfter(resp.Header.Get("Retry-After")), apiErr
+	}
+
+	if err = json.Unmarshal(body, out); err != nil {
+		return -1, fmt.Errorf("%v: %w", err, ErrBadResponse)
+	}
+
+	var eb errorBody
+	if json.Unmarshal(body, &eb) == nil && eb.Status == "ERROR" {
+		return -1, &APIError{StatusCode: resp.StatusCode, RequestID: eb.RequestID, Message: eb.Error}
+	}
+	return 0, nil
+}
+
+// retryAfter reads a Retry-After header given in seconds, returning 0 if there isn't a usable one.
+func retryAfter(header string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// IsRetryable reports whether err is worth trying again later, as opposed to a bad request, key or symbol.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUnavailable)
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package polygon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+const testKey = "test-key"
+
+// serve starts a stand-in for Polygon answering with handler, and returns a client for it that retries quickly.
+// The trailing slash on the endpoint checks NewClient trims it.
+func serve(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := NewClient(config.StocksConfig{E: srv.URL + "/", Key: testKey})
+	c.Backoff = time.Millisecond
+	return c
+}
+
+// route answers path with body, and fails the test on any other path or without the key.
+func route(t *testing.T, routes map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer "+testKey {
+			t.Errorf("%v sent Authorization %q", r.URL.Path, got)
+		}
+		body, ok := routes[r.URL.EscapedPath()]
+		if !ok {
+			t.Errorf("unexpected request for %v", r.URL.EscapedPath())
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}
+}
+
+func mustSymbol(t *testing.T, code string) utils.OptionSymbol {
+	t.Helper()
+	symbol, err := utils.ParseOptionSymbol(code)
+	if err != nil {
+		t.Fatalf("ParseOptionSymbol(%q): %v", code, err)
+	}
+	return symbol
+}
+
+func TestNewClient(t *testing.T) {
+	if c := NewClient(config.StocksConfig{Key: testKey}); c.BaseURL != DefaultBaseURL || c.Key != testKey {
+		t.Errorf("without an endpoint got BaseURL %q and Key %q", c.BaseURL, c.Key)
+	}
+	if c := NewClient(config.StocksConfig{E: "http://example.test/"}); c.BaseURL != "http://example.test" {
+		t.Errorf("BaseURL %q kept its trailing slash", c.BaseURL)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	c := serve(t, route(t, map[string]string{
+		"/v2/last/trade/AAPL":                             `{"status":"OK","results":{"p":150.25}}`,
+		"/v1/last/crypto/BTC/USD":                         `{"status":"success","symbol":"BTC-USD","last":{"price":19999.5}}`,
+		"/v3/snapshot/options/AAPL/O:AAPL261218C00150000": `{"status":"OK","results":{"day":{"close":2.5},"underlying_asset":{"price":151}}}`,
+		"/v3/snapshot/options/MSFT/O:MSFT261218P00300000": `{"status":"OK","results":{"day":{"close":4}}}`,
+		"/v2/last/trade/MSFT":                             `{"status":"OK","results":{"p":305.5}}`,
+	}))
+	ctx := context.Background()
+
+	quote, err := c.LastQuote(ctx, "AAPL")
+	if err != nil || quote.Results.P != 150.25 {
+		t.Errorf("LastQuote(AAPL) = %+v, %v", quote, err)
+	}
+
+	trade, err := c.LastCryptoTrade(ctx, "BTC", "USD")
+	if err != nil || trade.Last.Price != 19999.5 {
+		t.Errorf("LastCryptoTrade(BTC, USD) = %+v, %v", trade, err)
+	}
+
+	snap, err := c.OptionSnapshot(ctx, mustSymbol(t, "AAPL261218C00150000"))
+	if err != nil || snap.Results.Day.Close != 2.5 {
+		t.Errorf("OptionSnapshot = %+v, %v", snap, err)
+	}
+
+	price, err := c.UnderlyingPrice(ctx, mustSymbol(t, "AAPL261218C00150000"))
+	if err != nil || price != 151 {
+		t.Errorf("UnderlyingPrice from the snapshot = %v, %v, want 151", price, err)
+	}
+	price, err = c.UnderlyingPrice(ctx, mustSymbol(t, "MSFT261218P00300000"))
+	if err != nil || price != 305.5 {
+		t.Errorf("UnderlyingPrice from the last trade = %v, %v, want 305.5", price, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusUnauthorized, `{"status":"ERROR","error":"bad key"}`, ErrUnauthorized},
+		{http.StatusForbidden, `{"status":"NOT_AUTHORIZED","message":"upgrade your plan"}`, ErrUnauthorized},
+		{http.StatusNotFound, ``, ErrNotFound},
+		{http.StatusBadRequest, `{"status":"ERROR","request_id":"abc","error":"bad ticker"}`, ErrBadResponse},
+	} {
+		var calls int32
+		client := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(c.status)
+			fmt.Fprint(w, c.body)
+		})
+
+		_, err := client.LastQuote(context.Background(), "AAPL")
+		if !errors.Is(err, c.want) {
+			t.Errorf("a %v came back as %v, want %v", c.status, err, c.want)
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != c.status {
+			t.Errorf("a %v came back as %v, want an APIError for it", c.status, err)
+		}
+		if IsRetryable(err) || atomic.LoadInt32(&calls) != 1 {
+			t.Errorf("a %v was tried %d times, want once", c.status, calls)
+		}
+	}
+
+	client := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ERROR","request_id":"abc","error":"unknown"}`)
+	})
+	_, err := client.LastQuote(context.Background(), "AAPL")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RequestID != "abc" || apiErr.Message != "unknown" {
+		t.Errorf("a 200 with an ERROR status came back as %v", err)
+	}
+
+	client = serve(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `not json`)
+	})
+	if _, err = client.LastQuote(context.Background(), "AAPL"); !errors.Is(err, ErrBadResponse) {
+		t.Errorf("a body that isn't JSON came back as %v, want %v", err, ErrBadResponse)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	t.Run("5xx until exhausted", func(t *testing.T) {
+		var calls int32
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		})
+
+		_, err := c.LastCryptoTrade(context.Background(), "BTC", "USD")
+		if !errors.Is(err, ErrUnavailable) || !IsRetryable(err) {
+			t.Errorf("got %v, want %v", err, ErrUnavailable)
+		}
+		if got, want := atomic.LoadInt32(&calls), int32(c.Retries+1); got != want {
+			t.Errorf("tried %d times, want %d", got, want)
+		}
+	})
+
+	t.Run("5xx then success", func(t *testing.T) {
+		var calls int32
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"status":"OK","results":{"p":1}}`)
+		})
+
+		if _, err := c.LastQuote(context.Background(), "AAPL"); err != nil {
+			t.Errorf("got %v after two 503s, want the third try to succeed", err)
+		}
+	})
+
+	t.Run("429 honours Retry-After", func(t *testing.T) {
+		var calls int32
+		var first time.Time
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				first = time.Now()
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if waited := time.Since(first); waited < time.Second {
+				t.Errorf("retried after %v, before Retry-After was up", waited)
+			}
+			fmt.Fprint(w, `{"status":"OK","results":{"p":1}}`)
+		})
+
+		if _, err := c.LastQuote(context.Background(), "AAPL"); err != nil {
+			t.Errorf("got %v, want the retry to succeed", err)
+		}
+		if got := atomic.LoadInt32(&calls); got != 2 {
+			t.Errorf("tried %d times, want 2", got)
+		}
+	})
+
+	t.Run("429 until exhausted", func(t *testing.T) {
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+		c.Retries = 1
+
+		_, err := c.LastQuote(context.Background(), "AAPL")
+		if !errors.Is(err, ErrRateLimited) || !IsRetryable(err) {
+			t.Errorf("got %v, want %v", err, ErrRateLimited)
+		}
+	})
+
+	t.Run("429 fails fast", func(t *testing.T) {
+		var calls int32
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+		c = c.FailFast()
+
+		_, err := c.LastQuote(context.Background(), "AAPL")
+		if !errors.Is(err, ErrRateLimited) {
+			t.Errorf("got %v, want %v", err, ErrRateLimited)
+		}
+		if got := atomic.LoadInt32(&calls); got != 1 {
+			t.Errorf("tried %d times, want once", got)
+		}
+	})
+
+	t.Run("5xx still retried when failing fast on 429s", func(t *testing.T) {
+		var calls int32
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+		c = c.FailFast()
+
+		if _, err := c.LastQuote(context.Background(), "AAPL"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("got %v, want %v", err, ErrUnavailable)
+		}
+		if got, want := atomic.LoadInt32(&calls), int32(c.Retries+1); got != want {
+			t.Errorf("tried %d times, want %d", got, want)
+		}
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		c := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := c.LastQuote(ctx, "AAPL"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	for header, want := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		" 2 ":                           2 * time.Second,
+		"0":                             0,
+		"-1":                            0,
+		"Wed, 21 Oct 2026 07:28:00 GMT": 0,
+	} {
+		if got := retryAfter(header); got != want {
+			t.Errorf("retryAfter(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
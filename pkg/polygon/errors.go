@@ -0,0 +1,64 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package polygon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrUnauthorized = errors.New("UNAUTHORIZED")
+	ErrNotFound     = errors.New("NOT FOUND")
+	ErrRateLimited  = errors.New("RATE LIMITED")
+	ErrUnavailable  = errors.New("UNAVAILABLE")
+	ErrBadResponse  = errors.New("BAD RESPONSE")
+)
+
+// APIError is a response Polygon answered with an error. It unwraps to the sentinel for its status code, so callers
+// can test for ErrRateLimited and the like with errors.Is.
+type APIError struct {
+	StatusCode int
+	RequestID  string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("polygon returned %v (request %v): %v", e.StatusCode, e.RequestID, e.Message)
+	}
+	return fmt.Sprintf("polygon returned %v: %v", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrUnavailable
+	}
+	return ErrBadResponse
+}
+
+// retryable reports whether trying the same request again could succeed.
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
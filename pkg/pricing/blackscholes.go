@@ -0,0 +1,235 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrInvalidInput = errors.New("INVALID PRICING INPUT")
+	ErrExpired      = errors.New("OPTION EXPIRED")
+	ErrNoSolution   = errors.New("NO IMPLIED VOLATILITY")
+)
+
+const (
+	daysPerYear = 365
+
+	minVol   = 1e-4
+	maxVol   = 5.0
+	volTol   = 1e-6
+	maxIters = 100
+)
+
+// Inputs are what Black-Scholes prices a European option from. Years is the time left to expiry, Rate the
+// continuously compounded risk-free rate and Vol the annualised volatility, both as fractions (0.05 is 5%).
+type Inputs struct {
+	Spot   float64
+	Strike float64
+	Years  float64
+	Rate   float64
+	Vol    float64
+	Put    bool
+}
+
+// Greeks is an option's theoretical value and its sensitivities. Theta is per calendar day, Vega and Rho per
+// percentage point of volatility and rate, so each reads as dollars per share.
+type Greeks struct {
+	Value float64
+	Delta float64
+	Gamma float64
+	Theta float64
+	Vega  float64
+	Rho   float64
+}
+
+// YearsBetween is the time from now to expiry in years, or 0 if it has passed.
+func YearsBetween(now, expiry time.Time) float64 {
+	d := expiry.Sub(now)
+	if d <= 0 {
+		return 0
+	}
+	return d.Hours() / 24 / daysPerYear
+}
+
+func (in Inputs) check() error {
+	if in.Spot <= 0 || in.Strike <= 0 || in.Years < 0 || in.Vol < 0 || math.IsNaN(in.Rate) || math.IsInf(in.Rate, 0) {
+		return fmt.Errorf("spot %v, strike %v, years %v, vol %v, rate %v : %w", in.Spot, in.Strike, in.Years, in.Vol, in.Rate, ErrInvalidInput)
+	}
+	return nil
+}
+
+func (in Inputs) intrinsic() float64 {
+	if in.Put {
+		return math.Max(in.Strike-in.Spot, 0)
+	}
+	return math.Max(in.Spot-in.Strike, 0)
+}
+
+func cdf(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func pdf(x float64) float64 {
+	return math.Exp(-x*x/2) / math.Sqrt(2*math.Pi)
+}
+
+// Price prices in with Black-Scholes. At or past expiry, or with no volatility, the option is worth its
+// (discounted) intrinsic value and has only a delta.
+func Price(in Inputs) (Greeks, error) {
+	if err := in.check(); err != nil {
+		return Greeks{}, err
+	}
+
+	if in.Years == 0 || in.Vol == 0 {
+		return degenerate(in), nil
+	}
+
+	sqrtT := math.Sqrt(in.Years)
+	volT := in.Vol * sqrtT
+	d1 := (math.Log(in.Spot/in.Strike) + (in.Rate+in.Vol*in.Vol/2)*in.Years) / volT
+	d2 := d1 - volT
+	df := math.Exp(-in.Rate * in.Years)
+
+	g := Greeks{
+		Gamma: pdf(d1) / (in.Spot * volT),
+		Vega:  in.Spot * pdf(d1) * sqrtT / 100,
+	}
+	decay := -in.Spot * pdf(d1) * in.Vol / (2 * sqrtT)
+	if in.Put {
+		g.Value = in.Strike*df*cdf(-d2) - in.Spot*cdf(-d1)
+		g.Delta = cdf(d1) - 1
+		g.Theta = (decay + in.Rate*in.Strike*df*cdf(-d2)) / daysPerYear
+		g.Rho = -in.Strike * in.Years * df * cdf(-d2) / 100
+	} else {
+		g.Value = in.Spot*cdf(d1) - in.Strike*df*cdf(d2)
+		g.Delta = cdf(d1)
+		g.Theta = (decay - in.Rate*in.Strike*df*cdf(d2)) / daysPerYear
+		g.Rho = in.Strike * in.Years * df * cdf(d2) / 100
+	}
+	return g, nil
+}
+
+// degenerate prices an option with no time or volatility left: it will certainly finish at its intrinsic value.
+func degenerate(in Inputs) Greeks {
+	forward := in.Spot * math.Exp(in.Rate*in.Years)
+	df := math.Exp(-in.Rate * in.Years)
+
+	g := Greeks{}
+	switch {
+	case !in.Put && forward > in.Strike:
+		g.Value = in.Spot - in.Strike*df
+		g.Delta = 1
+	case in.Put && forward < in.Strike:
+		g.Value = in.Strike*df - in.Spot
+		g.Delta = -1
+	}
+	return g
+}
+
+// bounds are the no-arbitrage limits on in's premium, whatever the volatility.
+func (in Inputs) bounds() (lower, upper float64) {
+	df := math.Exp(-in.Rate * in.Years)
+	if in.Put {
+		return math.Max(in.Strike*df-in.Spot, 0), in.Strike * df
+	}
+	return math.Max(in.Spot-in.Strike*df, 0), in.Spot
+}
+
+// ImpliedVol is the volatility at which in (ignoring in.Vol) is worth premium. It uses Newton's method, falling
+// back to bisection where vega is too flat for that to converge, and returns ErrNoSolution for a premium outside
+// what any volatility could explain.
+func ImpliedVol(in Inputs, premium float64) (float64, error) {
+	if err := in.check(); err != nil {
+		return 0, err
+	}
+	if in.Years == 0 {
+		return 0, fmt.Errorf("unable to imply volatility at expiry : %w", ErrExpired)
+	}
+
+	lower, upper := in.bounds()
+	if premium <= lower || premium >= upper {
+		return 0, fmt.Errorf("premium %v is outside %v to %v : %w", premium, lower, upper, ErrNoSolution)
+	}
+
+	for _, edge := range []float64{minVol, maxVol} {
+		in.Vol = edge
+		g, err := Price(in)
+		if err != nil {
+			return 0, err
+		}
+		if (edge == minVol && g.Value > premium) || (edge == maxVol && g.Value < premium) {
+			return 0, fmt.Errorf("premium %v needs a volatility outside %v to %v : %w", premium, minVol, maxVol, ErrNoSolution)
+		}
+	}
+
+	lo, hi := minVol, maxVol
+	vol := math.Sqrt(2 * math.Abs(math.Log(in.Spot/in.Strike)+in.Rate*in.Years) / in.Years)
+	if vol < 0.1 || vol > 2 {
+		vol = 0.5
+	}
+
+	for i := 0; i < maxIters; i++ {
+		in.Vol = vol
+		g, err := Price(in)
+		if err != nil {
+			return 0, err
+		}
+
+		diff := g.Value - premium
+		if math.Abs(diff) < volTol {
+			return vol, nil
+		}
+		if diff > 0 {
+			hi = vol
+		} else {
+			lo = vol
+		}
+
+		next := vol - diff/(g.Vega*100)
+		if g.Vega <= 0 || next <= lo || next >= hi || math.IsNaN(next) {
+			next = (lo + hi) / 2
+		}
+		if hi-lo < volTol*volTol {
+			return next, nil
+		}
+		vol = next
+	}
+	return 0, fmt.Errorf("premium %v did not converge : %w", premium, ErrNoSolution)
+}
+
+// Decay projects in's value at the end of each of the next days calendar days, all else being equal, stopping
+// at expiry.
+func Decay(in Inputs, days int) ([]float64, error) {
+	values := make([]float64, 0, days)
+	for i := 1; i <= days; i++ {
+		at := in
+		at.Years = math.Max(in.Years-float64(i)/daysPerYear, 0)
+
+		g, err := Price(at)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, g.Value)
+		if at.Years == 0 {
+			break
+		}
+	}
+	return values, nil
+}
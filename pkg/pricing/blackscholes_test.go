@@ -0,0 +1,126 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package pricing
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func near(t *testing.T, what string, got, want, tol float64) {
+	t.Helper()
+	if math.Abs(got-want) > tol {
+		t.Errorf("%v = %.6f, want %.6f ± %v", what, got, want, tol)
+	}
+}
+
+func TestPriceReferenceValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    Inputs
+		value float64
+		delta float64
+	}{
+		{"at the money call", Inputs{Spot: 100, Strike: 100, Years: 1, Rate: 0.05, Vol: 0.2}, 10.4506, 0.6368},
+		{"at the money put", Inputs{Spot: 100, Strike: 100, Years: 1, Rate: 0.05, Vol: 0.2, Put: true}, 5.5735, -0.3632},
+		{"in the money call", Inputs{Spot: 42, Strike: 40, Years: 0.5, Rate: 0.1, Vol: 0.2}, 4.7594, 0.7791},
+		{"out of the money put", Inputs{Spot: 42, Strike: 40, Years: 0.5, Rate: 0.1, Vol: 0.2, Put: true}, 0.8086, -0.2209},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Price(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			near(t, "value", g.Value, tt.value, 1e-4)
+			near(t, "delta", g.Delta, tt.delta, 1e-4)
+		})
+	}
+}
+
+func TestPriceGreeks(t *testing.T) {
+	call, err := Price(Inputs{Spot: 100, Strike: 100, Years: 1, Rate: 0.05, Vol: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	near(t, "gamma", call.Gamma, 0.018762, 1e-6)
+	near(t, "vega", call.Vega, 0.375240, 1e-6)
+	near(t, "theta", call.Theta, -6.414028/daysPerYear, 1e-6)
+	near(t, "rho", call.Rho, 0.532325, 1e-6)
+
+	put, err := Price(Inputs{Spot: 100, Strike: 100, Years: 1, Rate: 0.05, Vol: 0.2, Put: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	near(t, "put-call parity", call.Value-put.Value, 100-100*math.Exp(-0.05), 1e-9)
+	near(t, "put gamma", put.Gamma, call.Gamma, 1e-12)
+	near(t, "put vega", put.Vega, call.Vega, 1e-12)
+}
+
+func TestPriceAtExpiry(t *testing.T) {
+	g, err := Price(Inputs{Spot: 110, Strike: 100, Rate: 0.05, Vol: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Value != 10 || g.Delta != 1 || g.Gamma != 0 || g.Vega != 0 {
+		t.Errorf("expired call 10 in the money priced at %+v, want its intrinsic value and a delta of 1", g)
+	}
+
+	if _, err := Price(Inputs{Spot: 0, Strike: 100, Years: 1}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("pricing with no spot returned %v, want %v", err, ErrInvalidInput)
+	}
+}
+
+func TestImpliedVolRoundTrip(t *testing.T) {
+	for _, put := range []bool{false, true} {
+		for _, strike := range []float64{60, 90, 100, 110, 150} {
+			for _, vol := range []float64{0.05, 0.2, 0.6, 1.5} {
+				in := Inputs{Spot: 100, Strike: strike, Years: 0.25, Rate: 0.04, Vol: vol, Put: put}
+				g, err := Price(in)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lower, upper := in.bounds()
+				if g.Value-lower < 1e-4 || upper-g.Value < 1e-4 {
+					continue // too close to a bound for the premium to say anything about volatility
+				}
+
+				got, err := ImpliedVol(in, g.Value)
+				if err != nil {
+					t.Errorf("put %v, strike %v, vol %v: %v", put, strike, vol, err)
+					continue
+				}
+				near(t, "implied vol", got, vol, 1e-4)
+			}
+		}
+	}
+}
+
+func TestImpliedVolNoSolution(t *testing.T) {
+	in := Inputs{Spot: 100, Strike: 90, Years: 0.5, Rate: 0.05}
+	lower, upper := in.bounds()
+	for _, premium := range []float64{lower - 0.01, upper + 0.01} {
+		if _, err := ImpliedVol(in, premium); !errors.Is(err, ErrNoSolution) {
+			t.Errorf("premium %v outside %v to %v returned %v, want %v", premium, lower, upper, err, ErrNoSolution)
+		}
+	}
+
+	in.Years = 0
+	if _, err := ImpliedVol(in, 10); !errors.Is(err, ErrExpired) {
+		t.Errorf("implying volatility at expiry returned %v, want %v", err, ErrExpired)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/db"
+	"github.com/m1k8/harpe/pkg/market"
+	"github.com/m1k8/harpe/pkg/types"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+var calendar = market.NYSE()
+
+// Analysis is an option priced at the volatility its premium implies.
+type Analysis struct {
+	Inputs
+	Greeks
+	Premium float64
+}
+
+// Expiry is when a contract stops trading: the close on its expiration date, or on the session before it if the
+// market is shut that day.
+func Expiry(symbol utils.OptionSymbol) time.Time {
+	y, m, d := symbol.Expiration.Date()
+	return calendar.LastClose(time.Date(y, m, d, 0, 0, 0, 0, calendar.Location()))
+}
+
+func symbolInputs(symbol utils.OptionSymbol, underlying, rate float64, now time.Time) Inputs {
+	return Inputs{
+		Spot:   underlying,
+		Strike: symbol.StrikePrice(),
+		Years:  YearsBetween(now, Expiry(symbol)),
+		Rate:   rate,
+		Put:    symbol.Put,
+	}
+}
+
+// OptionInputs are the inputs for an option alert's contract at now, with its underlying at underlying and no
+// volatility yet.
+func OptionInputs(o db.Option, underlying, rate float64, now time.Time) (Inputs, error) {
+	symbol, err := o.Symbol()
+	if err != nil {
+		return Inputs{}, err
+	}
+	return symbolInputs(symbol, underlying, rate, now), nil
+}
+
+func analyze(in Inputs, premium float64) (Analysis, error) {
+	if in.Years == 0 {
+		return Analysis{}, fmt.Errorf("unable to price an option that expired : %w", ErrExpired)
+	}
+
+	vol, err := ImpliedVol(in, premium)
+	if err != nil {
+		return Analysis{}, err
+	}
+	in.Vol = vol
+
+	g, err := Price(in)
+	if err != nil {
+		return Analysis{}, err
+	}
+	return Analysis{Inputs: in, Greeks: g, Premium: premium}, nil
+}
+
+// Analyze prices an option alert's contract at now from its underlying and premium, implying the volatility.
+func Analyze(o db.Option, underlying, premium, rate float64, now time.Time) (Analysis, error) {
+	in, err := OptionInputs(o, underlying, rate, now)
+	if err != nil {
+		return Analysis{}, err
+	}
+	return analyze(in, premium)
+}
+
+// AnalyzeSnapshot prices a contract from its Polygon snapshot, using the quote midpoint as the premium. Where
+// Polygon has an implied volatility the contract is priced at it, keeping Polygon's greeks if it has those too;
+// otherwise the volatility is implied locally. If that fails, Polygon's greeks are used on their own, valued at
+// the premium, so the figures always come from one model rather than a mix.
+func AnalyzeSnapshot(snap *types.Snapshot, rate float64, now time.Time) (Analysis, error) {
+	r := snap.Results
+	symbol, err := utils.ParseOptionSymbol(r.Details.Ticker)
+	if err != nil {
+		return Analysis{}, err
+	}
+
+	premium := r.LastQuote.Midpoint
+	if premium <= 0 {
+		premium = r.Day.Close
+	}
+
+	in := symbolInputs(symbol, r.UnderlyingAsset.Price, rate, now)
+	if in.Years == 0 {
+		return Analysis{}, fmt.Errorf("unable to price an option that expired : %w", ErrExpired)
+	}
+
+	sg := r.Greeks
+	hasGreeks := sg.Delta != 0 || sg.Gamma != 0 || sg.Theta != 0 || sg.Vega != 0
+
+	if r.ImpliedVolatility > 0 {
+		in.Vol = r.ImpliedVolatility
+		g, err := Price(in)
+		if err != nil {
+			return Analysis{}, err
+		}
+		if hasGreeks {
+			g.Delta, g.Gamma, g.Theta, g.Vega = sg.Delta, sg.Gamma, sg.Theta, sg.Vega
+		}
+		return Analysis{Inputs: in, Greeks: g, Premium: premium}, nil
+	}
+
+	a, err := analyze(in, premium)
+	if err == nil || !hasGreeks {
+		return a, err
+	}
+	g := Greeks{Value: premium, Delta: sg.Delta, Gamma: sg.Gamma, Theta: sg.Theta, Vega: sg.Vega}
+	return Analysis{Inputs: in, Greeks: g, Premium: premium}, nil
+}
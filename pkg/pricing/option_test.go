@@ -0,0 +1,127 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/types"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+func TestExpiry(t *testing.T) {
+	ny := calendar.Location()
+	tests := []struct {
+		name   string
+		symbol string
+		want   time.Time
+	}{
+		{"regular friday", "AAPL261016C00150000", time.Date(2026, 10, 16, 16, 0, 0, 0, ny)},
+		{"early close", "AAPL261127C00150000", time.Date(2026, 11, 27, 13, 0, 0, 0, ny)},
+		{"good friday", "AAPL270326C00150000", time.Date(2027, 3, 25, 16, 0, 0, 0, ny)},
+		{"observed independence day", "AAPL260703C00150000", time.Date(2026, 7, 2, 16, 0, 0, 0, ny)},
+		{"saturday", "AAPL261017C00150000", time.Date(2026, 10, 16, 16, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbol, err := utils.ParseOptionSymbol(tt.symbol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Expiry(symbol); !got.Equal(tt.want) {
+				t.Errorf("Expiry(%v) = %v, want %v", tt.symbol, got, tt.want)
+			}
+		})
+	}
+}
+
+// snapshot is a call struck at 100 expiring on 2027-10-15, with the underlying at 100 and a premium of 10.
+func snapshot() *types.Snapshot {
+	snap := &types.Snapshot{}
+	snap.Results.Details.Ticker = "O:AAPL271015C00100000"
+	snap.Results.UnderlyingAsset.Price = 100
+	snap.Results.LastQuote.Midpoint = 10
+	return snap
+}
+
+var now = time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC)
+
+func TestAnalyzeSnapshotImpliesVolatility(t *testing.T) {
+	a, err := AnalyzeSnapshot(snapshot(), 0.05, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	near(t, "value", a.Value, 10, 1e-4)
+	if a.Vol <= 0 || a.Premium != 10 {
+		t.Errorf("analysis %+v should imply a volatility from a premium of 10", a)
+	}
+}
+
+func TestAnalyzeSnapshotUsesPolygonVolatility(t *testing.T) {
+	snap := snapshot()
+	snap.Results.ImpliedVolatility = 0.3
+
+	a, err := AnalyzeSnapshot(snap, 0.05, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := Price(a.Inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Vol != 0.3 || a.Greeks != g {
+		t.Errorf("analysis %+v should be priced at Polygon's volatility of 0.3, giving %+v", a, g)
+	}
+
+	snap.Results.Greeks.Delta, snap.Results.Greeks.Gamma, snap.Results.Greeks.Theta, snap.Results.Greeks.Vega = 0.5, 0.01, -0.02, 0.4
+	a, err = AnalyzeSnapshot(snap, 0.05, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Vol != 0.3 || a.Delta != 0.5 || a.Gamma != 0.01 || a.Theta != -0.02 || a.Vega != 0.4 || a.Value != g.Value {
+		t.Errorf("analysis %+v should keep Polygon's volatility and greeks", a)
+	}
+}
+
+func TestAnalyzeSnapshotFallsBackToPolygonGreeks(t *testing.T) {
+	snap := snapshot()
+	snap.Results.LastQuote.Midpoint = 150 // more than the underlying, so no volatility explains it
+
+	if _, err := AnalyzeSnapshot(snap, 0.05, now); !errors.Is(err, ErrNoSolution) {
+		t.Fatalf("unexplainable premium with no greeks returned %v, want %v", err, ErrNoSolution)
+	}
+
+	snap.Results.Greeks.Delta, snap.Results.Greeks.Gamma = 0.9, 0.002
+	a, err := AnalyzeSnapshot(snap, 0.05, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Vol != 0 || a.Value != 150 || a.Delta != 0.9 || a.Gamma != 0.002 || a.Theta != 0 || a.Rho != 0 {
+		t.Errorf("analysis %+v should be Polygon's greeks alone, valued at the premium", a)
+	}
+}
+
+func TestAnalyzeSnapshotExpired(t *testing.T) {
+	snap := snapshot()
+	snap.Results.Details.Ticker = "O:AAPL261009C00100000"
+	snap.Results.ImpliedVolatility = 0.3
+
+	if _, err := AnalyzeSnapshot(snap, 0.05, now); !errors.Is(err, ErrExpired) {
+		t.Errorf("analysing an expired contract returned %v, want %v", err, ErrExpired)
+	}
+}
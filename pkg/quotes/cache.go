@@ -0,0 +1,179 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package quotes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+type cacheEntry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	at    time.Time
+	// cancelled is set when the fetch failed because the context of whoever was fetching ended.
+	cancelled bool
+}
+
+// Cache remembers each answer from Provider for TTL, and has concurrent callers asking for the same thing while it is
+// being fetched wait for that one request. Errors are shared with whoever was waiting but never kept, except that a
+// fetch cut short by its caller's context going is tried again by those still waiting rather than failing them too.
+// Expired answers are swept out at most once a TTL, as new ones come in.
+type Cache struct {
+	Provider QuoteProvider
+	TTL      time.Duration
+	Clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	swept   time.Time
+}
+
+// NewCache caches p for ttl.
+func NewCache(p QuoteProvider, ttl time.Duration) *Cache {
+	return &Cache{
+		Provider: p,
+		TTL:      ttl,
+		Clock:    clock.System(),
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+func (c *Cache) Name() string {
+	return c.Provider.Name()
+}
+
+// fresh reports whether e has been fetched, successfully, within TTL of now. Callers hold c.mu.
+func (c *Cache) fresh(e *cacheEntry, now time.Time) bool {
+	select {
+	case <-e.done:
+		return e.err == nil && now.Sub(e.at) < c.TTL
+	default:
+		return false
+	}
+}
+
+// sweep drops every expired entry, if it hasn't in the last TTL. Callers hold c.mu.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.TTL {
+		return
+	}
+	c.swept = now
+
+	for k, e := range c.entries {
+		select {
+		case <-e.done:
+			if !c.fresh(e, now) {
+				delete(c.entries, k)
+			}
+		default:
+		}
+	}
+}
+
+func (c *Cache) get(ctx context.Context, key string, fetch func() (interface{}, error)) (interface{}, error) {
+	for {
+		now := clock.OrSystem(c.Clock).Now()
+
+		c.mu.Lock()
+		if c.entries == nil {
+			c.entries = make(map[string]*cacheEntry)
+		}
+		e, ok := c.entries[key]
+		if ok && c.fresh(e, now) {
+			c.mu.Unlock()
+			return e.value, nil
+		}
+		if ok {
+			select {
+			case <-e.done:
+				ok = false
+			default: // someone is fetching it
+			}
+		}
+		if !ok {
+			c.sweep(now)
+			e = &cacheEntry{done: make(chan struct{})}
+			c.entries[key] = e
+			c.mu.Unlock()
+
+			e.value, e.err = fetch()
+			e.at = clock.OrSystem(c.Clock).Now()
+			e.cancelled = e.err != nil && ctx.Err() != nil
+
+			c.mu.Lock()
+			if e.err != nil && c.entries[key] == e {
+				delete(c.entries, key)
+			}
+			close(e.done)
+			c.mu.Unlock()
+			return e.value, e.err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.done:
+		}
+		if !e.cancelled {
+			return e.value, e.err
+		}
+		// The fetch died with its caller; ours is still alive, so fetch it ourselves.
+	}
+}
+
+// Purge forgets everything cached.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		select {
+		case <-e.done:
+			delete(c.entries, k)
+		default:
+		}
+	}
+}
+
+func (c *Cache) Stock(ctx context.Context, ticker string) (Quote, error) {
+	v, err := c.get(ctx, "stock:"+ticker, func() (interface{}, error) {
+		return c.Provider.Stock(ctx, ticker)
+	})
+	q, _ := v.(Quote)
+	return q, err
+}
+
+func (c *Cache) Option(ctx context.Context, symbol utils.OptionSymbol) (OptionQuote, error) {
+	v, err := c.get(ctx, "option:"+symbol.String(), func() (interface{}, error) {
+		return c.Provider.Option(ctx, symbol)
+	})
+	q, _ := v.(OptionQuote)
+	return q, err
+}
+
+func (c *Cache) Crypto(ctx context.Context, coin string) (Quote, error) {
+	v, err := c.get(ctx, "crypto:"+coin, func() (interface{}, error) {
+		return c.Provider.Crypto(ctx, coin)
+	})
+	q, _ := v.(Quote)
+	return q, err
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package quotes
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// counting prices every stock at 100 plus the number of calls so far. When gate is set, each call waits for it,
+// or for its context to go.
+type counting struct {
+	calls   int32
+	started chan struct{}
+	gate    chan struct{}
+	err     error
+}
+
+func (p *counting) Name() string {
+	return "counting"
+}
+
+func (p *counting) Stock(ctx context.Context, ticker string) (Quote, error) {
+	n := atomic.AddInt32(&p.calls, 1)
+	if p.started != nil {
+		p.started <- struct{}{}
+	}
+	if p.gate != nil {
+		select {
+		case <-p.gate:
+		case <-ctx.Done():
+			return Quote{}, ctx.Err()
+		}
+	}
+	if p.err != nil {
+		return Quote{}, p.err
+	}
+	return Quote{Symbol: ticker, Price: 100 + float64(n), Source: p.Name()}, nil
+}
+
+func (p *counting) Option(ctx context.Context, symbol utils.OptionSymbol) (OptionQuote, error) {
+	return OptionQuote{}, ErrUnsupported
+}
+
+func (p *counting) Crypto(ctx context.Context, coin string) (Quote, error) {
+	return p.Stock(ctx, coin)
+}
+
+var start = time.Date(2026, time.October, 14, 15, 0, 0, 0, time.UTC)
+
+func TestCacheTTL(t *testing.T) {
+	p := &counting{}
+	fake := clock.NewFake(start)
+	c := NewCache(p, time.Minute)
+	c.Clock = fake
+	ctx := context.Background()
+
+	q, err := c.Stock(ctx, "AAPL")
+	if err != nil || q.Price != 101 {
+		t.Fatalf("Stock = %+v, %v", q, err)
+	}
+	fake.Advance(59 * time.Second)
+	if q, _ = c.Stock(ctx, "AAPL"); q.Price != 101 {
+		t.Errorf("within the TTL got %v, want the cached 101", q.Price)
+	}
+	if q, _ = c.Crypto(ctx, "AAPL"); q.Price != 102 {
+		t.Errorf("crypto got %v, want it cached apart from the stock", q.Price)
+	}
+	fake.Advance(time.Second)
+	if q, _ = c.Stock(ctx, "AAPL"); q.Price != 103 {
+		t.Errorf("after the TTL got %v, want a fresh 103", q.Price)
+	}
+}
+
+func TestCacheKeepsNoErrors(t *testing.T) {
+	p := &counting{err: ErrRateLimited}
+	c := NewCache(p, time.Minute)
+	c.Clock = clock.NewFake(start)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Stock(context.Background(), "AAPL"); !errors.Is(err, ErrRateLimited) {
+			t.Errorf("Stock = %v, want %v", err, ErrRateLimited)
+		}
+	}
+	if atomic.LoadInt32(&p.calls) != 2 {
+		t.Errorf("asked the provider %d times, want the error never cached", atomic.LoadInt32(&p.calls))
+	}
+}
+
+func TestCacheCollapses(t *testing.T) {
+	p := &counting{started: make(chan struct{}, 1), gate: make(chan struct{})}
+	c := NewCache(p, time.Minute)
+	c.Clock = clock.NewFake(start)
+
+	prices := make(chan float64, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q, err := c.Stock(context.Background(), "AAPL")
+			if err != nil {
+				t.Errorf("Stock: %v", err)
+			}
+			prices <- q.Price
+		}()
+	}
+	<-p.started
+	time.Sleep(10 * time.Millisecond) // let the rest queue up behind the fetch
+	close(p.gate)
+	wg.Wait()
+	close(prices)
+
+	for price := range prices {
+		if price != 101 {
+			t.Errorf("got %v, want every caller to share 101", price)
+		}
+	}
+	if atomic.LoadInt32(&p.calls) != 1 {
+		t.Errorf("asked the provider %d times, want once", atomic.LoadInt32(&p.calls))
+	}
+}
+
+func TestCacheFetcherCancelled(t *testing.T) {
+	p := &counting{started: make(chan struct{}, 2), gate: make(chan struct{})}
+	c := NewCache(p, time.Minute)
+	c.Clock = clock.NewFake(start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fetched := make(chan error, 1)
+	go func() {
+		_, err := c.Stock(ctx, "AAPL")
+		fetched <- err
+	}()
+	<-p.started
+
+	waited := make(chan Quote, 1)
+	go func() {
+		q, err := c.Stock(context.Background(), "AAPL")
+		if err != nil {
+			t.Errorf("the waiter got %v, want it to fetch for itself", err)
+		}
+		waited <- q
+	}()
+	time.Sleep(10 * time.Millisecond) // let the waiter queue up behind the fetch
+
+	cancel()
+	if err := <-fetched; !errors.Is(err, context.Canceled) {
+		t.Errorf("the cancelled fetcher got %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-p.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiter took the cancelled fetch's error instead of fetching again")
+	}
+	close(p.gate)
+
+	select {
+	case q := <-waited:
+		if q.Price != 102 {
+			t.Errorf("the waiter got %v, want its own fetch's 102", q.Price)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiter never got an answer")
+	}
+}
+
+func TestCacheSweeps(t *testing.T) {
+	fake := clock.NewFake(start)
+	c := NewCache(&counting{}, time.Minute)
+	c.Clock = fake
+	ctx := context.Background()
+
+	for _, ticker := range []string{"AAPL", "MSFT", "TSLA"} {
+		c.Stock(ctx, ticker)
+	}
+	fake.Advance(2 * time.Minute)
+	c.Stock(ctx, "GME")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) != 1 {
+		t.Errorf("%d entries after the others expired, want only GME", len(c.entries))
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// Chain asks each provider in turn until one answers. A provider that reports ErrRateLimited is skipped for
+// Cooldown afterwards, rather than being asked again on every call.
+type Chain struct {
+	Providers []QuoteProvider
+	Cooldown  time.Duration
+	Clock     clock.Clock
+
+	mu      sync.Mutex
+	benched map[string]time.Time
+}
+
+// NewChain returns a chain over providers, in order of preference, with a one minute cooldown.
+func NewChain(providers ...QuoteProvider) *Chain {
+	return &Chain{
+		Providers: providers,
+		Cooldown:  time.Minute,
+		Clock:     clock.System(),
+		benched:   make(map[string]time.Time),
+	}
+}
+
+func (c *Chain) Name() string {
+	names := make([]string, 0, len(c.Providers))
+	for _, p := range c.Providers {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *Chain) now() time.Time {
+	return clock.OrSystem(c.Clock).Now()
+}
+
+func (c *Chain) available(p QuoteProvider) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.benched[p.Name()]
+	return !ok || !c.now().Before(until)
+}
+
+func (c *Chain) bench(p QuoteProvider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.benched == nil {
+		c.benched = make(map[string]time.Time)
+	}
+	c.benched[p.Name()] = c.now().Add(c.Cooldown)
+}
+
+// try runs ask against each available provider until one succeeds. If they all fail, the error of the last one
+// that supports what was asked is returned, then ErrRateLimited if any were cooling down, then ErrUnsupported.
+func (c *Chain) try(ctx context.Context, what string, ask func(p QuoteProvider) error) error {
+	var err, unsupported error
+	benched := false
+	for _, p := range c.Providers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !c.available(p) {
+			benched = true
+			continue
+		}
+
+		perr := ask(p)
+		if perr == nil {
+			return nil
+		}
+		if errors.Is(perr, ErrRateLimited) {
+			c.bench(p)
+		}
+		if errors.Is(perr, ErrUnsupported) {
+			unsupported = perr
+			continue
+		}
+		log.Println(fmt.Sprintf("Unable to get %v from %v, falling back : %v", what, p.Name(), perr.Error()))
+		err = perr
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case benched:
+		return fmt.Errorf("every provider for %v is cooling down: %w", what, ErrRateLimited)
+	case unsupported != nil:
+		return unsupported
+	}
+	return fmt.Errorf("no provider available for %v: %w", what, ErrUnavailable)
+}
+
+func (c *Chain) Stock(ctx context.Context, ticker string) (q Quote, err error) {
+	err = c.try(ctx, ticker, func(p QuoteProvider) (err error) {
+		q, err = p.Stock(ctx, ticker)
+		return err
+	})
+	return q, err
+}
+
+func (c *Chain) Option(ctx context.Context, symbol utils.OptionSymbol) (q OptionQuote, err error) {
+	err = c.try(ctx, symbol.String(), func(p QuoteProvider) (err error) {
+		q, err = p.Option(ctx, symbol)
+		return err
+	})
+	return q, err
+}
+
+func (c *Chain) Crypto(ctx context.Context, coin string) (q Quote, err error) {
+	err = c.try(ctx, coin, func(p QuoteProvider) (err error) {
+		q, err = p.Crypto(ctx, coin)
+		return err
+	})
+	return q, err
+}
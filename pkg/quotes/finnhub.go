@@ -0,0 +1,107 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package quotes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+const FinnhubBaseURL = "https://finnhub.io/api/v1"
+
+// Finnhub is a QuoteProvider covering stocks, and crypto through Binance's USDT pairs. It has no options.
+type Finnhub struct {
+	BaseURL string
+	Key     string
+	HTTP    *http.Client
+}
+
+// NewFinnhub returns a Finnhub provider for the FINNHUB_API key in cfg.
+func NewFinnhub(cfg config.StocksConfig) *Finnhub {
+	return &Finnhub{
+		BaseURL: FinnhubBaseURL,
+		Key:     cfg.Finn_API,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (f *Finnhub) Name() string {
+	return "finnhub"
+}
+
+type finnhubQuote struct {
+	C float64 `json:"c"`
+	T int64   `json:"t"`
+}
+
+func (f *Finnhub) quote(ctx context.Context, symbol string) (Quote, error) {
+	q := url.Values{"symbol": {symbol}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(f.BaseURL, "/")+"/quote?"+q.Encode(), nil)
+	if err != nil {
+		return Quote{}, err
+	}
+	req.Header.Set("X-Finnhub-Token", f.Key)
+
+	resp, err := f.HTTP.Do(req)
+	if err != nil {
+		return Quote{}, fmt.Errorf("unable to reach finnhub: %v: %w", err, ErrUnavailable)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return Quote{}, fmt.Errorf("finnhub returned %v: %w", resp.StatusCode, ErrRateLimited)
+	case resp.StatusCode >= 500:
+		return Quote{}, fmt.Errorf("finnhub returned %v: %w", resp.StatusCode, ErrUnavailable)
+	case resp.StatusCode != http.StatusOK:
+		return Quote{}, fmt.Errorf("finnhub returned %v for %v", resp.StatusCode, symbol)
+	}
+
+	var fq finnhubQuote
+	if err = json.NewDecoder(resp.Body).Decode(&fq); err != nil {
+		return Quote{}, fmt.Errorf("unable to read finnhub quote for %v: %w", symbol, err)
+	}
+	// Finnhub answers unknown symbols with a quote of zeroes.
+	if fq.C <= 0 {
+		return Quote{}, nonPositive(f.Name(), symbol)
+	}
+
+	return Quote{Symbol: symbol, Price: fq.C, At: time.Unix(fq.T, 0), Source: f.Name()}, nil
+}
+
+func (f *Finnhub) Stock(ctx context.Context, ticker string) (Quote, error) {
+	return f.quote(ctx, strings.ToUpper(ticker))
+}
+
+func (f *Finnhub) Option(ctx context.Context, symbol utils.OptionSymbol) (OptionQuote, error) {
+	return OptionQuote{}, fmt.Errorf("finnhub has no option quotes: %w", ErrUnsupported)
+}
+
+func (f *Finnhub) Crypto(ctx context.Context, coin string) (Quote, error) {
+	from, to := SplitPair(coin)
+	if to == "USD" {
+		to = "USDT"
+	}
+	return f.quote(ctx, "BINANCE:"+from+to)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package quotes
+
+import (
+	"context"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/polygon"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// Polygon is a QuoteProvider covering stocks, options and crypto.
+type Polygon struct {
+	Client *polygon.Client
+}
+
+// NewPolygon returns a Polygon provider for the endpoint and key in cfg. Being rate limited comes straight back as
+// ErrRateLimited, so a Chain can fall back at once instead of waiting.
+func NewPolygon(cfg config.StocksConfig) *Polygon {
+	return &Polygon{Client: polygon.NewClient(cfg).FailFast()}
+}
+
+func (p *Polygon) Name() string {
+	return "polygon"
+}
+
+func (p *Polygon) Stock(ctx context.Context, ticker string) (Quote, error) {
+	res, err := p.Client.LastQuote(ctx, ticker)
+	if err != nil {
+		return Quote{}, err
+	}
+	if res.Results.P <= 0 {
+		return Quote{}, nonPositive(p.Name(), ticker)
+	}
+
+	return Quote{Symbol: ticker, Price: res.Results.P, At: time.Unix(0, res.Results.T_), Source: p.Name()}, nil
+}
+
+func (p *Polygon) Option(ctx context.Context, symbol utils.OptionSymbol) (OptionQuote, error) {
+	res, err := p.Client.OptionSnapshot(ctx, symbol)
+	if err != nil {
+		return OptionQuote{}, err
+	}
+
+	r := res.Results
+	price := r.LastQuote.Midpoint
+	at := r.LastQuote.LastUpdated
+	if price <= 0 {
+		price, at = r.Day.Close, r.Day.LastUpdated
+	}
+	if price <= 0 {
+		return OptionQuote{}, nonPositive(p.Name(), symbol.String())
+	}
+
+	return OptionQuote{
+		Quote:      Quote{Symbol: symbol.String(), Price: price, At: time.Unix(0, at), Source: p.Name()},
+		Underlying: r.UnderlyingAsset.Price,
+		Bid:        r.LastQuote.Bid,
+		Ask:        r.LastQuote.Ask,
+	}, nil
+}
+
+func (p *Polygon) Crypto(ctx context.Context, coin string) (Quote, error) {
+	from, to := SplitPair(coin)
+	res, err := p.Client.LastCryptoTrade(ctx, from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+	if res.Last.Price <= 0 {
+		return Quote{}, nonPositive(p.Name(), coin)
+	}
+
+	return Quote{Symbol: from + to, Price: res.Last.Price, At: time.Unix(0, res.Last.Timestamp*int64(time.Millisecond)), Source: p.Name()}, nil
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/db"
+	"github.com/m1k8/harpe/pkg/polygon"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+// Errors are shared with pkg/polygon, so a Polygon failure needs no translating.
+var (
+	ErrRateLimited = polygon.ErrRateLimited
+	ErrNotFound    = polygon.ErrNotFound
+	ErrUnavailable = polygon.ErrUnavailable
+	ErrUnsupported = errors.New("UNSUPPORTED")
+)
+
+// Quote is the latest price of something, and where and when it came from.
+type Quote struct {
+	Symbol string
+	Price  float64
+	At     time.Time
+	Source string
+}
+
+// OptionQuote is an option's latest premium along with its underlying's price. Either may be 0 if unknown.
+type OptionQuote struct {
+	Quote
+	Underlying float64
+	Bid        float64
+	Ask        float64
+}
+
+// QuoteProvider is a source of prices. Providers return ErrUnsupported for asset types they don't cover and
+// ErrRateLimited when they have been asked too often.
+type QuoteProvider interface {
+	Name() string
+	Stock(ctx context.Context, ticker string) (Quote, error)
+	Option(ctx context.Context, symbol utils.OptionSymbol) (OptionQuote, error)
+	Crypto(ctx context.Context, coin string) (Quote, error)
+}
+
+// SplitPair reads a coin or pair as Kronos users write it - BTC, BTCUSD, BTC-USD, BTC/USD or X:BTCUSD - into the coin
+// and the currency it is priced in, which is USD unless given.
+func SplitPair(coin string) (from, to string) {
+	pair := strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(coin), "X:"))
+	if i := strings.IndexAny(pair, "-/"); i > 0 {
+		return pair[:i], pair[i+1:]
+	}
+	for _, quote := range []string{"USDT", "USDC", "USD", "EUR", "GBP"} {
+		if len(pair) > len(quote) && strings.HasSuffix(pair, quote) {
+			return strings.TrimSuffix(pair, quote), quote
+		}
+	}
+	return pair, "USD"
+}
+
+// Default is the provider Kronos uses: Polygon, falling back to Finnhub, with every answer shared for ttl.
+func Default(cfg config.StocksConfig, ttl time.Duration) QuoteProvider {
+	return NewCache(NewChain(NewPolygon(cfg), NewFinnhub(cfg)), ttl)
+}
+
+func nonPositive(source, symbol string) error {
+	return fmt.Errorf("%v has no price for %v: %w", source, symbol, ErrNotFound)
+}
+
+var (
+	_ QuoteProvider = (*Polygon)(nil)
+	_ QuoteProvider = (*Finnhub)(nil)
+	_ QuoteProvider = (*Chain)(nil)
+	_ QuoteProvider = (*Cache)(nil)
+)
+
+// ClosePrices prices alerts being expired by db.SweepExpired with p: the last trade for stocks, shorts and crypto, and
+// the premium for options.
+func ClosePrices(p QuoteProvider) db.PriceFunc {
+	return func(ctx context.Context, assetType, symbol string) (float32, error) {
+		var (
+			q   Quote
+			err error
+		)
+		switch assetType {
+		case db.AssetStock, db.AssetShort:
+			q, err = p.Stock(ctx, symbol)
+		case db.AssetCrypto:
+			q, err = p.Crypto(ctx, symbol)
+		case db.AssetOption:
+			s, serr := utils.ParseOptionSymbol(symbol)
+			if serr != nil {
+				return 0, serr
+			}
+			var oq OptionQuote
+			oq, err = p.Option(ctx, s)
+			q = oq.Quote
+		default:
+			return 0, fmt.Errorf("%v : %w", assetType, ErrUnsupported)
+		}
+		return float32(q.Price), err
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rules
+
+import (
+	"strings"
+
+	"github.com/m1k8/harpe/pkg/db"
+)
+
+// StockLevels is what a stock alert watches: its own price, long.
+func StockLevels(s db.Stock) Levels {
+	return Levels{
+		Series:       Price,
+		Side:         Long,
+		Starting:     s.StockStarting,
+		PoI:          s.StockPoI,
+		PoIHit:       s.StockPOIHit,
+		Targets:      []float32{s.StockSPt, s.StockEPt},
+		Stop:         s.StockStop,
+		TrailingStop: s.StockTrailingStop,
+		Extreme:      s.StockHighest,
+	}
+}
+
+// ShortLevels is what a short alert watches: its own price, short.
+func ShortLevels(s db.Short) Levels {
+	return Levels{
+		Series:       Price,
+		Side:         Short,
+		Starting:     s.ShortStarting,
+		PoI:          s.ShortPoI,
+		PoIHit:       s.ShortPOIHit,
+		Targets:      []float32{s.ShortSPt, s.ShortEPt},
+		Stop:         s.ShortStop,
+		TrailingStop: s.ShortTrailingStop,
+		Extreme:      s.ShortLowest,
+	}
+}
+
+// CryptoLevels is what a crypto alert watches: the coin's price, long.
+func CryptoLevels(c db.Crypto) Levels {
+	return Levels{
+		Series:       Price,
+		Side:         Long,
+		Starting:     c.CryptoStarting,
+		PoI:          c.CryptoPoI,
+		PoIHit:       c.CryptoPOIHit,
+		Targets:      []float32{c.CryptoSPt, c.CryptoEPt},
+		Stop:         c.CryptoStop,
+		TrailingStop: c.CryptoTrailingStop,
+		Extreme:      c.CryptoHighest,
+	}
+}
+
+// OptionLevels is what an option alert watches. The premium is always long and carries the trailing stop and highs;
+// the underlying carries the PoI and stop, long for calls and short for puts.
+func OptionLevels(o db.Option) (premium, underlying Levels) {
+	premium = Levels{
+		Series:       Premium,
+		Side:         Long,
+		Starting:     o.OptionStarting,
+		TrailingStop: o.OptionTrailingStop,
+		Extreme:      o.OptionHighest,
+		Pending:      o.OptionUnderlyingPoI != 0 && !o.OptionUnderlyingPOIHit,
+	}
+
+	underlying = Levels{
+		Series:   Underlying,
+		Side:     Long,
+		Starting: o.OptionUnderlyingStarting,
+		PoI:      o.OptionUnderlyingPoI,
+		PoIHit:   o.OptionUnderlyingPOIHit,
+		Stop:     o.OptionUnderlyingStop,
+	}
+	if IsPut(o.OptionContractType) {
+		underlying.Side = Short
+	}
+
+	return premium, underlying
+}
+
+// WithTargets swaps l's targets for the stored targets on its series that are still to be hit.
+// The IDs come back in the same order, so Event.Target of a TargetHit indexes them for db.HitTarget.
+func (l Levels) WithTargets(targets []*db.Target) (Levels, []int64) {
+	l.Targets = nil
+	var ids []int64
+	for _, t := range targets {
+		if t.Series != string(l.Series) || t.Hit() {
+			continue
+		}
+		l.Targets = append(l.Targets, t.Price)
+		ids = append(ids, t.TargetID)
+	}
+	return l, ids
+}
+
+// IsPut reports whether an option contract type ("P", "put", ...) is a put.
+func IsPut(contractType string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(contractType)), "P")
+}
+
+func EvaluateStock(s db.Stock, price float32) []Event {
+	return StockLevels(s).Evaluate(price)
+}
+
+func EvaluateShort(s db.Short, price float32) []Event {
+	return ShortLevels(s).Evaluate(price)
+}
+
+func EvaluateCrypto(c db.Crypto, price float32) []Event {
+	return CryptoLevels(c).Evaluate(price)
+}
+
+// EvaluateOption evaluates a new premium and underlying price together; either may be 0 if it hasn't changed.
+// A PoI hit on the underlying arms the premium's trailing stop straight away.
+func EvaluateOption(o db.Option, premium, underlying float32) []Event {
+	p, u := OptionLevels(o)
+
+	events := u.Evaluate(underlying)
+	if Has(events, PoIHit) {
+		p.Pending = false
+	}
+	return append(events, p.Evaluate(premium)...)
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rules
+
+// Side is the direction a price series has to move for the alert to pay off.
+type Side int
+
+const (
+	Long Side = iota
+	Short
+)
+
+// Kind is what an Event reports.
+type Kind string
+
+const (
+	PoIHit          Kind = "poi_hit"
+	TargetHit       Kind = "target_hit"
+	StopHit         Kind = "stop_hit"
+	TrailingStopHit Kind = "trailing_stop_hit"
+	NewHigh         Kind = "new_high"
+	NewLow          Kind = "new_low"
+)
+
+// Series says which price an event was triggered by; only options watch more than one.
+type Series string
+
+const (
+	Price      Series = "price"
+	Premium    Series = "premium"
+	Underlying Series = "underlying"
+)
+
+// Event is one thing a new price triggered. Level is the level that was crossed: the PoI, target, stop,
+// trailing stop price, or for NewHigh/NewLow the previous extreme. Target indexes Levels.Targets for TargetHit.
+type Event struct {
+	Kind   Kind
+	Level  float32
+	Price  float32
+	Target int
+	Series Series
+}
+
+// Levels is everything an alert watches on one price series. Zero levels are unset.
+// TrailingStop is a percentage retracement from Extreme, the best price seen so far in the alert's favour.
+// Pending holds back targets and the trailing stop while the alert waits on a PoI on another series.
+type Levels struct {
+	Series       Series
+	Side         Side
+	Starting     float32
+	PoI          float32
+	PoIHit       bool
+	Targets      []float32
+	Stop         float32
+	TrailingStop float32
+	Extreme      float32
+	Pending      bool
+}
+
+// better reports whether a is further in the alert's favour than b.
+func (l Levels) better(a, b float32) bool {
+	if l.Side == Short {
+		return a < b
+	}
+	return a > b
+}
+
+// reached reports whether price is at or beyond level in the alert's favour.
+func (l Levels) reached(price, level float32) bool {
+	return price == level || l.better(price, level)
+}
+
+// TrailingStopPrice is where the trailing stop sits given the best price so far, or 0 if there isn't one.
+func (l Levels) TrailingStopPrice(extreme float32) float32 {
+	if l.TrailingStop == 0 || extreme == 0 {
+		return 0
+	}
+	if l.Side == Short {
+		return extreme * (1 + l.TrailingStop/100)
+	}
+	return extreme * (1 - l.TrailingStop/100)
+}
+
+// Evaluate returns every event price triggers, in the order PoI, new extreme, targets, stop, trailing stop.
+// A PoI is hit when price gets from Starting to it, whichever side of Starting it is on.
+// Until the PoI is hit only new extremes are watched: an alert that never triggered can't be stopped out, so targets,
+// the stop and the trailing stop all wait for it.
+func (l Levels) Evaluate(price float32) []Event {
+	var events []Event
+	if price <= 0 {
+		return events
+	}
+
+	armed := !l.Pending && (l.PoI == 0 || l.PoIHit)
+	if !l.Pending && !armed && poiReached(l.Starting, l.PoI, price) {
+		events = append(events, Event{Kind: PoIHit, Level: l.PoI, Price: price, Series: l.Series})
+		armed = true
+	}
+
+	extreme := l.Extreme
+	if extreme == 0 || l.better(price, extreme) {
+		kind := NewHigh
+		if l.Side == Short {
+			kind = NewLow
+		}
+		if extreme != 0 {
+			events = append(events, Event{Kind: kind, Level: extreme, Price: price, Series: l.Series})
+		}
+		extreme = price
+	}
+
+	if !armed {
+		return events
+	}
+
+	for i, t := range l.Targets {
+		if t != 0 && l.reached(price, t) {
+			events = append(events, Event{Kind: TargetHit, Level: t, Price: price, Target: i, Series: l.Series})
+		}
+	}
+
+	if l.Stop != 0 && (price == l.Stop || l.better(l.Stop, price)) {
+		events = append(events, Event{Kind: StopHit, Level: l.Stop, Price: price, Series: l.Series})
+	}
+
+	if ts := l.TrailingStopPrice(extreme); ts != 0 && (price == ts || l.better(ts, price)) {
+		events = append(events, Event{Kind: TrailingStopHit, Level: ts, Price: price, Series: l.Series})
+	}
+
+	return events
+}
+
+func poiReached(starting, poi, price float32) bool {
+	if poi >= starting {
+		return price >= poi
+	}
+	return price <= poi
+}
+
+// Has reports whether events contains one of kind.
+func Has(events []Event, kind Kind) bool {
+	for _, e := range events {
+		if e.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/db"
+)
+
+func kinds(events []Event) []Kind {
+	ks := make([]Kind, 0, len(events))
+	for _, e := range events {
+		ks = append(ks, e.Kind)
+	}
+	return ks
+}
+
+type priced struct {
+	name  string
+	price float32
+	want  []Kind
+}
+
+func check(t *testing.T, what string, evaluate func(price float32) []Event, cases []priced) {
+	t.Helper()
+	for _, c := range cases {
+		if got := kinds(evaluate(c.price)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v, %v at %v: got %v, want %v", what, c.name, c.price, got, c.want)
+		}
+	}
+}
+
+func kindsOf(ks ...Kind) []Kind {
+	if ks == nil {
+		return []Kind{}
+	}
+	return ks
+}
+
+func TestStock(t *testing.T) {
+	s := db.Stock{StockStarting: 100, StockHighest: 100, StockSPt: 110, StockEPt: 120, StockStop: 90, StockTrailingStop: 10}
+	check(t, "stock", func(p float32) []Event { return EvaluateStock(s, p) }, []priced{
+		{"no price", 0, kindsOf()},
+		{"flat", 100, kindsOf()},
+		{"up a little", 105, kindsOf(NewHigh)},
+		{"first target", 110, kindsOf(NewHigh, TargetHit)},
+		{"both targets", 125, kindsOf(NewHigh, TargetHit, TargetHit)},
+		{"the stop", 90, kindsOf(StopHit, TrailingStopHit)},
+		{"above the stop", 95, kindsOf()},
+	})
+
+	s.StockHighest = 120
+	check(t, "stock off its high", func(p float32) []Event { return EvaluateStock(s, p) }, []priced{
+		{"within the trailing stop", 109, kindsOf()},
+		{"through the trailing stop", 107, kindsOf(TrailingStopHit)},
+	})
+
+	events := EvaluateStock(s, 125)
+	if events[0].Level != 120 || events[0].Price != 125 || events[0].Series != Price {
+		t.Errorf("a new high reported %+v, want the old high as its level", events[0])
+	}
+	if events[1].Kind != TargetHit || events[1].Target != 0 || events[2].Target != 1 {
+		t.Errorf("targets reported %+v", events[1:])
+	}
+}
+
+func TestStockPendingPoI(t *testing.T) {
+	s := db.Stock{StockStarting: 100, StockHighest: 100, StockPoI: 105, StockSPt: 110, StockStop: 90, StockTrailingStop: 5}
+	check(t, "pending stock", func(p float32) []Event { return EvaluateStock(s, p) }, []priced{
+		{"below the stop before the PoI", 85, kindsOf()},
+		{"short of the PoI", 104, kindsOf(NewHigh)},
+		{"the PoI", 105, kindsOf(PoIHit, NewHigh)},
+		{"through the PoI to the target", 112, kindsOf(PoIHit, NewHigh, TargetHit)},
+	})
+
+	// A PoI below the start is hit on the way down.
+	s.StockPoI, s.StockStop, s.StockTrailingStop = 95, 80, 0
+	check(t, "dip-buy stock", func(p float32) []Event { return EvaluateStock(s, p) }, []priced{
+		{"above the PoI", 104, kindsOf(NewHigh)},
+		{"the PoI", 95, kindsOf(PoIHit)},
+	})
+
+	s.StockPOIHit = true
+	check(t, "triggered stock", func(p float32) []Event { return EvaluateStock(s, p) }, []priced{
+		{"the stop", 80, kindsOf(StopHit)},
+	})
+}
+
+func TestShort(t *testing.T) {
+	s := db.Short{ShortStarting: 200, ShortLowest: 200, ShortSPt: 180, ShortEPt: 160, ShortStop: 210, ShortTrailingStop: 10}
+	check(t, "short", func(p float32) []Event { return EvaluateShort(s, p) }, []priced{
+		{"up a little", 205, kindsOf()},
+		{"down a little", 195, kindsOf(NewLow)},
+		{"first target", 180, kindsOf(NewLow, TargetHit)},
+		{"both targets", 150, kindsOf(NewLow, TargetHit, TargetHit)},
+		{"the stop", 210, kindsOf(StopHit)},
+		{"through the stop", 230, kindsOf(StopHit, TrailingStopHit)},
+	})
+
+	s.ShortLowest, s.ShortSPt, s.ShortEPt = 160, 0, 0
+	check(t, "short off its low", func(p float32) []Event { return EvaluateShort(s, p) }, []priced{
+		{"within the trailing stop", 175, kindsOf()},
+		{"through the trailing stop", 177, kindsOf(TrailingStopHit)},
+	})
+
+	s = db.Short{ShortStarting: 200, ShortLowest: 200, ShortPoI: 195, ShortSPt: 180, ShortStop: 220}
+	check(t, "pending short", func(p float32) []Event { return EvaluateShort(s, p) }, []priced{
+		{"above the stop before the PoI", 225, kindsOf()},
+		{"short of the PoI", 198, kindsOf(NewLow)},
+		{"the PoI", 195, kindsOf(PoIHit, NewLow)},
+		{"through the PoI to the target", 180, kindsOf(PoIHit, NewLow, TargetHit)},
+	})
+
+	// A PoI above the start is hit on a bounce.
+	s.ShortPoI = 205
+	check(t, "bounce short", func(p float32) []Event { return EvaluateShort(s, p) }, []priced{
+		{"at the target before the PoI", 180, kindsOf(NewLow)},
+		{"the PoI", 205, kindsOf(PoIHit)},
+		{"through the PoI and the stop", 221, kindsOf(PoIHit, StopHit)},
+	})
+}
+
+func TestCrypto(t *testing.T) {
+	c := db.Crypto{CryptoStarting: 20000, CryptoHighest: 20000, CryptoSPt: 22000, CryptoStop: 19000, CryptoTrailingStop: 5}
+	check(t, "crypto", func(p float32) []Event { return EvaluateCrypto(c, p) }, []priced{
+		{"the target", 22000, kindsOf(NewHigh, TargetHit)},
+		{"the stop", 19000, kindsOf(StopHit, TrailingStopHit)},
+	})
+
+	c.CryptoPoI = 21000
+	check(t, "pending crypto", func(p float32) []Event { return EvaluateCrypto(c, p) }, []priced{
+		{"the stop before the PoI", 18000, kindsOf()},
+		{"the PoI", 21000, kindsOf(PoIHit, NewHigh)},
+	})
+}
+
+func TestOption(t *testing.T) {
+	call := db.Option{
+		OptionContractType:       "C",
+		OptionStarting:           2,
+		OptionHighest:            3,
+		OptionTrailingStop:       20,
+		OptionUnderlyingStarting: 145,
+		OptionUnderlyingPoI:      150,
+		OptionUnderlyingStop:     140,
+	}
+	for _, c := range []struct {
+		name                string
+		premium, underlying float32
+		want                []Kind
+	}{
+		{"under the stop before the PoI", 2.3, 139, kindsOf()},
+		{"a new premium high before the PoI", 3.5, 146, kindsOf(NewHigh)},
+		{"the PoI arms the trailing stop", 2.3, 151, kindsOf(PoIHit, TrailingStopHit)},
+		{"only the premium moving", 2.5, 0, kindsOf()},
+	} {
+		if got := kinds(EvaluateOption(call, c.premium, c.underlying)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("call, %v: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	call.OptionUnderlyingPOIHit = true
+	if got := kinds(EvaluateOption(call, 2.5, 139)); !reflect.DeepEqual(got, kindsOf(StopHit)) {
+		t.Errorf("triggered call under its stop: got %v, want the stop", got)
+	}
+
+	put := db.Option{
+		OptionContractType:       "put",
+		OptionStarting:           2,
+		OptionHighest:            2,
+		OptionUnderlyingStarting: 145,
+		OptionUnderlyingPoI:      140,
+		OptionUnderlyingStop:     150,
+	}
+	events := EvaluateOption(put, 0, 139)
+	if got := kinds(events); !reflect.DeepEqual(got, kindsOf(PoIHit)) || events[0].Series != Underlying {
+		t.Errorf("put through its PoI on the way down: got %+v", events)
+	}
+	put.OptionUnderlyingPOIHit = true
+	if got := kinds(EvaluateOption(put, 0, 151)); !reflect.DeepEqual(got, kindsOf(StopHit)) {
+		t.Errorf("triggered put above its stop: got %v, want the stop", got)
+	}
+	if got := kinds(EvaluateOption(put, 0, 141)); !reflect.DeepEqual(got, kindsOf()) {
+		t.Errorf("triggered put below its stop: got %v, want nothing", got)
+	}
+}
+
+func TestWithTargets(t *testing.T) {
+	premium, underlying := OptionLevels(db.Option{OptionContractType: "C", OptionStarting: 2, OptionHighest: 2, OptionUnderlyingStarting: 145})
+	targets := []*db.Target{
+		{TargetID: 1, Series: string(Premium), Price: 3},
+		{TargetID: 2, Series: string(Underlying), Price: 160},
+		{TargetID: 3, Series: string(Premium), Price: 4},
+		{TargetID: 4, Series: string(Premium), Price: 2.5, HitPrice: 2.6, HitTime: time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC)},
+	}
+
+	premium, ids := premium.WithTargets(targets)
+	if !reflect.DeepEqual(premium.Targets, []float32{3, 4}) || !reflect.DeepEqual(ids, []int64{1, 3}) {
+		t.Errorf("premium targets %v with IDs %v, want the unhit premium ones", premium.Targets, ids)
+	}
+	events := premium.Evaluate(4.5)
+	if len(events) != 3 || ids[events[2].Target] != 3 {
+		t.Errorf("the premium at 4.5 reported %+v", events)
+	}
+
+	underlying, ids = underlying.WithTargets(targets)
+	if !reflect.DeepEqual(underlying.Targets, []float32{160}) || !reflect.DeepEqual(ids, []int64{2}) {
+		t.Errorf("underlying targets %v with IDs %v", underlying.Targets, ids)
+	}
+}
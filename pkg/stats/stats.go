@@ -0,0 +1,264 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package stats
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/db"
+)
+
+// Call is one alert, open or closed, reduced to what the statistics need.
+// Gain is the % move in the caller's favour, so a short that fell 10% has a Gain of 10.
+type Call struct {
+	AlertID   string
+	Caller    string
+	AssetType string
+	Ticker    string
+	CallTime  time.Time
+	Gain      float32
+	Closed    bool
+}
+
+// Window bounds calls by their call time. A zero Since or Until leaves that side open.
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// AllTime is the window containing every call.
+var AllTime = Window{}
+
+// Last returns the window covering the d before now.
+func Last(d time.Duration, now time.Time) Window {
+	return Window{Since: now.Add(-d)}
+}
+
+func (w Window) Contains(t time.Time) bool {
+	if !w.Since.IsZero() && t.Before(w.Since) {
+		return false
+	}
+	if !w.Until.IsZero() && !t.Before(w.Until) {
+		return false
+	}
+	return true
+}
+
+// Source is the part of db.Repository the statistics read from.
+type Source interface {
+	GetAllContext(ctx context.Context) ([]*db.Stock, []*db.Short, []*db.Crypto, []*db.Option, error)
+	GetClosedAlertsContext(ctx context.Context, since time.Time) ([]*db.ClosedAlert, error)
+}
+
+// Load fetches every open and closed call in the guild that falls inside w.
+func Load(ctx context.Context, src Source, w Window) ([]Call, error) {
+	stocks, shorts, crypto, options, err := src.GetAllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// alerts are closed after they're called, so this never misses anything in the window
+	closed, err := src.GetClosedAlertsContext(ctx, w.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter(Calls(stocks, shorts, crypto, options, closed), w), nil
+}
+
+// Calls flattens open alerts, judged at their best price so far, and closed alerts, judged at their close.
+// Closed alerts whose close price is unknown are left out.
+func Calls(stocks []*db.Stock, shorts []*db.Short, crypto []*db.Crypto, options []*db.Option, closed []*db.ClosedAlert) []Call {
+	calls := make([]Call, 0, len(stocks)+len(shorts)+len(crypto)+len(options)+len(closed))
+
+	for _, v := range stocks {
+		calls = append(calls, Call{v.StockAlertID, v.Caller, db.AssetStock, v.StockTicker, v.StockCallTime, v.GetPctGain(v.StockHighest), false})
+	}
+	for _, v := range shorts {
+		calls = append(calls, Call{v.ShortAlertID, v.Caller, db.AssetShort, v.ShortTicker, v.ShortCallTime, -v.GetPctGain(v.ShortLowest), false})
+	}
+	for _, v := range crypto {
+		calls = append(calls, Call{v.CryptoAlertID, v.Caller, db.AssetCrypto, v.CryptoCoin, v.CryptoCallTime, v.GetPctGain(v.CryptoHighest), false})
+	}
+	for _, v := range options {
+		calls = append(calls, Call{v.OptionAlertID, v.Caller, db.AssetOption, v.OptionTicker, v.OptionCallTime, v.GetPctGain(v.OptionHighest), false})
+	}
+	for _, v := range closed {
+		if v.PriceUnknown {
+			continue // closed at no known price, so there is nothing to judge it by
+		}
+		gain := v.PctGain
+		if v.AssetType == db.AssetShort {
+			gain = -gain
+		}
+		calls = append(calls, Call{v.AlertID, v.Caller, v.AssetType, v.Ticker, v.CallTime, gain, true})
+	}
+
+	return calls
+}
+
+func filter(calls []Call, w Window) []Call {
+	out := make([]Call, 0, len(calls))
+	for _, c := range calls {
+		if w.Contains(c.CallTime) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// CallerStats summarises one caller's calls. A win is any call with a positive Gain; WinRate is a percentage.
+type CallerStats struct {
+	Caller     string
+	Calls      int
+	Wins       int
+	WinRate    float32
+	AvgGain    float32
+	MedianGain float32
+	Best       Call
+	Worst      Call
+	ByAsset    map[string]int
+}
+
+// ForCaller computes caller's statistics over the calls inside w.
+func ForCaller(calls []Call, caller string, w Window) *CallerStats {
+	mine := make([]Call, 0)
+	for _, c := range filter(calls, w) {
+		if c.Caller == caller {
+			mine = append(mine, c)
+		}
+	}
+	return summarise(caller, mine)
+}
+
+// ByCaller computes every caller's statistics over the calls inside w.
+func ByCaller(calls []Call, w Window) map[string]*CallerStats {
+	grouped := make(map[string][]Call)
+	for _, c := range filter(calls, w) {
+		grouped[c.Caller] = append(grouped[c.Caller], c)
+	}
+
+	res := make(map[string]*CallerStats, len(grouped))
+	for caller, cs := range grouped {
+		res[caller] = summarise(caller, cs)
+	}
+	return res
+}
+
+func summarise(caller string, calls []Call) *CallerStats {
+	st := &CallerStats{
+		Caller:  caller,
+		Calls:   len(calls),
+		ByAsset: make(map[string]int),
+	}
+	if len(calls) == 0 {
+		return st
+	}
+
+	gains := make([]float64, 0, len(calls))
+	var total float64
+	st.Best, st.Worst = calls[0], calls[0]
+
+	for _, c := range calls {
+		if c.Gain > 0 {
+			st.Wins++
+		}
+		if c.Gain > st.Best.Gain {
+			st.Best = c
+		}
+		if c.Gain < st.Worst.Gain {
+			st.Worst = c
+		}
+		st.ByAsset[c.AssetType]++
+		gains = append(gains, float64(c.Gain))
+		total += float64(c.Gain)
+	}
+
+	sort.Float64s(gains)
+	mid := len(gains) / 2
+	if len(gains)%2 == 0 {
+		st.MedianGain = float32((gains[mid-1] + gains[mid]) / 2)
+	} else {
+		st.MedianGain = float32(gains[mid])
+	}
+
+	st.AvgGain = float32(total / float64(len(calls)))
+	st.WinRate = float32(st.Wins) / float32(st.Calls) * 100
+
+	return st
+}
+
+type RankBy int
+
+const (
+	RankByAvgGain RankBy = iota
+	RankByMedianGain
+	RankByWinRate
+	RankByCalls
+)
+
+// LeaderboardOptions controls how callers are ranked. Callers with fewer than MinCalls calls are left out,
+// and a Limit of 0 returns everyone.
+type LeaderboardOptions struct {
+	Window   Window
+	RankBy   RankBy
+	MinCalls int
+	Limit    int
+}
+
+// Leaderboard ranks the guild's callers, best first. Ties fall back to win rate, then number of calls, then name.
+func Leaderboard(calls []Call, opts LeaderboardOptions) []*CallerStats {
+	board := make([]*CallerStats, 0)
+	for _, st := range ByCaller(calls, opts.Window) {
+		if st.Calls >= opts.MinCalls {
+			board = append(board, st)
+		}
+	}
+
+	key := func(st *CallerStats) float32 {
+		switch opts.RankBy {
+		case RankByMedianGain:
+			return st.MedianGain
+		case RankByWinRate:
+			return st.WinRate
+		case RankByCalls:
+			return float32(st.Calls)
+		default:
+			return st.AvgGain
+		}
+	}
+
+	sort.Slice(board, func(i, j int) bool {
+		a, b := board[i], board[j]
+		if key(a) != key(b) {
+			return key(a) > key(b)
+		}
+		if a.WinRate != b.WinRate {
+			return a.WinRate > b.WinRate
+		}
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return a.Caller < b.Caller
+	})
+
+	if opts.Limit > 0 && len(board) > opts.Limit {
+		board = board[:opts.Limit]
+	}
+	return board
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/db"
+)
+
+func TestCallsSkipsUnpricedCloses(t *testing.T) {
+	closed := []*db.ClosedAlert{
+		{AlertID: "won", Caller: "a", AssetType: db.AssetStock, PctGain: 10},
+		{AlertID: "expired", Caller: "a", AssetType: db.AssetStock, PriceUnknown: true},
+		{AlertID: "short", Caller: "a", AssetType: db.AssetShort, PctGain: -5},
+	}
+
+	calls := Calls(nil, nil, nil, nil, closed)
+	if len(calls) != 2 {
+		t.Fatalf("got %v calls, want the 2 with a close price", len(calls))
+	}
+	for _, c := range calls {
+		if c.AlertID == "expired" {
+			t.Errorf("the call closed without a price was judged: %+v", c)
+		}
+		if c.AlertID == "short" && c.Gain != 5 {
+			t.Errorf("short that fell 5%% has Gain %v, want 5", c.Gain)
+		}
+	}
+}
+
+func TestCallsNegatesShorts(t *testing.T) {
+	shorts := []*db.Short{
+		{ShortAlertID: "fell", Caller: "a", ShortTicker: "AAPL", ShortStarting: 200, ShortLowest: 180},
+	}
+	stocks := []*db.Stock{
+		{StockAlertID: "rose", Caller: "a", StockTicker: "AAPL", StockStarting: 200, StockHighest: 220},
+	}
+	closed := []*db.ClosedAlert{
+		{AlertID: "squeezed", Caller: "a", AssetType: db.AssetShort, PctGain: 8},
+		{AlertID: "sold", Caller: "a", AssetType: db.AssetStock, PctGain: -8},
+	}
+
+	want := map[string]float32{"fell": 10, "rose": 10, "squeezed": -8, "sold": -8}
+	calls := Calls(stocks, shorts, nil, nil, closed)
+	if len(calls) != len(want) {
+		t.Fatalf("got %v calls, want %v", len(calls), len(want))
+	}
+	for _, c := range calls {
+		if c.Gain != want[c.AlertID] {
+			t.Errorf("%v has Gain %v, want %v", c.AlertID, c.Gain, want[c.AlertID])
+		}
+		if c.Closed != (c.AlertID == "squeezed" || c.AlertID == "sold") {
+			t.Errorf("%v has Closed %v", c.AlertID, c.Closed)
+		}
+	}
+}
+
+var day = time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC)
+
+func call(id, caller, assetType string, gain float32, daysAgo int) Call {
+	return Call{AlertID: id, Caller: caller, AssetType: assetType, CallTime: day.AddDate(0, 0, -daysAgo), Gain: gain}
+}
+
+func TestByCaller(t *testing.T) {
+	calls := []Call{
+		call("a1", "alice", db.AssetStock, 10, 1),
+		call("a2", "alice", db.AssetOption, -4, 2),
+		call("a3", "alice", db.AssetStock, 30, 3),
+		call("a4", "alice", db.AssetShort, 0, 40),
+		call("b1", "bob", db.AssetCrypto, -2, 1),
+	}
+
+	all := ByCaller(calls, AllTime)
+	if len(all) != 2 {
+		t.Fatalf("grouped into %v callers, want 2", len(all))
+	}
+
+	alice := all["alice"]
+	if alice.Calls != 4 || alice.Wins != 2 || alice.WinRate != 50 {
+		t.Errorf("alice has %v calls, %v wins and a %v%% win rate, want 4, 2 and 50%%", alice.Calls, alice.Wins, alice.WinRate)
+	}
+	if alice.AvgGain != 9 || alice.MedianGain != 5 {
+		t.Errorf("alice averages %v with a median of %v, want 9 and 5", alice.AvgGain, alice.MedianGain)
+	}
+	if alice.Best.AlertID != "a3" || alice.Worst.AlertID != "a2" {
+		t.Errorf("alice's best is %v and worst %v, want a3 and a2", alice.Best.AlertID, alice.Worst.AlertID)
+	}
+	if alice.ByAsset[db.AssetStock] != 2 || alice.ByAsset[db.AssetOption] != 1 || alice.ByAsset[db.AssetShort] != 1 {
+		t.Errorf("alice's calls by asset are %v", alice.ByAsset)
+	}
+	if bob := all["bob"]; bob.Calls != 1 || bob.Wins != 0 || bob.MedianGain != -2 {
+		t.Errorf("bob's stats are %+v", bob)
+	}
+
+	month := ByCaller(calls, Last(30*24*time.Hour, day))
+	if alice := month["alice"]; alice.Calls != 3 || alice.MedianGain != 10 {
+		t.Errorf("over the last 30 days alice has %v calls with a median of %v, want 3 and 10", alice.Calls, alice.MedianGain)
+	}
+	if st := ForCaller(calls, "carol", AllTime); st.Calls != 0 || st.Caller != "carol" {
+		t.Errorf("a caller with no calls has %+v", st)
+	}
+}
+
+func ranking(board []*CallerStats) []string {
+	names := make([]string, 0, len(board))
+	for _, st := range board {
+		names = append(names, st.Caller)
+	}
+	return names
+}
+
+func TestLeaderboard(t *testing.T) {
+	calls := []Call{
+		// alice averages 10 from 2 calls, winning both
+		call("a1", "alice", db.AssetStock, 5, 1),
+		call("a2", "alice", db.AssetStock, 15, 1),
+		// bob averages 10 from 3 calls, winning 2 of 3
+		call("b1", "bob", db.AssetStock, 20, 1),
+		call("b2", "bob", db.AssetStock, 20, 1),
+		call("b3", "bob", db.AssetStock, -10, 1),
+		// carol averages 10 from 2 calls, winning both, so she ties alice on everything but name
+		call("c1", "carol", db.AssetStock, 10, 1),
+		call("c2", "carol", db.AssetStock, 10, 1),
+		// dave has the best single call, but only the one
+		call("d1", "dave", db.AssetStock, 50, 1),
+		// erin has the most calls and loses them all
+		call("e1", "erin", db.AssetStock, -1, 1),
+		call("e2", "erin", db.AssetStock, -1, 1),
+		call("e3", "erin", db.AssetStock, -1, 1),
+		call("e4", "erin", db.AssetStock, -1, 1),
+	}
+
+	tests := []struct {
+		name string
+		opts LeaderboardOptions
+		want []string
+	}{
+		{"by average gain", LeaderboardOptions{}, []string{"dave", "alice", "carol", "bob", "erin"}},
+		{"by median gain", LeaderboardOptions{RankBy: RankByMedianGain}, []string{"dave", "bob", "alice", "carol", "erin"}},
+		{"by win rate", LeaderboardOptions{RankBy: RankByWinRate}, []string{"alice", "carol", "dave", "bob", "erin"}},
+		{"by calls", LeaderboardOptions{RankBy: RankByCalls}, []string{"erin", "bob", "alice", "carol", "dave"}},
+		{"with a minimum", LeaderboardOptions{MinCalls: 2}, []string{"alice", "carol", "bob", "erin"}},
+		{"limited", LeaderboardOptions{Limit: 2}, []string{"dave", "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ranking(Leaderboard(calls, tt.opts))
+			if len(got) != len(tt.want) {
+				t.Fatalf("ranked %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("ranked %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
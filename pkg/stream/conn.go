@@ -0,0 +1,241 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cluster is one of Polygon's WebSocket clusters; each is a separate connection.
+type Cluster string
+
+const (
+	Stocks  Cluster = "stocks"
+	Options Cluster = "options"
+	Crypto  Cluster = "crypto"
+)
+
+// Trade is one trade from the feed. Symbol is as Polygon names it: AAPL, O:AAPL261218C00150000 or BTC-USD.
+type Trade struct {
+	Cluster Cluster
+	Symbol  string
+	Price   float64
+	Size    float64
+	At      time.Time
+}
+
+var ErrAuthFailed = errors.New("AUTH FAILED")
+
+type event struct {
+	Ev      string  `json:"ev"`
+	Status  string  `json:"status"`
+	Message string  `json:"message"`
+	Sym     string  `json:"sym"`
+	Pair    string  `json:"pair"`
+	P       float64 `json:"p"`
+	S       float64 `json:"s"`
+	T       int64   `json:"t"`
+}
+
+type control struct {
+	Action string `json:"action"`
+	Params string `json:"params"`
+}
+
+// conn keeps one cluster's connection up, reconnecting with exponential backoff and resubscribing to everything
+// wanted each time it does.
+type conn struct {
+	cluster    Cluster
+	url        string
+	key        string
+	backoff    time.Duration
+	maxBackoff time.Duration
+	deliver    func(Trade)
+
+	mu   sync.Mutex
+	subs map[string]int
+	ws   *wsConn
+}
+
+func newConn(cluster Cluster, baseURL, key string, deliver func(Trade)) *conn {
+	return &conn{
+		cluster:    cluster,
+		url:        strings.TrimRight(baseURL, "/") + "/" + string(cluster),
+		key:        key,
+		backoff:    time.Second,
+		maxBackoff: time.Minute,
+		deliver:    deliver,
+		subs:       make(map[string]int),
+	}
+}
+
+// channel is the Polygon channel carrying symbol's trades.
+func (c *conn) channel(symbol string) string {
+	if c.cluster == Crypto {
+		return "XT." + symbol
+	}
+	return "T." + symbol
+}
+
+func (c *conn) send(ws *wsConn, action string, symbols []string) error {
+	if len(symbols) == 0 {
+		return nil
+	}
+	params := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		params = append(params, c.channel(s))
+	}
+	msg, _ := json.Marshal(control{Action: action, Params: strings.Join(params, ",")})
+	return ws.WriteText(msg)
+}
+
+// add wants symbol's trades, subscribing straight away if it is new and the connection is up.
+func (c *conn) add(symbol string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subs[symbol]++
+	if c.subs[symbol] == 1 && c.ws != nil {
+		if err := c.send(c.ws, "subscribe", []string{symbol}); err != nil {
+			log.Println(fmt.Sprintf("Unable to subscribe to %v : %v", symbol, err.Error()))
+		}
+	}
+}
+
+// remove drops one want of symbol's trades, unsubscribing once nobody wants them.
+func (c *conn) remove(symbol string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subs[symbol]--; c.subs[symbol] > 0 {
+		return
+	}
+	delete(c.subs, symbol)
+	if c.ws != nil {
+		if err := c.send(c.ws, "unsubscribe", []string{symbol}); err != nil {
+			log.Println(fmt.Sprintf("Unable to unsubscribe from %v : %v", symbol, err.Error()))
+		}
+	}
+}
+
+// run keeps the connection up until ctx is done.
+func (c *conn) run(ctx context.Context) {
+	wait := c.backoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println(fmt.Sprintf("%v feed dropped, reconnecting in %v : %v", c.cluster, wait, err))
+
+		// A session that stayed up a while was healthy; start backing off afresh.
+		if time.Since(start) > c.maxBackoff {
+			wait = c.backoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > c.maxBackoff {
+			wait = c.maxBackoff
+		}
+	}
+}
+
+// session connects, authenticates, subscribes to everything wanted, then reads trades until the connection fails.
+func (c *conn) session(ctx context.Context) error {
+	ws, err := dial(ctx, c.url)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	auth, _ := json.Marshal(control{Action: "auth", Params: c.key})
+	if err = ws.WriteText(auth); err != nil {
+		return err
+	}
+
+	defer func() {
+		c.mu.Lock()
+		c.ws = nil
+		c.mu.Unlock()
+	}()
+
+	for {
+		msg, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var events []event
+		if err = json.Unmarshal(msg, &events); err != nil {
+			log.Println(fmt.Sprintf("Unable to read %v feed message : %v", c.cluster, err.Error()))
+			continue
+		}
+
+		for _, e := range events {
+			switch e.Ev {
+			case "status":
+				switch e.Status {
+				case "auth_success":
+					if err = c.authenticated(ws); err != nil {
+						return err
+					}
+				case "auth_failed":
+					return fmt.Errorf("%v: %w", e.Message, ErrAuthFailed)
+				}
+			case "T", "XT":
+				sym := e.Sym
+				if e.Ev == "XT" {
+					sym = e.Pair
+				}
+				c.deliver(Trade{Cluster: c.cluster, Symbol: sym, Price: e.P, Size: e.S, At: time.Unix(0, e.T*int64(time.Millisecond))})
+			}
+		}
+	}
+}
+
+// authenticated subscribes to everything wanted, and has add and remove use ws from now on.
+func (c *conn) authenticated(ws *wsConn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	symbols := make([]string, 0, len(c.subs))
+	for s := range c.subs {
+		symbols = append(symbols, s)
+	}
+	c.ws = ws
+	return c.send(ws, "subscribe", symbols)
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package stream
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/m1k8/harpe/pkg/config"
+	"github.com/m1k8/harpe/pkg/db"
+	"github.com/m1k8/harpe/pkg/quotes"
+	"github.com/m1k8/harpe/pkg/utils"
+)
+
+const DefaultURL = "wss://socket.polygon.io"
+
+type watcher struct {
+	ctx     context.Context
+	cluster Cluster
+	symbol  string
+	ch      chan Trade
+}
+
+// Feed streams trades for whatever is being watched, one connection per cluster, and fans each out to every
+// watcher of its symbol. Watchers only ever see the latest trade: a slow one skips ahead rather than holding the
+// feed up.
+type Feed struct {
+	conns map[Cluster]*conn
+
+	mu       sync.Mutex
+	watchers map[Cluster]map[string]map[*watcher]struct{}
+	alerts   map[string]*watcher
+}
+
+// NewFeed returns a feed on the Polygon WebSocket API at baseURL, e.g. DefaultURL, authenticating with key.
+func NewFeed(baseURL, key string) *Feed {
+	f := &Feed{
+		conns:    make(map[Cluster]*conn),
+		watchers: make(map[Cluster]map[string]map[*watcher]struct{}),
+		alerts:   make(map[string]*watcher),
+	}
+	for _, c := range []Cluster{Stocks, Options, Crypto} {
+		f.conns[c] = newConn(c, baseURL, key, f.deliver)
+		f.watchers[c] = make(map[string]map[*watcher]struct{})
+	}
+	return f
+}
+
+// FeedFromConfig returns a feed on DefaultURL with the Polygon key in config.json.
+func FeedFromConfig(cfg config.StocksConfig) *Feed {
+	return NewFeed(DefaultURL, cfg.Key)
+}
+
+// Run keeps every cluster's connection up until ctx is done.
+func (f *Feed) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range f.conns {
+		wg.Add(1)
+		go func(c *conn) {
+			defer wg.Done()
+			c.run(ctx)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (f *Feed) deliver(t Trade) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for w := range f.watchers[t.Cluster][t.Symbol] {
+		select {
+		case w.ch <- t:
+		default:
+			select {
+			case <-w.ch:
+			default:
+			}
+			w.ch <- t
+		}
+	}
+}
+
+// Symbol returns the cluster and Polygon symbol trading assetType's symbol: a ticker for stocks and shorts, a coin
+// or pair for crypto, and an option symbol utils.ParseOptionSymbol understands for options.
+func Symbol(assetType, symbol string) (Cluster, string, error) {
+	switch assetType {
+	case db.AssetStock, db.AssetShort:
+		return Stocks, strings.ToUpper(strings.TrimSpace(symbol)), nil
+	case db.AssetCrypto:
+		from, to := quotes.SplitPair(symbol)
+		return Crypto, from + "-" + to, nil
+	case db.AssetOption:
+		s, err := utils.ParseOptionSymbol(symbol)
+		if err != nil {
+			return "", "", err
+		}
+		return Options, s.Polygon(), nil
+	}
+	return "", "", db.ErrInvalidInput
+}
+
+// Watch streams trades in symbol, of assetType, until ctx is done, when the channel is closed and the symbol
+// unsubscribed from if nothing else is watching it.
+func (f *Feed) Watch(ctx context.Context, assetType, symbol string) (<-chan Trade, error) {
+	cluster, sym, err := Symbol(assetType, symbol)
+	if err != nil {
+		return nil, err
+	}
+	return f.watch(ctx, "", cluster, sym), nil
+}
+
+// watch streams symbol's trades until ctx is done. Given an alert key it watches for that alert, unless ctx is
+// already being watched for it, returning that channel instead. A watch under any other context is replaced, even if
+// its cleanup hasn't run yet, as the alert has since been re-created.
+func (f *Feed) watch(ctx context.Context, alert string, cluster Cluster, symbol string) <-chan Trade {
+	w := &watcher{ctx: ctx, cluster: cluster, symbol: symbol, ch: make(chan Trade, 1)}
+
+	f.mu.Lock()
+	if old, ok := f.alerts[alert]; ok && alert != "" && old.ctx == ctx {
+		f.mu.Unlock()
+		return old.ch
+	}
+	if f.watchers[cluster][symbol] == nil {
+		f.watchers[cluster][symbol] = make(map[*watcher]struct{})
+	}
+	f.watchers[cluster][symbol][w] = struct{}{}
+	if alert != "" {
+		f.alerts[alert] = w
+	}
+	f.mu.Unlock()
+	f.conns[cluster].add(symbol)
+
+	go func() {
+		<-ctx.Done()
+
+		f.mu.Lock()
+		delete(f.watchers[cluster][symbol], w)
+		if len(f.watchers[cluster][symbol]) == 0 {
+			delete(f.watchers[cluster], symbol)
+		}
+		if alert != "" && f.alerts[alert] == w {
+			delete(f.alerts, alert)
+		}
+		close(w.ch)
+		f.mu.Unlock()
+		f.conns[cluster].remove(symbol)
+	}()
+
+	return w.ch
+}
+
+func alertKey(guild, id string) string {
+	return guild + "/" + id
+}
+
+// WatchAlerts starts watching every one of the guild's alerts that has a monitor in reg and isn't watched yet, each
+// for as long as its monitor runs. Call it with GetAll's results whenever alerts are created; removing an alert
+// stops its monitor and so its watch. Trades hands each monitor its channel.
+func (f *Feed) WatchAlerts(reg *db.AlertRegistry, guild string, stocks []*db.Stock, shorts []*db.Short, cryptos []*db.Crypto, options []*db.Option) {
+	watch := func(id, assetType, symbol string) {
+		ctx, ok := reg.Lookup(guild, id)
+		if !ok {
+			return
+		}
+
+		cluster, sym, err := Symbol(assetType, symbol)
+		if err != nil {
+			return
+		}
+		f.watch(ctx, alertKey(guild, id), cluster, sym)
+	}
+
+	for _, v := range stocks {
+		watch(v.StockAlertID, db.AssetStock, v.StockTicker)
+	}
+	for _, v := range shorts {
+		watch(v.ShortAlertID, db.AssetShort, v.ShortTicker)
+	}
+	for _, v := range cryptos {
+		watch(v.CryptoAlertID, db.AssetCrypto, v.CryptoCoin)
+	}
+	for _, v := range options {
+		if s, err := v.Symbol(); err == nil {
+			watch(v.OptionAlertID, db.AssetOption, s.String())
+		}
+	}
+}
+
+// Trades returns the trades of the guild's alert id, if WatchAlerts is watching it.
+func (f *Feed) Trades(guild, id string) (<-chan Trade, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w, ok := f.alerts[alertKey(guild, id)]
+	if !ok || w.ctx.Err() != nil {
+		return nil, false
+	}
+	return w.ch, true
+}
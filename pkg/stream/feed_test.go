@@ -0,0 +1,292 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package stream
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/db"
+)
+
+const testKey = "test-key"
+
+// running starts the stocks connection of a feed on s, reconnecting quickly, and returns the server end of it once
+// it has sent its key. The other clusters are left down.
+func running(t *testing.T, s *server) (*Feed, *peer) {
+	t.Helper()
+
+	f := NewFeed(s.url, testKey)
+	c := f.conns[Stocks]
+	c.backoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return f, stocks(t, s)
+}
+
+// stocks waits for the next stocks connection and checks the key it authenticates with.
+func stocks(t *testing.T, s *server) *peer {
+	t.Helper()
+
+	p := s.next(t)
+	if p.path != "/stocks" {
+		t.Fatalf("connected to %v, want /stocks", p.path)
+	}
+	if key := p.expect("auth"); key != testKey {
+		t.Errorf("authenticated with %q, want %q", key, testKey)
+	}
+	return p
+}
+
+// channels splits subscribe or unsubscribe params into sorted channels.
+func channels(params string) []string {
+	cs := strings.Split(params, ",")
+	sort.Strings(cs)
+	return cs
+}
+
+// wants waits until the feed wants symbol from n watchers.
+func wants(t *testing.T, f *Feed, symbol string, n int) {
+	t.Helper()
+
+	c := f.conns[Stocks]
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		got := c.subs[symbol]
+		c.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v is wanted by %d watchers, want %d", symbol, got, n)
+		}
+	}
+}
+
+func receive(t *testing.T, ch <-chan Trade) (Trade, bool) {
+	t.Helper()
+	select {
+	case trade, ok := <-ch:
+		return trade, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing arrived")
+		return Trade{}, false
+	}
+}
+
+func TestAuthFailed(t *testing.T) {
+	s := serve(t, acceptKey)
+	c := newConn(Stocks, s.url, "bad-key", func(Trade) {})
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.session(context.Background()) }()
+
+	p := s.next(t)
+	if key := p.expect("auth"); key != "bad-key" {
+		t.Errorf("authenticated with %q", key)
+	}
+	p.send(`[{"ev":"status","status":"auth_failed","message":"authentication failed"}]`)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("session = %v, want %v", err, ErrAuthFailed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session carried on after auth_failed")
+	}
+}
+
+func TestResubscribeAfterDrop(t *testing.T) {
+	s := serve(t, acceptKey)
+	f, p := running(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	aapl, err := f.Watch(ctx, db.AssetStock, "aapl")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	_, err = f.Watch(ctx, db.AssetShort, "MSFT")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	wants(t, f, "AAPL", 1)
+	wants(t, f, "MSFT", 1)
+
+	p.send(`[{"ev":"status","status":"connected"},{"ev":"status","status":"auth_success"}]`)
+	if got := channels(p.expect("subscribe")); strings.Join(got, ",") != "T.AAPL,T.MSFT" {
+		t.Errorf("subscribed to %v after authenticating", got)
+	}
+	p.send(`[{"ev":"T","sym":"AAPL","p":150.5,"s":10,"t":1792000000000}]`)
+	if trade, _ := receive(t, aapl); trade.Price != 150.5 || trade.Symbol != "AAPL" || trade.Cluster != Stocks {
+		t.Errorf("got trade %+v", trade)
+	}
+
+	p.ws.conn.Close()
+	p = stocks(t, s)
+	p.send(`[{"ev":"status","status":"auth_success"}]`)
+	if got := channels(p.expect("subscribe")); strings.Join(got, ",") != "T.AAPL,T.MSFT" {
+		t.Errorf("resubscribed to %v after reconnecting", got)
+	}
+	p.send(`[{"ev":"T","sym":"AAPL","p":151,"s":1,"t":1792000001000}]`)
+	if trade, _ := receive(t, aapl); trade.Price != 151 {
+		t.Errorf("got trade %+v after reconnecting", trade)
+	}
+}
+
+func TestWatchUnsubscribesAfterLastWatcher(t *testing.T) {
+	s := serve(t, acceptKey)
+	f, p := running(t, s)
+	p.send(`[{"ev":"status","status":"auth_success"}]`)
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+
+	a, err := f.Watch(first, db.AssetStock, "AAPL")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got := p.expect("subscribe"); got != "T.AAPL" {
+		t.Errorf("subscribed to %v, want T.AAPL", got)
+	}
+	b, err := f.Watch(second, db.AssetStock, "AAPL")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	wants(t, f, "AAPL", 2)
+
+	p.send(`[{"ev":"T","sym":"AAPL","p":150,"s":1,"t":1792000000000}]`)
+	for _, ch := range []<-chan Trade{a, b} {
+		if trade, _ := receive(t, ch); trade.Price != 150 {
+			t.Errorf("got trade %+v", trade)
+		}
+	}
+
+	// The first watcher going must not unsubscribe; if it did, that would be the next thing the server reads,
+	// ahead of the MSFT subscribe.
+	cancelFirst()
+	if _, ok := receive(t, a); ok {
+		t.Error("the cancelled watcher's channel is still open")
+	}
+	wants(t, f, "AAPL", 1)
+	if _, err = f.Watch(second, db.AssetStock, "MSFT"); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got := p.expect("subscribe"); got != "T.MSFT" {
+		t.Errorf("subscribed to %v, want T.MSFT", got)
+	}
+
+	cancelSecond()
+	got := []string{p.expect("unsubscribe"), p.expect("unsubscribe")}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "T.AAPL,T.MSFT" {
+		t.Errorf("unsubscribed from %v once the last watchers went", got)
+	}
+	if _, ok := receive(t, b); ok {
+		t.Error("the last watcher's channel is still open")
+	}
+}
+
+func TestWatchAlertsOncePerMonitor(t *testing.T) {
+	f := NewFeed("ws://127.0.0.1:0", testKey)
+	reg := db.NewAlertRegistry()
+	reg.Open("g", "a")
+	stocks := []*db.Stock{{StockAlertID: "a", StockTicker: "AAPL"}}
+
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			f.WatchAlerts(reg, "g", stocks, nil, nil, nil)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+
+	f.mu.Lock()
+	n := len(f.watchers[Stocks]["AAPL"])
+	f.mu.Unlock()
+	if n != 1 {
+		t.Errorf("concurrent WatchAlerts left %v watchers on the alert, want 1", n)
+	}
+
+	reg.Cancel("g", "a")
+	wantsNone := func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_, ok := f.alerts[alertKey("g", "a")]
+		return !ok
+	}
+	for deadline := time.Now().Add(5 * time.Second); !wantsNone(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the watch outlived the alert's monitor")
+		}
+	}
+}
+
+func TestWatchAlertsReplacesEndedWatch(t *testing.T) {
+	f := NewFeed("ws://127.0.0.1:0", testKey)
+	reg := db.NewAlertRegistry()
+	stocks := []*db.Stock{{StockAlertID: "a", StockTicker: "AAPL"}}
+
+	// A watch from the alert's previous monitor whose cleanup hasn't run yet.
+	ended, cancel := context.WithCancel(context.Background())
+	cancel()
+	stale := &watcher{ctx: ended, cluster: Stocks, symbol: "AAPL", ch: make(chan Trade, 1)}
+	f.alerts[alertKey("g", "a")] = stale
+
+	if _, ok := f.Trades("g", "a"); ok {
+		t.Error("Trades handed out the ended watch")
+	}
+
+	reg.Open("g", "a")
+	f.WatchAlerts(reg, "g", stocks, nil, nil, nil)
+
+	f.mu.Lock()
+	w := f.alerts[alertKey("g", "a")]
+	f.mu.Unlock()
+	if w == stale {
+		t.Fatal("the re-created alert is left with its previous monitor's ended watch")
+	}
+	ch, ok := f.Trades("g", "a")
+	if !ok || ch != (<-chan Trade)(w.ch) {
+		t.Error("Trades doesn't hand out the re-created alert's watch")
+	}
+
+	f.deliver(Trade{Cluster: Stocks, Symbol: "AAPL", Price: 150})
+	if trade, _ := receive(t, ch); trade.Price != 150 {
+		t.Errorf("got trade %+v", trade)
+	}
+	reg.Cancel("g", "a")
+}
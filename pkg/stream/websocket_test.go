@@ -0,0 +1,238 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// peer is the server end of one connection to a stand-in for Polygon's WebSocket API.
+type peer struct {
+	t    *testing.T
+	path string
+	ws   *wsConn
+}
+
+// server is the stand-in. Every connection that completes the handshake is handed to the test on peers.
+type server struct {
+	url   string
+	peers chan *peer
+}
+
+// serve starts a stand-in answering the handshake with accept, which is given the client's key.
+func serve(t *testing.T, accept func(key string) string) *server {
+	t.Helper()
+
+	s := &server{peers: make(chan *peer, 8)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%v was not a websocket handshake: %v", r.URL.Path, r.Header)
+			http.Error(w, "not a websocket", http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n",
+			accept(r.Header.Get("Sec-WebSocket-Key")))
+		if err = rw.Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+			conn.Close()
+			return
+		}
+
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		s.peers <- &peer{t: t, path: r.URL.Path, ws: &wsConn{conn: conn, r: rw.Reader}}
+	}))
+	t.Cleanup(srv.Close)
+
+	s.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return s
+}
+
+// next waits for the next connection to the stand-in.
+func (s *server) next(t *testing.T) *peer {
+	t.Helper()
+	select {
+	case p := <-s.peers:
+		t.Cleanup(func() { p.ws.conn.Close() })
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing connected")
+		return nil
+	}
+}
+
+// frame writes one unmasked frame, as servers send them.
+func (p *peer) frame(fin bool, op byte, payload []byte) {
+	p.t.Helper()
+
+	head := []byte{op}
+	if fin {
+		head[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	if _, err := p.ws.conn.Write(append(head, payload...)); err != nil {
+		p.t.Errorf("writing a frame: %v", err)
+	}
+}
+
+// send writes msg as one text message.
+func (p *peer) send(msg string) {
+	p.t.Helper()
+	p.frame(true, opText, []byte(msg))
+}
+
+// expect reads the client's next message, which must be action, and returns its params.
+func (p *peer) expect(action string) string {
+	p.t.Helper()
+
+	msg, err := p.ws.ReadMessage()
+	if err != nil {
+		p.t.Fatalf("waiting for %v: %v", action, err)
+	}
+	var c control
+	if err = json.Unmarshal(msg, &c); err != nil {
+		p.t.Fatalf("waiting for %v got %q: %v", action, msg, err)
+	}
+	if c.Action != action {
+		p.t.Fatalf("got %v %q, want %v", c.Action, c.Params, action)
+	}
+	return c.Params
+}
+
+func dialed(t *testing.T, s *server) (*wsConn, *peer) {
+	t.Helper()
+
+	ws, err := dial(context.Background(), s.url+"/stocks")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws, s.next(t)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	if got, want := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("acceptKey = %q, want %q", got, want)
+	}
+}
+
+func TestDialChecksAcceptKey(t *testing.T) {
+	s := serve(t, func(key string) string {
+		return acceptKey("not " + key)
+	})
+
+	if ws, err := dial(context.Background(), s.url+"/stocks"); err == nil {
+		ws.Close()
+		t.Error("dial accepted a handshake with the wrong Sec-WebSocket-Accept")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	s := serve(t, acceptKey)
+
+	t.Run("fragmented, with control frames between", func(t *testing.T) {
+		ws, p := dialed(t, s)
+
+		p.frame(false, opText, []byte(`[{"ev":`))
+		p.frame(true, opPing, []byte("are you there"))
+		p.frame(false, opContinuation, []byte(`"T","sym":`))
+		p.frame(true, opPong, nil)
+		p.frame(true, opContinuation, []byte(`"AAPL"}]`))
+
+		msg, err := ws.ReadMessage()
+		if err != nil || string(msg) != `[{"ev":"T","sym":"AAPL"}]` {
+			t.Errorf("ReadMessage = %q, %v", msg, err)
+		}
+
+		fin, op, payload, err := p.ws.readFrame()
+		if err != nil || !fin || op != opPong || string(payload) != "are you there" {
+			t.Errorf("the ping was answered with %v %v %q, %v", fin, op, payload, err)
+		}
+	})
+
+	t.Run("oversized frame", func(t *testing.T) {
+		ws, p := dialed(t, s)
+
+		head := []byte{0x80 | opText, 127, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(head[2:], maxMessage+1)
+		if _, err := p.ws.conn.Write(head); err != nil {
+			t.Fatalf("writing the header: %v", err)
+		}
+
+		// Nothing follows the header, so a client that tried to read the payload would hang until the deadline.
+		ws.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := ws.ReadMessage()
+		if err == nil || !strings.Contains(err.Error(), "too large") {
+			t.Errorf("ReadMessage = %v, want the frame refused as too large", err)
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			t.Error("ReadMessage waited for the payload instead of refusing the frame")
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		ws, p := dialed(t, s)
+
+		p.frame(true, opClose, []byte{0x03, 0xE9})
+		if _, err := ws.ReadMessage(); !errors.Is(err, ErrClosed) {
+			t.Errorf("ReadMessage = %v, want %v", err, ErrClosed)
+		}
+		_, op, payload, err := p.ws.readFrame()
+		if err != nil || op != opClose || string(payload) != "\x03\xE9" {
+			t.Errorf("the close was answered with %v %q, %v", op, payload, err)
+		}
+	})
+
+	t.Run("client frames are masked", func(t *testing.T) {
+		ws, p := dialed(t, s)
+
+		if err := ws.WriteText([]byte("hello")); err != nil {
+			t.Fatalf("WriteText: %v", err)
+		}
+		var head [2]byte
+		if _, err := p.ws.r.Read(head[:]); err != nil {
+			t.Fatalf("reading the header: %v", err)
+		}
+		if head[1]&0x80 == 0 {
+			t.Error("the client sent an unmasked frame")
+		}
+	})
+}
@@ -131,3 +131,65 @@ type Snapshot struct {
 	} `json:"results"`
 	Status string `json:"status"`
 }
+
+type LastCryptoTradeResponse struct {
+	RequestID string `json:"request_id"`
+	Last      struct {
+		Conditions []int   `json:"conditions"`
+		Exchange   int     `json:"exchange"`
+		Price      float64 `json:"price"`
+		Size       float64 `json:"size"`
+		Timestamp  int64   `json:"timestamp"`
+	} `json:"last"`
+	Status string `json:"status"`
+	Symbol string `json:"symbol"`
+}
+
+type UniversalSnapshotResponse struct {
+	RequestID string              `json:"request_id"`
+	NextURL   string              `json:"next_url"`
+	Results   []UniversalSnapshot `json:"results"`
+	Status    string              `json:"status"`
+}
+
+type UniversalSnapshot struct {
+	Ticker       string `json:"ticker"`
+	Type         string `json:"type"`
+	MarketStatus string `json:"market_status"`
+	Error        string `json:"error"`
+	Message      string `json:"message"`
+	Session      struct {
+		Change        float64 `json:"change"`
+		ChangePercent float64 `json:"change_percent"`
+		Close         float64 `json:"close"`
+		High          float64 `json:"high"`
+		Low           float64 `json:"low"`
+		Open          float64 `json:"open"`
+		PreviousClose float64 `json:"previous_close"`
+		Price         float64 `json:"price"`
+		Volume        float64 `json:"volume"`
+	} `json:"session"`
+	LastTrade struct {
+		Price        float64 `json:"price"`
+		Size         float64 `json:"size"`
+		SipTimestamp int64   `json:"sip_timestamp"`
+	} `json:"last_trade"`
+	LastQuote struct {
+		Ask         float64 `json:"ask"`
+		Bid         float64 `json:"bid"`
+		LastUpdated int64   `json:"last_updated"`
+		Midpoint    float64 `json:"midpoint"`
+	} `json:"last_quote"`
+	Greeks struct {
+		Delta float64 `json:"delta"`
+		Gamma float64 `json:"gamma"`
+		Theta float64 `json:"theta"`
+		Vega  float64 `json:"vega"`
+	} `json:"greeks"`
+	ImpliedVolatility float64 `json:"implied_volatility"`
+	OpenInterest      int     `json:"open_interest"`
+	UnderlyingAsset   struct {
+		Price  float64 `json:"price"`
+		Ticker string  `json:"ticker"`
+	} `json:"underlying_asset"`
+}
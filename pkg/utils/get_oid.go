@@ -2,24 +2,16 @@ package utils
 
 import (
 	"fmt"
-	"strings"
+	"log"
 )
 
+// GetCode returns the compact OCC symbol for an option, or "" if the pieces don't make one.
 func GetCode(ticker, contractType, day, month, year string, price float32) string {
-	code := ""
-	code += strings.ToUpper(ticker)
-	code += year[2:]
-	code += month
-	code += day
-
-	if len(contractType) > 1 {
-		code += strings.ToUpper(contractType[:1])
-	} else {
-		code += strings.ToUpper(contractType)
+	symbol, err := NewOptionSymbol(ticker, contractType, day, month, year, float64(price))
+	if err != nil {
+		log.Println(fmt.Sprintf("Unable to build option code : %v", err.Error()))
+		return ""
 	}
-	price *= 1000
-
-	code += fmt.Sprintf("%08d", int(price)) // problem?
 
-	return code
+	return symbol.String()
 }
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSymbol = errors.New("INVALID OPTION SYMBOL")
+
+// OptionSymbol is an OCC option symbol: root, expiration, call or put, and strike. The strike is kept in thousandths
+// of a dollar, exactly as OCC encodes it, so parsing and formatting round-trip without float rounding.
+type OptionSymbol struct {
+	Root       string
+	Expiration time.Time // midnight UTC on the expiration date
+	Put        bool
+	Strike     int64
+}
+
+const (
+	maxRootLen    = 6
+	symbolTailLen = 15 // YYMMDD, C or P, and an eight digit strike
+	polygonPrefix = "O:"
+)
+
+func symbolErr(format string, args ...interface{}) error {
+	return fmt.Errorf("%v : %w", fmt.Sprintf(format, args...), ErrInvalidSymbol)
+}
+
+// ParseOptionSymbol reads a compact symbol such as AAPL261218C00150000, the same with Polygon's O: prefix, or the
+// 21 character OCC form with the root padded to six characters by spaces.
+func ParseOptionSymbol(in string) (OptionSymbol, error) {
+	code := strings.TrimPrefix(strings.TrimSpace(in), polygonPrefix)
+	if len(code) <= symbolTailLen {
+		return OptionSymbol{}, symbolErr("%q is too short", in)
+	}
+
+	split := len(code) - symbolTailLen
+	root, tail := strings.TrimRight(code[:split], " "), code[split:]
+	if err := checkRoot(root); err != nil {
+		return OptionSymbol{}, symbolErr("%q: %v", in, err)
+	}
+
+	expiration, err := time.Parse("060102", tail[:6])
+	if err != nil {
+		return OptionSymbol{}, symbolErr("%q has an invalid expiration %v", in, tail[:6])
+	}
+
+	var put bool
+	switch tail[6] {
+	case 'C':
+	case 'P':
+		put = true
+	default:
+		return OptionSymbol{}, symbolErr("%q is neither a call nor a put", in)
+	}
+
+	strike, err := strconv.ParseInt(tail[7:], 10, 64)
+	if err != nil || strings.ContainsAny(tail[7:], "+-") {
+		return OptionSymbol{}, symbolErr("%q has an invalid strike %v", in, tail[7:])
+	}
+
+	return OptionSymbol{Root: root, Expiration: expiration, Put: put, Strike: strike}, nil
+}
+
+func checkRoot(root string) error {
+	if root == "" || len(root) > maxRootLen {
+		return fmt.Errorf("root %q must be 1 to %v characters", root, maxRootLen)
+	}
+	for _, r := range root {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return fmt.Errorf("root %q may only hold capital letters and digits", root)
+		}
+	}
+	return nil
+}
+
+// NewOptionSymbol builds a symbol from the pieces Kronos stores alerts as. contractType only needs to start with
+// C or P, year may have two or four digits, and strike is rounded to the nearest tenth of a cent.
+func NewOptionSymbol(ticker, contractType, day, month, year string, strike float64) (OptionSymbol, error) {
+	root := strings.ToUpper(strings.TrimSpace(ticker))
+	if err := checkRoot(root); err != nil {
+		return OptionSymbol{}, symbolErr("%v", err)
+	}
+
+	y, yErr := strconv.Atoi(year)
+	m, mErr := strconv.Atoi(month)
+	d, dErr := strconv.Atoi(day)
+	if yErr != nil || mErr != nil || dErr != nil {
+		return OptionSymbol{}, symbolErr("invalid expiration %v/%v/%v", month, day, year)
+	}
+	if y < 100 {
+		y += 2000
+	}
+	expiration := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if expiration.Year() != y || expiration.Month() != time.Month(m) || expiration.Day() != d || y > 2099 {
+		return OptionSymbol{}, symbolErr("invalid expiration %v/%v/%v", month, day, year)
+	}
+
+	var put bool
+	switch strings.ToUpper(strings.TrimSpace(contractType) + " ")[0] {
+	case 'C':
+	case 'P':
+		put = true
+	default:
+		return OptionSymbol{}, symbolErr("contract type %q is neither a call nor a put", contractType)
+	}
+
+	millis := math.Round(strike * 1000)
+	if millis <= 0 || millis > 99999999 {
+		return OptionSymbol{}, symbolErr("strike %v is out of range", strike)
+	}
+
+	return OptionSymbol{Root: root, Expiration: expiration, Put: put, Strike: int64(millis)}, nil
+}
+
+// ContractType is "P" for puts and "C" for calls.
+func (o OptionSymbol) ContractType() string {
+	if o.Put {
+		return "P"
+	}
+	return "C"
+}
+
+// StrikePrice is the strike in dollars.
+func (o OptionSymbol) StrikePrice() float64 {
+	return float64(o.Strike) / 1000
+}
+
+// Date returns the expiration as the zero padded day, month and four digit year Kronos stores.
+func (o OptionSymbol) Date() (day, month, year string) {
+	return o.Expiration.Format("02"), o.Expiration.Format("01"), o.Expiration.Format("2006")
+}
+
+// String is the compact symbol, e.g. AAPL261218C00150000.
+func (o OptionSymbol) String() string {
+	return fmt.Sprintf("%v%v%v%08d", o.Root, o.Expiration.Format("060102"), o.ContractType(), o.Strike)
+}
+
+// Polygon is the symbol as Polygon's API expects it, e.g. O:AAPL261218C00150000.
+func (o OptionSymbol) Polygon() string {
+	return polygonPrefix + o.String()
+}
+
+// OCC is the 21 character OCC symbol, with the root padded to six characters, e.g. "AAPL  261218C00150000".
+func (o OptionSymbol) OCC() string {
+	return fmt.Sprintf("%-6v%v%v%08d", o.Root, o.Expiration.Format("060102"), o.ContractType(), o.Strike)
+}
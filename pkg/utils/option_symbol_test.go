@@ -0,0 +1,127 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOptionSymbolRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		compact string
+		occ     string
+		root    string
+		expires time.Time
+		put     bool
+		strike  int64
+		price   float64
+	}{
+		{"AAPL261218C00150000", "AAPL  261218C00150000", "AAPL", time.Date(2026, 12, 18, 0, 0, 0, 0, time.UTC), false, 150000, 150},
+		{"SPY270115P00412500", "SPY   270115P00412500", "SPY", time.Date(2027, 1, 15, 0, 0, 0, 0, time.UTC), true, 412500, 412.5},
+		{"F261120C00012125", "F     261120C00012125", "F", time.Date(2026, 11, 20, 0, 0, 0, 0, time.UTC), false, 12125, 12.125},
+		{"NVDA261218C00000500", "NVDA  261218C00000500", "NVDA", time.Date(2026, 12, 18, 0, 0, 0, 0, time.UTC), false, 500, 0.5},
+		{"BRKB261218P12345000", "BRKB  261218P12345000", "BRKB", time.Date(2026, 12, 18, 0, 0, 0, 0, time.UTC), true, 12345000, 12345},
+		{"GOOGL1261218C01500000", "GOOGL1261218C01500000", "GOOGL1", time.Date(2026, 12, 18, 0, 0, 0, 0, time.UTC), false, 1500000, 1500},
+		{"SPXW280229P05000000", "SPXW  280229P05000000", "SPXW", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), true, 5000000, 5000},
+	} {
+		for _, in := range []string{c.compact, "O:" + c.compact, c.occ, " " + c.compact + " "} {
+			s, err := ParseOptionSymbol(in)
+			if err != nil {
+				t.Errorf("ParseOptionSymbol(%q): %v", in, err)
+				continue
+			}
+			if s.Root != c.root || !s.Expiration.Equal(c.expires) || s.Put != c.put || s.Strike != c.strike {
+				t.Errorf("ParseOptionSymbol(%q) = %+v", in, s)
+			}
+			if s.StrikePrice() != c.price {
+				t.Errorf("ParseOptionSymbol(%q).StrikePrice() = %v, want %v", in, s.StrikePrice(), c.price)
+			}
+			if s.String() != c.compact {
+				t.Errorf("ParseOptionSymbol(%q).String() = %q, want %q", in, s.String(), c.compact)
+			}
+			if s.Polygon() != "O:"+c.compact {
+				t.Errorf("ParseOptionSymbol(%q).Polygon() = %q, want %q", in, s.Polygon(), "O:"+c.compact)
+			}
+			if s.OCC() != c.occ || len(s.OCC()) != 21 {
+				t.Errorf("ParseOptionSymbol(%q).OCC() = %q, want %q", in, s.OCC(), c.occ)
+			}
+		}
+
+		day, month, year := c.expires.Format("02"), c.expires.Format("01"), c.expires.Format("2006")
+		built, err := NewOptionSymbol(c.root, map[bool]string{false: "call", true: "put"}[c.put], day, month, year[2:], c.price)
+		if err != nil || built.String() != c.compact {
+			t.Errorf("NewOptionSymbol for %v = %v, %v", c.compact, built, err)
+		}
+		if d, m, y := built.Date(); d != day || m != month || y != year {
+			t.Errorf("%v.Date() = %v, %v, %v", c.compact, d, m, y)
+		}
+	}
+}
+
+func TestParseOptionSymbolRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"261218C00150000",        // no root
+		"O:",                     // nothing after the prefix
+		"AAPL261218C0015000",     // seven digit strike
+		"aapl261218C00150000",    // lower case root
+		"TOOLONG261218C00150000", // seven character root
+		"AAPL261318C00150000",    // month 13
+		"AAPL260230C00150000",    // February 30th
+		"AAPL270229P00150000",    // not a leap year
+		"AAPL2612x8C00150000",    // not a date
+		"AAPL261218X00150000",    // neither call nor put
+		"AAPL261218c00150000",    // lower case side
+		"AAPL261218C+0150000",    // signed strike
+		"AAPL261218C0015000A",    // strike isn't a number
+	} {
+		if s, err := ParseOptionSymbol(in); !errors.Is(err, ErrInvalidSymbol) {
+			t.Errorf("ParseOptionSymbol(%q) = %+v, %v, want %v", in, s, err, ErrInvalidSymbol)
+		}
+	}
+}
+
+func TestNewOptionSymbolRejects(t *testing.T) {
+	for _, c := range []struct {
+		name                           string
+		ticker, side, day, month, year string
+		strike                         float64
+	}{
+		{"no ticker", "", "C", "18", "12", "2026", 150},
+		{"bad side", "AAPL", "X", "18", "12", "2026", 150},
+		{"no side", "AAPL", "", "18", "12", "2026", 150},
+		{"day 32", "AAPL", "C", "32", "12", "2026", 150},
+		{"month 13", "AAPL", "C", "18", "13", "2026", 150},
+		{"february 29th off a leap year", "AAPL", "P", "29", "02", "27", 150},
+		{"year past 2099", "AAPL", "C", "18", "12", "2100", 150},
+		{"day isn't a number", "AAPL", "C", "1st", "12", "2026", 150},
+		{"zero strike", "AAPL", "C", "18", "12", "2026", 0},
+		{"strike too large", "AAPL", "C", "18", "12", "2026", 100000},
+	} {
+		if s, err := NewOptionSymbol(c.ticker, c.side, c.day, c.month, c.year, c.strike); !errors.Is(err, ErrInvalidSymbol) {
+			t.Errorf("%v: NewOptionSymbol = %+v, %v, want %v", c.name, s, err, ErrInvalidSymbol)
+		}
+	}
+}
+
+func TestNewOptionSymbolRoundsStrike(t *testing.T) {
+	s, err := NewOptionSymbol("aapl", "c", "18", "12", "2026", 0.1+0.2)
+	if err != nil || s.Strike != 300 || s.String() != "AAPL261218C00000300" {
+		t.Errorf("NewOptionSymbol with a strike of 0.1+0.2 = %+v, %v, want 300 thousandths", s, err)
+	}
+}
@@ -22,10 +22,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/m1k8/harpe/pkg/clock"
 	"github.com/uniplaces/carbon"
 )
 
+// ParseDate reads an M/D or M/D/YY expiration date, taking the current year when none is given.
 func ParseDate(in string) (m, d, y string, err error) {
+	return ParseDateWith(in, clock.System())
+}
+
+// ParseDateWith is ParseDate with the current year told by c. A nil c is the real clock.
+func ParseDateWith(in string, c clock.Clock) (m, d, y string, err error) {
+	return ParseDateAt(in, clock.OrSystem(c).Now())
+}
+
+// ParseDateAt is ParseDate with now deciding the year.
+func ParseDateAt(in string, now time.Time) (m, d, y string, err error) {
 	strs := strings.Split(in, "/")
 
 	defer func() {
@@ -50,7 +62,7 @@ func ParseDate(in string) (m, d, y string, err error) {
 	if len(strs) == 3 {
 		y = "20" + strs[2]
 	} else {
-		nowC := carbon.NewCarbon(time.Now()).Year()
+		nowC := carbon.NewCarbon(now).Year()
 		y = fmt.Sprintf("%v", nowC)
 	}
 
@@ -19,22 +19,30 @@ import (
 	"log"
 	"time"
 
-	"github.com/uniplaces/carbon"
+	"github.com/m1k8/harpe/pkg/clock"
+	"github.com/m1k8/harpe/pkg/market"
 )
 
+// calendar is the NYSE calendar the time to open is counted on.
+var calendar = market.NYSE()
+
+// GetTimeToOpen is how long until NYSE's next regular open, or 0 while it is open.
 func GetTimeToOpen() time.Duration {
-	now, _ := carbon.NowInLocation("America/Detroit")
-	var timeTillOpen time.Duration
+	return GetTimeToOpenWith(clock.System())
+}
 
-	if now.Hour() <= 9 {
-		timeTillOpen = now.StartOfDay().Add(9*time.Hour + 30*time.Minute).Sub(now.Time)
-	} else {
-		timeTillOpen = now.NextWeekday().StartOfDay().Sub(now.Time) + 9*time.Hour + 29*time.Minute
-	}
+// GetTimeToOpenWith is GetTimeToOpen telling the time with c. A nil c is the real clock.
+func GetTimeToOpenWith(c clock.Clock) time.Duration {
+	return GetTimeToOpenAt(clock.OrSystem(c).Now())
+}
 
-	if timeTillOpen <= 0 {
+// GetTimeToOpenAt is GetTimeToOpen as of now.
+func GetTimeToOpenAt(now time.Time) time.Duration {
+	if calendar.IsOpen(now) {
 		return 0
 	}
+
+	timeTillOpen := calendar.NextOpen(now).Sub(now)
 	log.Println("Time till open: " + timeTillOpen.String())
 	return timeTillOpen
 }
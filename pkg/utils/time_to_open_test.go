@@ -0,0 +1,57 @@
+/*
+ * Copyright 2022 M1K
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m1k8/harpe/pkg/clock"
+)
+
+func TestGetTimeToOpen(t *testing.T) {
+	ny := calendar.Location()
+	fake := clock.NewFake(time.Date(2026, time.October, 16, 16, 0, 0, 0, ny))
+
+	// Friday's close to Monday's open.
+	if got, want := GetTimeToOpenWith(fake), 65*time.Hour+30*time.Minute; got != want {
+		t.Errorf("after Friday's close GetTimeToOpenWith = %v, want %v", got, want)
+	}
+
+	fake.Set(time.Date(2026, time.November, 25, 20, 0, 0, 0, ny))
+	if got, want := GetTimeToOpenWith(fake), 37*time.Hour+30*time.Minute; got != want {
+		t.Errorf("the evening before Thanksgiving GetTimeToOpenWith = %v, want %v", got, want)
+	}
+
+	fake.Set(time.Date(2026, time.October, 19, 9, 30, 0, 0, ny))
+	if got := GetTimeToOpenWith(fake); got != 0 {
+		t.Errorf("at the open GetTimeToOpenWith = %v, want 0", got)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	fake := clock.NewFake(time.Date(2027, time.January, 4, 12, 0, 0, 0, time.UTC))
+
+	m, d, y, err := ParseDateWith("3/5", fake)
+	if err != nil || m != "03" || d != "05" || y != "2027" {
+		t.Errorf(`ParseDateWith("3/5") = %v, %v, %v, %v, want 03, 05, 2027 by the clock`, m, d, y, err)
+	}
+
+	m, d, y, err = ParseDateWith("12/17/26", fake)
+	if err != nil || m != "12" || d != "17" || y != "2026" {
+		t.Errorf(`ParseDateWith("12/17/26") = %v, %v, %v, %v, want 12, 17, 2026`, m, d, y, err)
+	}
+}